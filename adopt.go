@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// vaultAttachment is a diary photo found in the target photo path.
+type vaultAttachment struct {
+	path string
+	name string
+	date string
+}
+
+// guardFirstRun keeps the first import with an empty state database from
+// writing into a vault that already has attachments and notes, as happens
+// when the vault is synced to a new machine. The photos still in the
+// source folders would be imported a second time. With adopt the
+// attachments are recorded in the state first, so they are skipped.
+func guardFirstRun(settings *appSettings, adopt bool) {
+	if settings.StatePath == "" {
+		if adopt {
+			log.Fatal("--adopt-existing needs state_path")
+		}
+		return
+	}
+	empty, err := stateIsEmpty(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !empty {
+		if adopt {
+			logInfof("the state database already has records, nothing to adopt")
+		}
+		return
+	}
+
+	attachments, err := vaultAttachments(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(attachments) == 0 || !dirExists(settings.ObsidianFilePath) {
+		return
+	}
+	notes, err := noteFiles(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(notes) == 0 {
+		return
+	}
+	if !adopt {
+		log.Fatalf("the state database %s is empty but the vault already has %d attachments and %d notes, run once with --adopt-existing to record them before importing", settings.StatePath, len(attachments), len(notes))
+	}
+
+	if err := adoptAttachments(attachments, settings); err != nil {
+		log.Fatalf("unable to adopt the attachments in the vault: %s", err)
+	}
+	logInfof("adopted %d attachments already in the vault", len(attachments))
+}
+
+// stateIsEmpty tells whether no photo was recorded yet, without creating
+// the database.
+func stateIsEmpty(settings *appSettings) (bool, error) {
+	if !fileExists(settings.StatePath) {
+		return true, nil
+	}
+	db, err := openState(settings)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	empty := true
+	db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(processedBucket); bucket != nil {
+			key, _ := bucket.Cursor().First()
+			empty = key == nil
+		}
+		return nil
+	})
+	return empty, nil
+}
+
+// vaultAttachments lists the diary photos in the target photo paths, the
+// files with the image prefix and a dated name. Sidecars, montages and
+// contact sheets are left out.
+func vaultAttachments(settings *appSettings) ([]vaultAttachment, error) {
+	attachments := make([]vaultAttachment, 0)
+	seen := make(map[string]bool)
+	for _, folder := range sourceFolders(settings) {
+		if seen[folder.TargetPhotoPath] {
+			continue
+		}
+		seen[folder.TargetPhotoPath] = true
+
+		files, err := os.ReadDir(folder.TargetPhotoPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read path %s: %v", folder.TargetPhotoPath, err)
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasPrefix(file.Name(), folder.ImagePrefix) {
+				continue
+			}
+			original := strings.TrimSuffix(strings.TrimPrefix(file.Name(), folder.ImagePrefix), encryptedExtension)
+			date, ok := getDateFromFile(original)
+			if !ok {
+				continue
+			}
+			attachments = append(attachments, vaultAttachment{path.Join(folder.TargetPhotoPath, file.Name()), original, date})
+		}
+	}
+	return attachments, nil
+}
+
+// adoptAttachments records the attachments as imported, by the hash of
+// their content and their modification time. Encrypted attachments are
+// recorded by the hash of the encrypted file, which keeps the state from
+// being empty but doesn't match their photos.
+func adoptAttachments(attachments []vaultAttachment, settings *appSettings) error {
+	records := make([]processedFile, 0, len(attachments))
+	for _, attachment := range attachments {
+		hash, err := fileSHA256(attachment.path)
+		if err != nil {
+			return fmt.Errorf("unable to hash %s: %v", attachment.path, err)
+		}
+		info, err := os.Stat(attachment.path)
+		if err != nil {
+			return fmt.Errorf("unable to stat %s: %v", attachment.path, err)
+		}
+		records = append(records, processedFile{
+			Hash:       hash,
+			Original:   attachment.name,
+			Target:     attachment.path,
+			Note:       notePath(attachment.date, settings),
+			Date:       attachment.date,
+			ImportedAt: info.ModTime(),
+		})
+	}
+
+	db, err := openState(settings)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(processedBucket)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(record.Hash), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
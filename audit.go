@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var noteLinkPattern = regexp.MustCompile(`\[\[([^\]|#]+)[^\]]*\]\]|\[([^\]]+)\]\(<(?:file|https?)://`)
+
+// auditReport lists where the notes and the attachment folders disagree.
+type auditReport struct {
+	Unlinked []string
+	Missing  []string
+	Unknown  []string
+}
+
+// auditVault compares the attachments of every source folder, including
+// the large file path if one is set, with the links in the notes.
+func auditVault(settings *appSettings) (*auditReport, error) {
+	attachments := make(map[string]string)
+	prefixes := make([]string, 0)
+	for _, folder := range sourceFolders(settings) {
+		prefixes = append(prefixes, folder.ImagePrefix)
+		for _, dir := range []string{folder.TargetPhotoPath, folder.LargeFilePath} {
+			if dir == "" {
+				continue
+			}
+			files, err := os.ReadDir(dir)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to read path %s: %v", dir, err)
+			}
+			for _, file := range files {
+				name := normalizeName(file.Name())
+				if !file.IsDir() && strings.HasPrefix(name, folder.ImagePrefix) {
+					attachments[name] = folder.ImagePrefix
+				}
+			}
+		}
+	}
+
+	// Offloaded attachments count as present and their placeholders as known.
+	placeholders := make(map[string]bool)
+	if settings.Offload != nil {
+		index, err := readOffloadIndex(settings)
+		if err != nil {
+			return nil, err
+		}
+		for name, entry := range index {
+			attachments[name] = longestPrefix(name, prefixes)
+			if entry.Placeholder != "" {
+				placeholders[normalizeName(path.Base(entry.Placeholder))] = true
+			}
+		}
+	}
+
+	linked, err := linkedAttachments(settings, prefixes)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &auditReport{}
+	for name, prefix := range attachments {
+		if _, ok := linked[name]; !ok {
+			report.Unlinked = append(report.Unlinked, name)
+		}
+		original := strings.TrimSuffix(strings.TrimPrefix(name, prefix), encryptedExtension)
+		if !isDiaryPhoto(original, settings) && !isGeneratedAttachment(original) && !placeholders[name] {
+			report.Unknown = append(report.Unknown, name)
+		}
+	}
+	for name, note := range linked {
+		if _, ok := attachments[name]; !ok {
+			report.Missing = append(report.Missing, fmt.Sprintf("%s (in %s)", name, note))
+		}
+	}
+
+	sort.Strings(report.Unlinked)
+	sort.Strings(report.Missing)
+	sort.Strings(report.Unknown)
+	return report, nil
+}
+
+// linkedAttachments returns the prefixed attachments linked from the notes
+// mapped to the first note that links them. Names are normalized so links
+// and files written by different systems compare equal.
+func linkedAttachments(settings *appSettings, prefixes []string) (map[string]string, error) {
+	notes, err := noteFiles(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	linked := make(map[string]string)
+	for _, note := range notes {
+		if note == linkReportNoteName {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(settings.ObsidianFilePath, note))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read note %s: %v", note, err)
+		}
+
+		for _, match := range noteLinkPattern.FindAllStringSubmatch(string(data), -1) {
+			name := normalizeName(path.Base(strings.TrimSpace(match[1] + match[2])))
+			if !hasAnyPrefix(name, prefixes) {
+				continue
+			}
+			if _, ok := linked[name]; !ok {
+				linked[name] = note
+			}
+		}
+	}
+	return linked, nil
+}
+
+func longestPrefix(name string, prefixes []string) string {
+	longest := ""
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	return longest
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// runAudit implements the audit command. It exits with status 1 when the
+// vault and the attachments disagree.
+func runAudit(settings *appSettings) {
+	report, err := auditVault(settings)
+	if err != nil {
+		log.Fatalf("unable to audit the vault: %s", err)
+	}
+
+	printAuditSection("Attachments not linked from any note", report.Unlinked)
+	printAuditSection("Linked attachments missing from disk", report.Missing)
+	printAuditSection("Prefixed files with unknown names", report.Unknown)
+
+	if len(report.Unlinked)+len(report.Missing)+len(report.Unknown) > 0 {
+		os.Exit(1)
+	}
+	fmt.Println("No differences found")
+}
+
+func printAuditSection(title string, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	fmt.Printf("%s (%d):\n", title, len(names))
+	for _, name := range names {
+		fmt.Printf("  %s\n", name)
+	}
+}
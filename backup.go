@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// backupSettings configure snapshotting the vault with restic or borg after
+// photos have been imported.
+type backupSettings struct {
+	Tool         string   `yaml:"tool"`
+	Command      string   `yaml:"command"`
+	Repository   string   `yaml:"repository"`
+	PasswordFile string   `yaml:"password_file"`
+	Tags         []string `yaml:"tags"`
+}
+
+// runBackup snapshots the notes and the attachments.
+func runBackup(settings *appSettings) error {
+	backup := settings.Backup
+	paths := []string{settings.ObsidianFilePath}
+	if !isInsideDir(settings.TargetPhotoPath, settings.ObsidianFilePath) {
+		paths = append(paths, settings.TargetPhotoPath)
+	}
+
+	var cmd *exec.Cmd
+	switch backup.Tool {
+	case "restic":
+		args := []string{"-r", backup.Repository, "backup"}
+		if backup.PasswordFile != "" {
+			args = append(args, "--password-file", backup.PasswordFile)
+		}
+		for _, tag := range backup.Tags {
+			args = append(args, "--tag", tag)
+		}
+		cmd = exec.Command(backupCommand(backup), append(args, paths...)...)
+	case "borg":
+		args := []string{"create"}
+		if len(backup.Tags) > 0 {
+			args = append(args, "--comment", strings.Join(backup.Tags, ","))
+		}
+		args = append(args, backup.Repository+"::diary-{now:%Y-%m-%dT%H:%M:%S}")
+		cmd = exec.Command(backupCommand(backup), append(args, paths...)...)
+		cmd.Env = os.Environ()
+		if backup.PasswordFile != "" {
+			// borg splits the command like a shell, so the path is quoted.
+			cmd.Env = append(cmd.Env, "BORG_PASSCOMMAND=cat "+shellQuote(backup.PasswordFile))
+		}
+	default:
+		return fmt.Errorf("unknown backup tool %q", backup.Tool)
+	}
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s backup failed: %v: %s", backup.Tool, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func backupCommand(settings *backupSettings) string {
+	if settings.Command != "" {
+		return settings.Command
+	}
+	return settings.Tool
+}
+
+// isInsideDir tells whether the path is the folder or in it. A folder
+// that only starts with the same name, like /vault-photos for /vault, is
+// not in it.
+func isInsideDir(filePath string, dir string) bool {
+	relative, err := filepath.Rel(dir, filePath)
+	if err != nil {
+		return false
+	}
+	return relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// shellQuote quotes the value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'"'"'`) + "'"
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+type calDAVSettings struct {
+	URL        string   `yaml:"url"`
+	Username   string   `yaml:"username"`
+	Password   string   `yaml:"password"`
+	Calendars  []string `yaml:"calendars"`
+	TitlesOnly bool     `yaml:"titles_only"`
+}
+
+type calendarEvent struct {
+	Summary  string
+	Location string
+	Start    time.Time
+	End      time.Time
+	AllDay   bool
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href     string        `xml:"DAV: href"`
+	Propstat []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	DisplayName  string `xml:"DAV: displayname"`
+	ResourceType struct {
+		Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+	} `xml:"DAV: resourcetype"`
+	CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+}
+
+const calDAVPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:displayname/>
+    <d:resourcetype/>
+  </d:prop>
+</d:propfind>`
+
+const calDAVReportBody = `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop>
+    <c:calendar-data/>
+  </d:prop>
+  <c:filter>
+    <c:comp-filter name="VCALENDAR">
+      <c:comp-filter name="VEVENT">
+        <c:time-range start="%s" end="%s"/>
+      </c:comp-filter>
+    </c:comp-filter>
+  </c:filter>
+</c:calendar-query>`
+
+var calDAVClient = &http.Client{Timeout: 30 * time.Second}
+
+// fetchDayEvents returns the events of the given date from every allowed
+// calendar under the configured CalDAV home URL, sorted by start time.
+func fetchDayEvents(date string, settings *calDAVSettings) ([]calendarEvent, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %s: %v", date, err)
+	}
+
+	calendars, err := listCalendars(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]calendarEvent, 0)
+	for _, calendarURL := range calendars {
+		calendarEvents, err := queryCalendar(calendarURL, day, settings)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, calendarEvents...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events, nil
+}
+
+func listCalendars(settings *calDAVSettings) ([]string, error) {
+	base, err := url.Parse(settings.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CalDAV url %s: %v", settings.URL, err)
+	}
+
+	multistatus, err := calDAVRequest("PROPFIND", settings.URL, calDAVPropfindBody, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for _, name := range settings.Calendars {
+		allowed[strings.ToLower(name)] = true
+	}
+
+	result := make([]string, 0)
+	for _, response := range multistatus.Responses {
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.ResourceType.Calendar == nil {
+				continue
+			}
+			if len(allowed) > 0 && !allowed[strings.ToLower(propstat.Prop.DisplayName)] {
+				continue
+			}
+			href, err := url.Parse(response.Href)
+			if err != nil {
+				return nil, fmt.Errorf("invalid calendar href %s: %v", response.Href, err)
+			}
+			result = append(result, base.ResolveReference(href).String())
+		}
+	}
+
+	return result, nil
+}
+
+func queryCalendar(calendarURL string, day time.Time, settings *calDAVSettings) ([]calendarEvent, error) {
+	start := day.UTC().Format("20060102T150405Z")
+	end := day.AddDate(0, 0, 1).UTC().Format("20060102T150405Z")
+	body := fmt.Sprintf(calDAVReportBody, start, end)
+
+	multistatus, err := calDAVRequest("REPORT", calendarURL, body, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]calendarEvent, 0)
+	for _, response := range multistatus.Responses {
+		for _, propstat := range response.Propstat {
+			if propstat.Prop.CalendarData == "" {
+				continue
+			}
+			result = append(result, parseICSEvents(propstat.Prop.CalendarData)...)
+		}
+	}
+
+	return result, nil
+}
+
+func calDAVRequest(method string, target string, body string, settings *calDAVSettings) (*davMultistatus, error) {
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %v", method, err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	if settings.Username != "" {
+		req.SetBasicAuth(settings.Username, settings.Password)
+	}
+
+	resp, err := calDAVClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %v", method, target, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("%s %s returned %s", method, target, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s response: %v", method, err)
+	}
+
+	var multistatus davMultistatus
+	if err := xml.Unmarshal(data, &multistatus); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s response: %v", method, err)
+	}
+	return &multistatus, nil
+}
+
+// parseICSEvents extracts VEVENT components from iCalendar data. Only the
+// properties needed for the diary are read.
+func parseICSEvents(data string) []calendarEvent {
+	result := make([]calendarEvent, 0)
+	var current *calendarEvent
+
+	for _, line := range unfoldICSLines(data) {
+		name, params, value := splitICSLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			current = &calendarEvent{}
+		case name == "END" && value == "VEVENT":
+			if current != nil {
+				result = append(result, *current)
+			}
+			current = nil
+		case current == nil:
+			continue
+		case name == "SUMMARY":
+			current.Summary = unescapeICSText(value)
+		case name == "LOCATION":
+			current.Location = unescapeICSText(value)
+		case name == "DTSTART":
+			current.Start, current.AllDay = parseICSTime(params, value)
+		case name == "DTEND":
+			current.End, _ = parseICSTime(params, value)
+		}
+	}
+
+	return result
+}
+
+func unfoldICSLines(data string) []string {
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func splitICSLine(line string) (string, map[string]string, string) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return "", nil, ""
+	}
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, param := range parts[1:] {
+		if eq := strings.Index(param, "="); eq >= 0 {
+			params[strings.ToUpper(param[:eq])] = strings.Trim(param[eq+1:], `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, line[colon+1:]
+}
+
+func parseICSTime(params map[string]string, value string) (time.Time, bool) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		t, _ := time.ParseInLocation("20060102", value, time.Local)
+		return t, true
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, _ := time.Parse("20060102T150405Z", value)
+		return t.Local(), false
+	}
+	location := time.Local
+	if tzid, ok := params["TZID"]; ok {
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			location = loc
+		}
+	}
+	t, _ := time.ParseInLocation("20060102T150405", value, location)
+	return t.Local(), false
+}
+
+func unescapeICSText(value string) string {
+	replacer := strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+// formatEvents renders the events as a Markdown list. With titlesOnly the
+// times and locations are left out of the note.
+func formatEvents(events []calendarEvent, titlesOnly bool) string {
+	var buf bytes.Buffer
+	for _, event := range events {
+		if titlesOnly || event.AllDay {
+			buf.WriteString(fmt.Sprintf("- %s\n", event.Summary))
+			continue
+		}
+		line := fmt.Sprintf("- %s %s", event.Start.Format("15:04"), event.Summary)
+		if !event.End.IsZero() {
+			line = fmt.Sprintf("- %s–%s %s", event.Start.Format("15:04"), event.End.Format("15:04"), event.Summary)
+		}
+		if event.Location != "" {
+			line = fmt.Sprintf("%s (%s)", line, event.Location)
+		}
+		buf.WriteString(line + "\n")
+	}
+	return buf.String()
+}
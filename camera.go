@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// cameraSettings configure the experimental gphoto2 source which pulls
+// photos from a camera or phone connected over PTP/MTP.
+type cameraSettings struct {
+	Command       string `yaml:"command"`
+	Port          string `yaml:"port"`
+	HashIndexPath string `yaml:"hash_index_path"`
+	DownloadPath  string `yaml:"download_path"`
+	TimeOffset    string `yaml:"time_offset"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+// cameraTimeLayout matches the start of the file names gphoto2 is asked
+// to use for the downloaded files.
+const cameraTimeLayout = "2006-01-02-150405"
+
+// importCameraPhotos downloads the photos on the connected camera into the
+// download path with gphoto2 and copies the ones not imported before into
+// the original photo path. The downloaded files are kept, so gphoto2 skips
+// them and only the new photos on the camera are downloaded on every poll.
+// A file that fails to import is removed to download it again.
+func importCameraPhotos(settings *appSettings) (int, error) {
+	camera := settings.Camera
+	if camera.HashIndexPath == "" {
+		return 0, fmt.Errorf("camera hash_index_path is not set")
+	}
+
+	index, err := readHashIndex(camera.HashIndexPath)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := parseTimeOffset(camera.TimeOffset)
+	if err != nil {
+		return 0, err
+	}
+
+	downloadPath := cameraDownloadPath(camera)
+	if err := os.MkdirAll(downloadPath, 0755); err != nil {
+		return 0, fmt.Errorf("unable to create %s: %v", downloadPath, err)
+	}
+	downloaded, err := os.ReadDir(downloadPath)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read path %s: %v", downloadPath, err)
+	}
+	existing := make(map[string]bool)
+	for _, file := range downloaded {
+		existing[file.Name()] = true
+	}
+
+	command := camera.Command
+	if command == "" {
+		command = "gphoto2"
+	}
+	// gphoto2 expands the capture time of each file into the file name.
+	args := []string{"--get-all-files", "--skip-existing", "--filename", "%Y-%m-%d-%H%M%S-%f.%C"}
+	if camera.Port != "" {
+		args = append(args, "--port", camera.Port)
+	}
+
+	cmd := exec.Command(command, args...)
+	cmd.Dir = downloadPath
+	output, err := cmd.CombinedOutput()
+	if strings.Contains(string(output), "No camera found") {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s failed: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+
+	files, err := os.ReadDir(downloadPath)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read path %s: %v", downloadPath, err)
+	}
+
+	count := 0
+	for _, file := range files {
+		ext := photoExtension(file.Name())
+		if file.IsDir() || existing[file.Name()] || ext == "" || len(file.Name()) < len(cameraTimeLayout) {
+			continue
+		}
+		captured, err := time.ParseInLocation(cameraTimeLayout, file.Name()[0:len(cameraTimeLayout)], time.Local)
+		if err != nil {
+			continue
+		}
+
+		filePath := path.Join(downloadPath, file.Name())
+		imported, err := importHashedPhoto(filePath, captured.Add(offset), ext, index, camera.HashIndexPath, settings)
+		if err != nil {
+			os.Remove(filePath)
+			return count, err
+		}
+		if imported {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// cameraDownloadPath returns the folder the files of the camera are
+// downloaded to, next to the hash index unless download_path is set.
+func cameraDownloadPath(camera *cameraSettings) string {
+	if camera.DownloadPath != "" {
+		return camera.DownloadPath
+	}
+	return camera.HashIndexPath + "-files"
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// castSettings configure showing today's photos on a DLNA/UPnP media
+// renderer. The renderer fetches the photos from a small HTTP server that
+// runs for the duration of the slideshow, so the listen address must be
+// reachable from the renderer. Without a host in it, the photos are
+// offered on the address of the interface that reaches the renderer.
+type castSettings struct {
+	RendererURL   string `yaml:"renderer_url"`
+	ListenAddress string `yaml:"listen_address"`
+	SlideSeconds  int    `yaml:"slide_seconds"`
+}
+
+const avTransportService = "urn:schemas-upnp-org:service:AVTransport:1"
+
+const soapEnvelope = `<?xml version="1.0" encoding="utf-8"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+<s:Body><u:%s xmlns:u="%s">%s</u:%s></s:Body>
+</s:Envelope>`
+
+const didlLiteItem = `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/">` +
+	`<item id="1" parentID="0" restricted="1"><dc:title>%s</dc:title><upnp:class>object.item.imageItem.photo</upnp:class>` +
+	`<res protocolInfo="http-get:*:%s:*">%s</res></item></DIDL-Lite>`
+
+var castClient = &http.Client{Timeout: 10 * time.Second}
+
+// castQueue collects the photos the import moved, so only the new photos
+// of today are cast and not the ones cast after an earlier import.
+type castQueue struct {
+	sync.Mutex
+	photos []string
+}
+
+var currentCast = &castQueue{}
+
+func (q *castQueue) handleEvent(event importEvent) error {
+	if event.Type != eventPhotoImported || photoExtension(event.Target) == "" {
+		return nil
+	}
+	if date, _ := getDateFromFile(event.Source); date != time.Now().Format("2006-01-02") {
+		return nil
+	}
+	q.Lock()
+	defer q.Unlock()
+	q.photos = append(q.photos, event.Target)
+	return nil
+}
+
+// take returns the collected photos and starts over.
+func (q *castQueue) take() []string {
+	q.Lock()
+	defer q.Unlock()
+	photos := q.photos
+	q.photos = nil
+	return photos
+}
+
+// slideshow is the slideshow running in the background. A new one stops
+// the previous one first.
+var slideshow = struct {
+	sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}{}
+
+// castImportedPhotos starts a slideshow of the photos of today the import
+// moved, without waiting for it, as it takes the slide time of every
+// photo. Encrypted photos and videos can't be shown, so only JPEG and PNG
+// photos are cast.
+func castImportedPhotos(settings *appSettings) {
+	photos := currentCast.take()
+	if settings.Cast == nil || len(photos) == 0 {
+		return
+	}
+
+	slideshow.Lock()
+	defer slideshow.Unlock()
+	if slideshow.cancel != nil {
+		slideshow.cancel()
+		<-slideshow.done
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	slideshow.cancel, slideshow.done = cancel, done
+	go func() {
+		defer close(done)
+		if err := castPhotos(ctx, photos, settings.Cast); err != nil {
+			logErrorf("unable to cast today's photos: %s", err)
+		}
+	}()
+}
+
+// waitForSlideshow waits until the slideshow in the background is over, so
+// a single import doesn't exit while the renderer loads the photos.
+func waitForSlideshow() {
+	slideshow.Lock()
+	done := slideshow.done
+	slideshow.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// castPhotos shows the photos on the configured renderer one at a time and
+// stops the renderer after the last one or when the slideshow is stopped.
+func castPhotos(ctx context.Context, photos []string, cast *castSettings) error {
+	listener, err := net.Listen("tcp", cast.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %v", cast.ListenAddress, err)
+	}
+	server := &http.Server{Handler: castHandler(photos)}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	host, err := castHost(listener, cast.RendererURL)
+	if err != nil {
+		return err
+	}
+
+	slide := time.Duration(cast.SlideSeconds) * time.Second
+	if slide <= 0 {
+		slide = 10 * time.Second
+	}
+
+	logInfof("casting %d photos to %s", len(photos), cast.RendererURL)
+	for _, photo := range photos {
+		name := path.Base(photo)
+		photoURL := fmt.Sprintf("http://%s/%s", host, url.PathEscape(name))
+		contentType := mime.TypeByExtension(path.Ext(name))
+		metadata := fmt.Sprintf(didlLiteItem, xmlEscape(name), contentType, xmlEscape(photoURL))
+
+		args := fmt.Sprintf("<InstanceID>0</InstanceID><CurrentURI>%s</CurrentURI><CurrentURIMetaData>%s</CurrentURIMetaData>",
+			xmlEscape(photoURL), xmlEscape(metadata))
+		if err := soapCall(cast.RendererURL, "SetAVTransportURI", args); err != nil {
+			return err
+		}
+		if err := soapCall(cast.RendererURL, "Play", "<InstanceID>0</InstanceID><Speed>1</Speed>"); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(slide):
+		case <-ctx.Done():
+		case <-shutdownRequested():
+		}
+		if ctx.Err() != nil || shuttingDown() {
+			break
+		}
+	}
+
+	return soapCall(cast.RendererURL, "Stop", "<InstanceID>0</InstanceID>")
+}
+
+// castHost returns the host and port the renderer loads the photos from.
+// When the listen address has no host, the address of the interface that
+// reaches the renderer is used, as the renderer can't load the photos
+// from 0.0.0.0 or [::].
+func castHost(listener net.Listener, rendererURL string) (string, error) {
+	addr := listener.Addr().(*net.TCPAddr)
+	if !addr.IP.IsUnspecified() {
+		return addr.String(), nil
+	}
+
+	renderer, err := url.Parse(rendererURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid renderer_url %s: %v", rendererURL, err)
+	}
+	port := renderer.Port()
+	if port == "" {
+		port = "80"
+	}
+	// Dialing UDP sends nothing, it only picks the outbound interface.
+	conn, err := net.Dial("udp", net.JoinHostPort(renderer.Hostname(), port))
+	if err != nil {
+		return "", fmt.Errorf("unable to find the address that reaches %s, set the host of listen_address: %v", renderer.Host, err)
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+	return net.JoinHostPort(local.IP.String(), strconv.Itoa(addr.Port)), nil
+}
+
+func castHandler(photos []string) http.Handler {
+	allowed := make(map[string]string)
+	for _, photo := range photos {
+		allowed[path.Base(photo)] = photo
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		photo, ok := allowed[path.Base(r.URL.Path)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, photo)
+	})
+}
+
+func soapCall(controlURL string, action string, args string) error {
+	body := fmt.Sprintf(soapEnvelope, action, avTransportService, args, action)
+	req, err := http.NewRequest("POST", controlURL, bytes.NewBufferString(body))
+	if err != nil {
+		return fmt.Errorf("failed to create %s request: %v", action, err)
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPACTION", fmt.Sprintf(`"%s#%s"`, avTransportService, action))
+
+	resp, err := castClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s failed: %v", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", action, resp.Status)
+	}
+	return nil
+}
+
+func xmlEscape(value string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(value))
+	return buf.String()
+}
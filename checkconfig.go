@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configReport collects what check-config finds in the settings file.
+type configReport struct {
+	errors   []string
+	warnings []string
+	defaults []string
+}
+
+func (r *configReport) errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *configReport) warnf(format string, args ...interface{}) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *configReport) defaultf(format string, args ...interface{}) {
+	r.defaults = append(r.defaults, fmt.Sprintf(format, args...))
+}
+
+var unknownFieldPattern = regexp.MustCompile(`^(line \d+): field (\S+) not found in type \S+$`)
+
+// runCheckConfig validates the settings file: unknown and missing settings,
+// folders that don't exist, zero intervals and the other checks of reading
+// the settings. It prints the defaults that apply and exits with 1 when the
+// settings have errors.
+func runCheckConfig(settingsFile string, profile string) {
+	report := &configReport{}
+	checkConfig(settingsFile, profile, report)
+
+	for _, message := range report.errors {
+		fmt.Printf("error: %s\n", message)
+	}
+	for _, message := range report.warnings {
+		fmt.Printf("warning: %s\n", message)
+	}
+	for _, message := range report.defaults {
+		fmt.Printf("default: %s\n", message)
+	}
+	if len(report.errors) > 0 {
+		fmt.Printf("%s has %d errors\n", settingsFile, len(report.errors))
+		os.Exit(1)
+	}
+	fmt.Printf("%s is valid\n", settingsFile)
+}
+
+func checkConfig(settingsFile string, profile string, report *configReport) {
+	data, err := os.ReadFile(settingsFile)
+	if err != nil {
+		report.errorf("unable to read %s: %s", settingsFile, err)
+		return
+	}
+
+	checkKnownFields(data, "", report)
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err == nil && len(document.Content) > 0 {
+		checkZeroIntervals(document.Content[0], "", report)
+	}
+	var raw appSettings
+	if err := yaml.Unmarshal(data, &raw); err == nil {
+		for _, name := range profileNames(&raw) {
+			node := raw.Profiles[name]
+			profileData, err := yaml.Marshal(&node)
+			if err == nil {
+				checkKnownFields(profileData, fmt.Sprintf("profile %s: ", name), report)
+			}
+		}
+	}
+
+	settings, err := readSettings(settingsFile, profile)
+	if err != nil {
+		report.errorf("%s", err)
+		return
+	}
+	checkRequiredPaths(settings, report)
+	reportDefaults(settings, report)
+}
+
+// checkKnownFields reports the settings the import doesn't know, which are
+// usually typos that would otherwise be ignored.
+func checkKnownFields(data []byte, prefix string, report *configReport) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var settings appSettings
+	err := decoder.Decode(&settings)
+	var typeError *yaml.TypeError
+	if errors.As(err, &typeError) {
+		for _, message := range typeError.Errors {
+			if match := unknownFieldPattern.FindStringSubmatch(message); match != nil {
+				report.errorf("%s%s: unknown setting %s", prefix, match[1], match[2])
+			} else {
+				report.errorf("%s%s", prefix, message)
+			}
+		}
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		report.errorf("%s%s", prefix, err)
+	}
+}
+
+// checkZeroIntervals warns about intervals and timeouts set to 0, which
+// fall back to their defaults instead of turning anything off.
+func checkZeroIntervals(node *yaml.Node, prefix string, report *configReport) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if key == "profiles" {
+				continue
+			}
+			if strings.HasSuffix(key, "_seconds") && value.Kind == yaml.ScalarNode && value.Value == "0" {
+				report.warnf("line %d: %s%s is 0, which means its default", value.Line, prefix, key)
+			}
+			checkZeroIntervals(value, prefix+key+".", report)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			checkZeroIntervals(item, fmt.Sprintf("%s%d.", prefix, i), report)
+		}
+	}
+}
+
+// checkRequiredPaths reports the paths that are not set and the folders
+// that don't exist.
+func checkRequiredPaths(settings *appSettings, report *configReport) {
+	required := []struct {
+		key   string
+		value string
+		note  string
+	}{
+		{"original_photo_path", settings.OriginalPhotoPath, "the photos would be read from the working directory"},
+		{"target_photo_path", settings.TargetPhotoPath, "the photos would be moved to the working directory"},
+		{"obsidian_file_path", settings.ObsidianFilePath, "the notes would be written to the working directory"},
+	}
+	for _, setting := range required {
+		if setting.value == "" {
+			report.errorf("%s is not set, %s", setting.key, setting.note)
+		}
+	}
+
+	folders := map[string]string{
+		"original_photo_path": settings.OriginalPhotoPath,
+		"obsidian_file_path":  settings.ObsidianFilePath,
+	}
+	for i, source := range settings.Sources {
+		folders[fmt.Sprintf("sources.%d.path", i)] = source.Path
+	}
+	keys := make([]string, 0, len(folders))
+	for key := range folders {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if folders[key] != "" && !isFolder(folders[key]) {
+			report.errorf("%s %s is not a folder", key, folders[key])
+		}
+	}
+	if settings.TargetPhotoPath != "" && !isFolder(settings.TargetPhotoPath) {
+		if isFolder(path.Dir(settings.TargetPhotoPath)) {
+			report.warnf("target_photo_path %s does not exist, the first import creates it", settings.TargetPhotoPath)
+		} else {
+			report.errorf("target_photo_path %s is not a folder", settings.TargetPhotoPath)
+		}
+	}
+}
+
+func isFolder(folder string) bool {
+	info, err := os.Stat(folder)
+	return err == nil && info.IsDir()
+}
+
+// reportDefaults lists the defaults that apply to the settings that are
+// not set.
+func reportDefaults(settings *appSettings, report *configReport) {
+	if settings.NoteNameFormat == "" {
+		report.defaultf("note_name_format: %%Y-%%m-%%d")
+	}
+	if settings.LockFile == "" {
+		report.defaultf("lock_file: %s", instanceLockPath(settings))
+	}
+	if settings.RetryAttempts <= 0 {
+		report.defaultf("retry_attempts: %d", defaultRetryAttempts)
+	}
+	if settings.LogLevel == "" {
+		report.defaultf("log_level: info")
+	}
+	if settings.LogFormat == "" {
+		report.defaultf("log_format: text")
+	}
+	if settings.Symlinks == "" {
+		report.defaultf("symlinks: follow")
+	}
+
+	watch := watchConfig(settings)
+	if settings.Watch == nil || settings.Watch.PollIntervalSeconds <= 0 {
+		report.defaultf("watch.poll_interval_seconds: %d", watch.PollIntervalSeconds)
+	}
+	if settings.Watch == nil || settings.Watch.DebounceSeconds <= 0 {
+		report.defaultf("watch.debounce_seconds: %d", watch.DebounceSeconds)
+	}
+	if settings.Timing == nil || settings.Timing.WarnSeconds <= 0 {
+		report.defaultf("timing.warn_seconds: 60")
+	}
+	if settings.HEIC != nil && len(settings.HEIC.Command) == 0 {
+		report.defaultf("heic.command: [%s]", strings.Join(defaultHEICCommand(), ", "))
+	}
+	if settings.ExifTool != nil && len(settings.ExifTool.Command) == 0 {
+		report.defaultf("exiftool.command: [exiftool]")
+	}
+	if settings.Travel != nil && settings.Travel.HomePath == "" {
+		report.defaultf("travel.home_path: %s", settings.ObsidianFilePath)
+	}
+}
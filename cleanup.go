@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// moveUnsortedFiles moves files that do not look like diary photos and are
+// older than the configured number of days from the original photo path
+// to the unsorted photo path. It returns the names of the moved files.
+func moveUnsortedFiles(settings *appSettings) ([]string, error) {
+	files, err := os.ReadDir(settings.OriginalPhotoPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read path %s: %v", settings.OriginalPhotoPath, err)
+	}
+	if err := os.MkdirAll(settings.UnsortedPhotoPath, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %v", settings.UnsortedPhotoPath, err)
+	}
+
+	threshold := time.Now().AddDate(0, 0, -settings.UnsortedAfterDays)
+	moved := make([]string, 0)
+	for _, file := range files {
+		if isSkippedEntry(file, settings) || isDiaryPhoto(file.Name(), settings) {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			return moved, fmt.Errorf("unable to stat %s: %v", file.Name(), err)
+		}
+		if info.ModTime().After(threshold) {
+			continue
+		}
+
+		source := path.Join(settings.OriginalPhotoPath, file.Name())
+		target := path.Join(settings.UnsortedPhotoPath, file.Name())
+		if err := moveFile(source, target); err != nil {
+			return moved, err
+		}
+		moved = append(moved, file.Name())
+	}
+
+	return moved, nil
+}
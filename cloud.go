@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// cloudFileSettings configure handling photos in folders synced by cloud
+// clients that keep placeholders until a file is opened, like OneDrive or
+// Dropbox smart sync. Placeholders are skipped until they are downloaded,
+// unless hydrate is set, in which case they are read once to download them.
+// Every photo is read within the timeout before it is imported so a stuck
+// download does not stall the import.
+type cloudFileSettings struct {
+	Hydrate            bool `yaml:"hydrate"`
+	ReadTimeoutSeconds int  `yaml:"read_timeout_seconds"`
+}
+
+// skipCloudPlaceholders removes the photos that are not available locally
+// from the scanned photos. They are left in place for the next run.
+func skipCloudPlaceholders(photos map[string][]string, settings *appSettings) map[string][]string {
+	timeout := time.Duration(settings.CloudFiles.ReadTimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+		for _, photo := range datePhotos {
+			info, err := os.Stat(photo)
+			if err != nil {
+				logFields{"source": photo}.warnf("skipped %s: %s", photo, err)
+				continue
+			}
+			if isCloudPlaceholder(info) && !settings.CloudFiles.Hydrate {
+				logFields{"source": photo}.infof("skipped %s, it is not downloaded yet", photo)
+				continue
+			}
+			if err := readWithTimeout(photo, timeout); err != nil {
+				logFields{"source": photo}.warnf("skipped %s: %s", photo, err)
+				continue
+			}
+			result[date] = append(result[date], photo)
+		}
+	}
+
+	return result
+}
+
+// readWithTimeout reads the whole file, which downloads a placeholder. A
+// read that does not finish in time is left running in the background.
+func readWithTimeout(filePath string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		f, err := os.Open(filePath)
+		if err != nil {
+			done <- fmt.Errorf("unable to read %s: %v", filePath, err)
+			return
+		}
+		defer f.Close()
+		if _, err := io.Copy(io.Discard, f); err != nil {
+			done <- fmt.Errorf("unable to read %s: %v", filePath, err)
+			return
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("reading %s did not finish in %s", filePath, timeout)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// sfDataless is set on files whose contents have been evicted by a file
+// provider such as iCloud Drive, Dropbox or OneDrive.
+const sfDataless = 0x40000000
+
+func isCloudPlaceholder(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return stat.Flags&sfDataless != 0
+}
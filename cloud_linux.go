@@ -0,0 +1,17 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// isCloudPlaceholder tells whether the file has a size but no blocks on
+// disk, which is how FUSE based sync clients show files that are not
+// downloaded yet.
+func isCloudPlaceholder(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return info.Size() > 0 && stat.Blocks == 0
+}
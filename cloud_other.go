@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "os"
+
+func isCloudPlaceholder(info os.FileInfo) bool {
+	return false
+}
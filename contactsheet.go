@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// contactSheetSettings configure a single contact sheet of the photos
+// of days with at least min_photos photos. The sheet is embedded at the
+// top of the note and the photos themselves are only linked, so notes with
+// many photos stay quick to open.
+type contactSheetSettings struct {
+	MinPhotos int `yaml:"min_photos"`
+	Columns   int `yaml:"columns"`
+	ThumbSize int `yaml:"thumb_size"`
+}
+
+const (
+	contactSheetSuffix  = ".contact"
+	contactSheetPadding = 8
+)
+
+// updateContactSheet makes the contact sheet of the date from the photos
+// of every folder, embeds it in the note and turns the embeds of the
+// photos on it into links.
+func updateContactSheet(date string, folders []*appSettings) {
+	settings := folders[0]
+	sheet := settings.ContactSheet
+	if settings.Encryption != nil {
+		return
+	}
+	minPhotos := sheet.MinPhotos
+	if minPhotos <= 0 {
+		minPhotos = 20
+	}
+
+	photos := dayAttachments(date, folders)
+	if len(photos) < minPhotos {
+		return
+	}
+
+	name := settings.ImagePrefix + date + contactSheetSuffix + ".jpg"
+	logInfof("making a contact sheet of %d photos for %s", len(photos), date)
+	if err := writeContactSheet(photos, path.Join(settings.TargetPhotoPath, name), sheet); err != nil {
+		logErrorf("unable to make a contact sheet for %s: %s", date, err)
+		return
+	}
+
+	diaryFilePath := notePath(date, settings)
+	if err := insertAtTop(diaryFilePath, fmt.Sprintf("![[%s]]", name), settings); err != nil {
+		logErrorf("unable to add the contact sheet to the note of %s: %s", date, err)
+		return
+	}
+
+	names := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		names = append(names, regexp.QuoteMeta(path.Base(photo)))
+	}
+	embeds := regexp.MustCompile(`!(\[\[(?:` + strings.Join(names, "|") + `)(?:\|[^\]]*)?\]\])`)
+	if data, err := os.ReadFile(diaryFilePath); err == nil && embeds.Match(data) {
+		err := rewriteNote(diaryFilePath, settings, func(original []byte) []byte {
+			return embeds.ReplaceAll(original, []byte("$1"))
+		})
+		if err != nil {
+			logErrorf("unable to link the photos of %s instead of embedding them: %s", date, err)
+		}
+	}
+}
+
+func writeContactSheet(photos []string, target string, settings *contactSheetSettings) error {
+	columns := settings.Columns
+	if columns <= 0 {
+		columns = 5
+	}
+	thumbSize := settings.ThumbSize
+	if thumbSize <= 0 {
+		thumbSize = 300
+	}
+	if len(photos) < columns {
+		columns = len(photos)
+	}
+	rows := (len(photos) + columns - 1) / columns
+
+	cell := thumbSize + contactSheetPadding
+	sheet := image.NewRGBA(image.Rect(0, 0, columns*cell+contactSheetPadding, rows*cell+contactSheetPadding))
+	draw.Draw(sheet, sheet.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	drawn := 0
+	for _, photo := range photos {
+		thumb, err := decodeScaled(photo, thumbSize)
+		if err != nil {
+			logWarnf("left %s out of the contact sheet: %s", photo, err)
+			continue
+		}
+		bounds := thumb.Bounds()
+		x := contactSheetPadding + (drawn%columns)*cell + (thumbSize-bounds.Dx())/2
+		y := contactSheetPadding + (drawn/columns)*cell + (thumbSize-bounds.Dy())/2
+		draw.Draw(sheet, image.Rect(x, y, x+bounds.Dx(), y+bounds.Dy()), thumb, bounds.Min, draw.Src)
+		drawn++
+	}
+	if drawn == 0 {
+		return fmt.Errorf("none of the photos could be read")
+	}
+
+	temp := target + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", temp, err)
+	}
+	if err := jpeg.Encode(f, sheet, &jpeg.Options{Quality: 85}); err != nil {
+		f.Close()
+		os.Remove(temp)
+		return fmt.Errorf("unable to encode the contact sheet: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, target)
+}
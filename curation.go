@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// keywordSettings configure curating photos by the dc:subject keywords of
+// their XMP sidecars. With an allowlist only photos with at least one of
+// the keywords are imported, and photos with a denied keyword never are.
+type keywordSettings struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// filterBySidecars removes the photos rated below min_rating or rejected by
+// their keywords from the scanned photos and moves them, with their
+// sidecars, to the archive photo path. Photos without a sidecar have no
+// rating nor keywords and are kept.
+func filterBySidecars(photos map[string][]string, sidecars map[string]*xmpSidecar, settings *appSettings) map[string][]string {
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+		for _, photo := range datePhotos {
+			sidecar, ok := sidecars[photo]
+			reason := ""
+			if ok {
+				reason = rejectReason(sidecar, settings)
+			}
+			if reason == "" {
+				result[date] = append(result[date], photo)
+				continue
+			}
+
+			logFields{"source": photo}.infof("skipped %s: %s", photo, reason)
+			if settings.ArchivePhotoPath == "" {
+				continue
+			}
+			if err := archivePhoto(photo, sidecar, settings.ArchivePhotoPath); err != nil {
+				logFields{"source": photo}.errorf("unable to archive %s: %s", photo, err)
+			}
+		}
+	}
+
+	return result
+}
+
+func rejectReason(sidecar *xmpSidecar, settings *appSettings) string {
+	if settings.MinRating > 0 && sidecar.Rating < settings.MinRating {
+		return fmt.Sprintf("rated %d", sidecar.Rating)
+	}
+	if settings.Keywords == nil {
+		return ""
+	}
+
+	keywords := make(map[string]bool)
+	for _, keyword := range sidecar.Keywords {
+		keywords[strings.ToLower(strings.TrimSpace(keyword))] = true
+	}
+	for _, keyword := range settings.Keywords.Deny {
+		if keywords[strings.ToLower(keyword)] {
+			return fmt.Sprintf("tagged %s", keyword)
+		}
+	}
+	if len(settings.Keywords.Allow) == 0 {
+		return ""
+	}
+	for _, keyword := range settings.Keywords.Allow {
+		if keywords[strings.ToLower(keyword)] {
+			return ""
+		}
+	}
+	return "no allowed keyword"
+}
+
+func archivePhoto(photo string, sidecar *xmpSidecar, archivePath string) error {
+	if err := os.MkdirAll(archivePath, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", archivePath, err)
+	}
+
+	name := path.Base(photo)
+	ext := path.Ext(name)
+	target := path.Join(archivePath, name)
+	for i := 1; fileExists(target); i++ {
+		if i == 100 {
+			return fmt.Errorf("no free file name left for %s", name)
+		}
+		target = path.Join(archivePath, fmt.Sprintf("%s_%02d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+
+	if err := moveFile(photo, target); err != nil {
+		return err
+	}
+	return moveFile(sidecar.Path, target+".xmp")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	templateVariablePattern = regexp.MustCompile(`{{\s*(date|time|title|photos)(?::([^}]*))?\s*}}`)
+	photosVariablePattern   = regexp.MustCompile(`{{\s*photos\s*}}`)
+	momentTokenPattern      = regexp.MustCompile(`YYYY|YY|MMMM|MMM|MM|M|Do|DD|D|dddd|ddd|WW|W|ww|w|HH|H|hh|h|mm|ss|A|a|\[[^\]]*\]`)
+)
+
+var momentTokens = map[string]string{
+	"YYYY": "2006", "YY": "06", "MMMM": "January", "MMM": "Jan", "MM": "01", "M": "1",
+	"DD": "02", "D": "2", "dddd": "Monday", "ddd": "Mon",
+	"HH": "15", "hh": "03", "h": "3", "mm": "04", "ss": "05", "A": "PM", "a": "pm",
+}
+
+// newNote returns a new note of the date with the body, the photo section
+// and the sections around it. With a daily note template the template is
+// rendered and the body replaces its {{photos}} variable, or is added to
+// the end of it.
+func newNote(date string, body string, settings *appSettings) string {
+	if settings.DailyNoteTemplatePath == "" {
+		return "# " + date + "\n\n" + body
+	}
+
+	data, err := os.ReadFile(settings.DailyNoteTemplatePath)
+	if err != nil {
+		logWarnf("unable to read the daily note template, using the default note: %s", err)
+		return "# " + date + "\n\n" + body
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+
+	if !photosVariablePattern.MatchString(text) {
+		if !strings.HasSuffix(text, "\n") {
+			text = text + "\n"
+		}
+		text = text + "\n{{photos}}"
+	}
+	return renderDailyNoteTemplate(text, date, body)
+}
+
+// renderDailyNoteTemplate replaces the variables of the Obsidian Templates
+// core plugin, {{date}}, {{time}} and {{title}} with an optional Moment.js
+// format such as {{date:dddd D.M.YYYY}}.
+func renderDailyNoteTemplate(text string, date string, body string) string {
+	day, err := parseDateKey(date)
+	if err != nil {
+		day = time.Now()
+	}
+	now := time.Now()
+
+	return templateVariablePattern.ReplaceAllStringFunc(text, func(variable string) string {
+		match := templateVariablePattern.FindStringSubmatch(variable)
+		format := strings.TrimSpace(match[2])
+		switch match[1] {
+		case "date":
+			if format == "" {
+				format = "YYYY-MM-DD"
+			}
+			return momentFormat(day, format)
+		case "time":
+			if format == "" {
+				format = "HH:mm"
+			}
+			return momentFormat(now, format)
+		case "title":
+			return date
+		}
+		return strings.TrimSuffix(body, "\n")
+	})
+}
+
+// momentFormat formats the time with a Moment.js format string, as used
+// by Obsidian. Weeks are always ISO weeks.
+func momentFormat(t time.Time, format string) string {
+	return momentTokenPattern.ReplaceAllStringFunc(format, func(token string) string {
+		if strings.HasPrefix(token, "[") {
+			return strings.Trim(token, "[]")
+		}
+		_, week := t.ISOWeek()
+		switch token {
+		case "Do":
+			return ordinal(t.Day())
+		case "WW", "ww":
+			return fmt.Sprintf("%02d", week)
+		case "W", "w":
+			return strconv.Itoa(week)
+		}
+		return t.Format(momentTokens[token])
+	})
+}
+
+func ordinal(day int) string {
+	suffix := "th"
+	switch {
+	case day%100 >= 11 && day%100 <= 13:
+	case day%10 == 1:
+		suffix = "st"
+	case day%10 == 2:
+		suffix = "nd"
+	case day%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(day) + suffix
+}
+
+// addFrontMatter adds the line to the front matter of the note, creating
+// it if the note has none.
+func addFrontMatter(note string, line string) string {
+	if strings.HasPrefix(note, "---\n") {
+		if end := strings.Index(note[4:], "\n---\n"); end >= 0 {
+			return note[:4+end] + "\n" + line + note[4+end:]
+		}
+	}
+	return "---\n" + line + "\n---\n" + note
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partialDateSettings configure how photos with incomplete dates in their
+// names are handled. Two-digit years below the pivot year are in the 2000s.
+// Photos with only a month, like 2024-05.jpg, are skipped, dated to the
+// first day of the month or added to a monthly note.
+type partialDateSettings struct {
+	TwoDigitYears bool   `yaml:"two_digit_years"`
+	PivotYear     int    `yaml:"pivot_year"`
+	MonthOnly     string `yaml:"month_only"`
+}
+
+var (
+	twoDigitYearPattern = regexp.MustCompile(`^(\d{2})-(\d{2})-(\d{2})(-\d{2})?\.[^.]+$`)
+	monthOnlyPattern    = regexp.MustCompile(`^(\d{2}|\d{4})-(\d{2})\.[^.]+$`)
+)
+
+// isDiaryPhoto tells whether the file name is already in one of the forms
+// that checkPhotos imports.
+func isDiaryPhoto(name string, settings *appSettings) bool {
+	if photoFilePattern.MatchString(name) || (settings.Videos != nil && videoFilePattern.MatchString(name)) {
+		return true
+	}
+	return settings.PartialDates != nil && settings.PartialDates.MonthOnly == "monthly_note" && monthPhotoFilePattern.MatchString(name)
+}
+
+func isMonthKey(date string) bool {
+	return len(date) == len("2006-01")
+}
+
+// normalizePhotoNames renames photos whose names have a date in one of the
+// configured layouts, e.g. 01.05.2024.jpg, or a partial date to the ISO
+// names that checkPhotos recognizes. It returns the original names by the
+// new names.
+func normalizePhotoNames(settings *appSettings) (map[string]string, error) {
+	renamed := make(map[string]string)
+	files, err := os.ReadDir(settings.OriginalPhotoPath)
+	if err != nil {
+		return renamed, fmt.Errorf("unable to read path %s: %v", settings.OriginalPhotoPath, err)
+	}
+
+	seen := make(map[string]string)
+	for _, file := range files {
+		if isSkippedEntry(file, settings) || isDiaryPhoto(file.Name(), settings) {
+			continue
+		}
+		if first, ok := seen[normalizeName(file.Name())]; ok && sameContent(first, path.Join(settings.OriginalPhotoPath, file.Name())) {
+			logFields{"source": path.Join(settings.OriginalPhotoPath, file.Name())}.infof("skipped %s, the same photo as %s with a differently encoded name", file.Name(), path.Base(first))
+			continue
+		}
+		source := path.Join(settings.OriginalPhotoPath, file.Name())
+		seen[normalizeName(file.Name())] = source
+
+		name, err := normalizedName(file.Name(), settings, nil)
+		if err != nil {
+			return renamed, err
+		}
+		if name == "" {
+			continue
+		}
+		target := path.Join(settings.OriginalPhotoPath, name)
+		if err := os.Rename(source, target); err != nil {
+			return renamed, fmt.Errorf("unable to rename %s: %v", source, err)
+		}
+		logFields{"source": source, "target": target}.infof("renamed %s to %s", file.Name(), name)
+		renamed[name] = file.Name()
+		seen[normalizeName(file.Name())] = target
+	}
+
+	return renamed, nil
+}
+
+// normalizedName returns the ISO name a photo is renamed to, or an empty
+// string when no date can be parsed from the name nor, if enabled, from
+// its EXIF data. Names in reserved are treated as taken.
+func normalizedName(name string, settings *appSettings, reserved map[string]bool) (string, error) {
+	ext := photoExtension(name)
+	if ext == "" && settings.Videos != nil {
+		ext = videoExtension(name)
+	}
+	if ext == "" {
+		return "", nil
+	}
+	filePath := path.Join(settings.OriginalPhotoPath, name)
+	name = normalizeName(name)
+
+	if date, ok := parseLocalizedDate(name, settings.DateLayouts); ok {
+		return freeName(date.Format("2006-01-02"), "-", ext, settings, reserved)
+	}
+	if date, month, ok := parsePartialDate(name, settings.PartialDates); ok {
+		if month && settings.PartialDates.MonthOnly == "monthly_note" {
+			return freeName(date.Format("2006-01"), "_", ext, settings, reserved)
+		}
+		return freeName(date.Format("2006-01-02"), "-", ext, settings, reserved)
+	}
+	if settings.ExifDates {
+		if date, ok := captureTime(filePath, settings); ok {
+			return freeName(date.Format("2006-01-02"), "-", ext, settings, reserved)
+		}
+	}
+	return "", nil
+}
+
+// parsePartialDate parses names with a two-digit year or without a day
+// according to the settings. The second return value tells whether only
+// the month was known.
+func parsePartialDate(name string, settings *partialDateSettings) (time.Time, bool, bool) {
+	if settings == nil {
+		return time.Time{}, false, false
+	}
+
+	if match := twoDigitYearPattern.FindStringSubmatch(name); match != nil && settings.TwoDigitYears {
+		year := expandYear(match[1], settings.PivotYear)
+		date, err := time.ParseInLocation("2006-01-02", fmt.Sprintf("%04d-%s-%s", year, match[2], match[3]), time.Local)
+		return date, false, err == nil
+	}
+
+	if match := monthOnlyPattern.FindStringSubmatch(name); match != nil {
+		if settings.MonthOnly != "monthly_note" && settings.MonthOnly != "first_day" {
+			return time.Time{}, false, false
+		}
+		if len(match[1]) == 2 && !settings.TwoDigitYears {
+			return time.Time{}, false, false
+		}
+		year := expandYear(match[1], settings.PivotYear)
+		date, err := time.ParseInLocation("2006-01", fmt.Sprintf("%04d-%s", year, match[2]), time.Local)
+		return date, true, err == nil
+	}
+
+	return time.Time{}, false, false
+}
+
+func expandYear(year string, pivot int) int {
+	value, _ := strconv.Atoi(year)
+	if len(year) == 4 {
+		return value
+	}
+	if pivot <= 0 {
+		pivot = 70
+	}
+	if value < pivot {
+		return 2000 + value
+	}
+	return 1900 + value
+}
+
+// parseLocalizedDate parses the file name without its extension with the
+// given time layouts and returns the first date that matches.
+func parseLocalizedDate(name string, layouts []string) (time.Time, bool) {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	for _, layout := range layouts {
+		if date, err := time.ParseInLocation(layout, base, time.Local); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// dryRunNote is a note the import would create or modify, with the lines
+// it would add and remove.
+type dryRunNote struct {
+	Path    string   `json:"path"`
+	Action  string   `json:"action"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+type dryRunReport struct {
+	Files []plannedFile `json:"files"`
+	Notes []dryRunNote  `json:"notes"`
+}
+
+// sandboxPath maps a path of the sandbox to the path it stands for.
+type sandboxPath struct {
+	sandbox string
+	real    string
+}
+
+// runDryRun runs the import against a copy of the source folders and the
+// notes and reports what it would change. The attachments are stood in by
+// empty files with the same names and times, so montages, contact sheets
+// and the report notes are left out, like remote sources and everything
+// else that reaches outside of the vault.
+func runDryRun(settings *appSettings, outputFormat string) {
+	root, err := os.MkdirTemp("", "diary-dry-run-")
+	if err != nil {
+		log.Fatalf("unable to create temporary folder: %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	sandbox, paths := dryRunSettings(settings, root)
+	if err := prepareSandbox(settings, sandbox); err != nil {
+		log.Fatalf("unable to prepare the dry run: %s", err)
+	}
+
+	_, planned, _ := importFolders(sourceFolders(sandbox), true)
+	if sandbox.PlaceholderNotes != nil {
+		createPlaceholderNotes(sandbox)
+	}
+
+	report := dryRunReport{Files: make([]plannedFile, 0), Notes: make([]dryRunNote, 0)}
+	for _, file := range planned {
+		if file.Target != "" {
+			file.Target = realPath(file.Target, paths)
+		}
+		report.Files = append(report.Files, file)
+	}
+	if report.Notes, err = changedNotes(settings, sandbox); err != nil {
+		log.Fatalf("unable to compare the notes: %s", err)
+	}
+
+	if outputFormat == "json" {
+		printJSON(report)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tDATE\tACTION\tTARGET")
+	for _, file := range report.Files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", file.Name, file.Date, file.Action, file.Target)
+	}
+	w.Flush()
+
+	if len(report.Notes) == 0 {
+		fmt.Println("\nno notes would change")
+	}
+	for _, note := range report.Notes {
+		fmt.Printf("\n%s %s\n", note.Action, note.Path)
+		for _, line := range note.Removed {
+			fmt.Printf("- %s\n", line)
+		}
+		for _, line := range note.Added {
+			fmt.Printf("+ %s\n", line)
+		}
+	}
+}
+
+// dryRunSettings returns a copy of the settings with every path the import
+// writes to moved under the temporary root, and the sandbox paths with the
+// paths they stand for.
+func dryRunSettings(settings *appSettings, root string) (*appSettings, []sandboxPath) {
+	sandbox := simulationSettings(settings, root)
+	paths := []sandboxPath{
+		{sandbox.OriginalPhotoPath, settings.OriginalPhotoPath},
+		{sandbox.TargetPhotoPath, settings.TargetPhotoPath},
+		{sandbox.ObsidianFilePath, settings.ObsidianFilePath},
+		{sandbox.LargeFilePath, settings.LargeFilePath},
+		{sandbox.UnsortedPhotoPath, settings.UnsortedPhotoPath},
+		{sandbox.ArchivePhotoPath, settings.ArchivePhotoPath},
+	}
+	if settings.DateGuard != nil && settings.DateGuard.QuarantinePath != "" {
+		paths = append(paths, sandboxPath{sandbox.DateGuard.QuarantinePath, settings.DateGuard.QuarantinePath})
+	}
+
+	sandbox.Sources = make([]sourceFolderSettings, 0, len(settings.Sources))
+	for i, source := range settings.Sources {
+		folder := source
+		folder.Path = path.Join(root, "sources", fmt.Sprint(i))
+		sandbox.Sources = append(sandbox.Sources, folder)
+		paths = append(paths, sandboxPath{folder.Path, source.Path})
+	}
+
+	if settings.HEIC != nil && settings.HEIC.OriginalPath != "" {
+		heic := *settings.HEIC
+		heic.OriginalPath = path.Join(root, "heic")
+		sandbox.HEIC = &heic
+		paths = append(paths, sandboxPath{heic.OriginalPath, settings.HEIC.OriginalPath})
+	}
+	if settings.ImageTool != nil && settings.ImageTool.OriginalPath != "" {
+		tool := *settings.ImageTool
+		tool.OriginalPath = path.Join(root, "converted")
+		sandbox.ImageTool = &tool
+		paths = append(paths, sandboxPath{tool.OriginalPath, settings.ImageTool.OriginalPath})
+	}
+	if settings.RetroEdits != nil && settings.RetroEdits.StagingPath != "" {
+		retro := *settings.RetroEdits
+		retro.StagingPath = path.Join(root, "staging")
+		sandbox.RetroEdits = &retro
+		paths = append(paths, sandboxPath{retro.StagingPath, settings.RetroEdits.StagingPath})
+	}
+	if settings.StatePath != "" {
+		sandbox.StatePath = path.Join(root, "state.db")
+	}
+
+	// Plugins that transform and caption photos are part of the import,
+	// plugins that fetch photos are remote sources.
+	sandbox.Plugins = make([]pluginSettings, 0, len(settings.Plugins))
+	for _, plugin := range settings.Plugins {
+		if plugin.Kind != "source" {
+			sandbox.Plugins = append(sandbox.Plugins, plugin)
+		}
+	}
+	sandbox.Montage = nil
+	sandbox.ContactSheet = nil
+	sandbox.Storage = nil
+	sandbox.PhotoStats = false
+	sandbox.LinkReport = false
+
+	sort.Slice(paths, func(i, j int) bool {
+		return len(paths[i].sandbox) > len(paths[j].sandbox)
+	})
+	return sandbox, paths
+}
+
+// prepareSandbox copies the source folders, the notes and the state
+// database to the sandbox and stands in the attachments with empty files.
+func prepareSandbox(settings *appSettings, sandbox *appSettings) error {
+	folders := sourceFolders(settings)
+	sandboxFolders := sourceFolders(sandbox)
+	for i, folder := range folders {
+		if err := copySourceFolder(folder, sandboxFolders[i].OriginalPhotoPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(sandbox.ObsidianFilePath, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", sandbox.ObsidianFilePath, err)
+	}
+	notes, err := noteFiles(settings)
+	if err != nil {
+		return err
+	}
+	for _, note := range notes {
+		if err := copySandboxFile(path.Join(settings.ObsidianFilePath, note), path.Join(sandbox.ObsidianFilePath, note)); err != nil {
+			return err
+		}
+	}
+
+	if err := standInAttachments(settings.TargetPhotoPath, sandbox.TargetPhotoPath); err != nil {
+		return err
+	}
+	if settings.LargeFilePath != "" {
+		if err := standInAttachments(settings.LargeFilePath, sandbox.LargeFilePath); err != nil {
+			return err
+		}
+	}
+
+	if settings.StatePath != "" && fileExists(settings.StatePath) {
+		if err := copySandboxFile(settings.StatePath, sandbox.StatePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySourceFolder copies the files of a source folder, keeping symlinks
+// as symlinks so they are imported the same way. Photos marked as
+// processed are left out, since the marks name the real paths.
+func copySourceFolder(folder *appSettings, target string) error {
+	source := folder.OriginalPhotoPath
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", target, err)
+	}
+	files, err := os.ReadDir(source)
+	if err != nil {
+		return fmt.Errorf("unable to read path %s: %v", source, err)
+	}
+	for _, file := range files {
+		if file.IsDir() || isMarkedProcessed(file, folder) {
+			continue
+		}
+		sourcePath := path.Join(source, file.Name())
+		targetPath := path.Join(target, file.Name())
+		if file.Type()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(sourcePath)
+			if err != nil {
+				return fmt.Errorf("unable to read link %s: %v", sourcePath, err)
+			}
+			if !filepath.IsAbs(link) {
+				link = filepath.Join(source, link)
+			}
+			if err := os.Symlink(link, targetPath); err != nil {
+				return fmt.Errorf("unable to create link %s: %v", targetPath, err)
+			}
+			continue
+		}
+		if err := copySandboxFile(sourcePath, targetPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySandboxFile copies the file with its modification time, which the
+// import uses as the capture time of photos without EXIF dates.
+func copySandboxFile(source string, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", source, err)
+	}
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", path.Dir(target), err)
+	}
+	if err := copyFile(source, target); err != nil {
+		return err
+	}
+	return os.Chtimes(target, info.ModTime(), info.ModTime())
+}
+
+// standInAttachments creates an empty file for every attachment, so names
+// are reserved and existing links resolve without copying the photos. The
+// checksum manifest is copied as it is.
+func standInAttachments(source string, target string) error {
+	if !dirExists(source) {
+		return nil
+	}
+	err := filepath.WalkDir(source, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, filePath)
+		if err != nil {
+			return err
+		}
+		targetPath := filepath.Join(target, relative)
+		if entry.IsDir() {
+			return os.MkdirAll(targetPath, 0755)
+		}
+		if entry.Name() == manifestName {
+			return copySandboxFile(filePath, targetPath)
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(targetPath, nil, 0644); err != nil {
+			return err
+		}
+		return os.Chtimes(targetPath, info.ModTime(), info.ModTime())
+	})
+	if err != nil {
+		return fmt.Errorf("unable to stand in the attachments of %s: %v", source, err)
+	}
+	return nil
+}
+
+// changedNotes compares the notes of the sandbox with the real notes.
+func changedNotes(settings *appSettings, sandbox *appSettings) ([]dryRunNote, error) {
+	notes, err := noteFiles(sandbox)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(notes)
+
+	result := make([]dryRunNote, 0)
+	for _, note := range notes {
+		changed, err := os.ReadFile(path.Join(sandbox.ObsidianFilePath, note))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read note %s: %v", note, err)
+		}
+		original, err := os.ReadFile(path.Join(settings.ObsidianFilePath, note))
+		if os.IsNotExist(err) {
+			result = append(result, dryRunNote{Path: note, Action: "create", Added: noteLines(string(changed))})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read note %s: %v", note, err)
+		}
+		if string(original) == string(changed) {
+			continue
+		}
+		added, removed := lineChanges(string(original), string(changed))
+		result = append(result, dryRunNote{Path: note, Action: "modify", Added: added, Removed: removed})
+	}
+	return result, nil
+}
+
+// lineChanges returns the lines only the changed note has and the lines
+// only the original has, in the order they appear.
+func lineChanges(original string, changed string) ([]string, []string) {
+	originalLines := noteLines(original)
+	changedLines := noteLines(changed)
+
+	counts := make(map[string]int)
+	for _, line := range originalLines {
+		counts[line]++
+	}
+	added := make([]string, 0)
+	for _, line := range changedLines {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		added = append(added, line)
+	}
+
+	counts = make(map[string]int)
+	for _, line := range changedLines {
+		counts[line]++
+	}
+	removed := make([]string, 0)
+	for _, line := range originalLines {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		removed = append(removed, line)
+	}
+	return added, removed
+}
+
+func noteLines(content string) []string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// realPath returns the path the sandbox path stands for.
+func realPath(sandboxed string, paths []sandboxPath) string {
+	for _, mapped := range paths {
+		if mapped.sandbox == "" || mapped.real == "" {
+			continue
+		}
+		if sandboxed == mapped.sandbox || strings.HasPrefix(sandboxed, mapped.sandbox+"/") {
+			return mapped.real + strings.TrimPrefix(sandboxed, mapped.sandbox)
+		}
+	}
+	return sandboxed
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+// stateDump is the internal state of the process, for debugging an import
+// that seems stuck.
+type stateDump struct {
+	Time              time.Time      `json:"time"`
+	Uptime            string         `json:"uptime"`
+	Status            statusDocument `json:"status"`
+	HeavyWorkDeferred bool           `json:"heavy_work_deferred"`
+	Folders           []folderDump   `json:"folders"`
+	Files             []fileDump     `json:"files"`
+	Subscribers       []string       `json:"subscribers"`
+	Goroutines        int            `json:"goroutines"`
+	MemoryBytes       uint64         `json:"memory_bytes"`
+}
+
+// folderDump lists the files waiting in a source folder: the photos the
+// next import takes and the other files, like photos waiting for a
+// conversion or without a date.
+type folderDump struct {
+	Name    string   `json:"name,omitempty"`
+	Path    string   `json:"path"`
+	Pending []string `json:"pending"`
+	Other   []string `json:"other"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// fileDump is how long a file of the current or last import has taken in
+// each stage.
+type fileDump struct {
+	File   string            `json:"file"`
+	Total  string            `json:"total"`
+	Stages map[string]string `json:"stages"`
+}
+
+var processStarted = time.Now()
+
+// stateDumps holds the settings the dumps describe, which the watch
+// switches.
+var stateDumps = struct {
+	sync.Mutex
+	settings *appSettings
+}{}
+
+func useDumpSettings(settings *appSettings) {
+	stateDumps.Lock()
+	defer stateDumps.Unlock()
+	stateDumps.settings = settings
+}
+
+func currentStateDump() stateDump {
+	stateDumps.Lock()
+	settings := stateDumps.settings
+	stateDumps.Unlock()
+
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	dump := stateDump{
+		Time:              time.Now(),
+		Uptime:            time.Since(processStarted).Round(time.Second).String(),
+		Status:            statusSnapshot(),
+		HeavyWorkDeferred: deferringHeavyWork(),
+		Folders:           make([]folderDump, 0),
+		Files:             make([]fileDump, 0),
+		Goroutines:        runtime.NumGoroutine(),
+		MemoryBytes:       memory.Alloc,
+	}
+	if settings != nil {
+		for _, folder := range sourceFolders(settings) {
+			dump.Folders = append(dump.Folders, dumpFolder(folder))
+		}
+	}
+	for _, timing := range slowestFiles(-1) {
+		file := fileDump{File: timing.File, Total: timing.Total.Round(time.Millisecond).String(), Stages: make(map[string]string)}
+		for stage, elapsed := range timing.Stages {
+			file.Stages[stage] = elapsed.Round(time.Millisecond).String()
+		}
+		dump.Files = append(dump.Files, file)
+	}
+
+	eventBus.Lock()
+	for _, s := range append(append([]subscription{}, eventBus.internal...), eventBus.subscriptions...) {
+		dump.Subscribers = append(dump.Subscribers, s.name)
+	}
+	eventBus.Unlock()
+	return dump
+}
+
+func dumpFolder(folder *appSettings) folderDump {
+	dump := folderDump{Name: folder.SourceName, Path: folder.OriginalPhotoPath, Pending: make([]string, 0), Other: make([]string, 0)}
+	files, err := os.ReadDir(folder.OriginalPhotoPath)
+	if err != nil {
+		dump.Error = err.Error()
+		return dump
+	}
+	for _, file := range files {
+		if file.IsDir() || isSkippedEntry(file, folder) {
+			continue
+		}
+		if isDiaryPhoto(file.Name(), folder) {
+			dump.Pending = append(dump.Pending, file.Name())
+		} else {
+			dump.Other = append(dump.Other, file.Name())
+		}
+	}
+	sort.Strings(dump.Pending)
+	sort.Strings(dump.Other)
+	return dump
+}
+
+// writeStateDump writes the state with the stacks of the goroutines to
+// dump_path, or logs a summary of it without dump_path.
+func writeStateDump() {
+	stateDumps.Lock()
+	settings := stateDumps.settings
+	stateDumps.Unlock()
+	dump := currentStateDump()
+
+	if settings == nil || settings.DumpPath == "" {
+		pending := 0
+		for _, folder := range dump.Folders {
+			pending += len(folder.Pending)
+		}
+		logInfof("state: %s, up %s, %d photos pending in %d folders, %d files in the current import, %d goroutines, %d bytes in use",
+			dump.Status.State, dump.Uptime, pending, len(dump.Folders), len(dump.Files), dump.Goroutines, dump.MemoryBytes)
+		for _, folder := range dump.Folders {
+			logInfof("state: %s has %d photos pending and %d other files", folder.Path, len(folder.Pending), len(folder.Other))
+		}
+		for _, logged := range dump.Status.RecentErrors {
+			logInfof("state: error at %s: %s", logged.Time, logged.Message)
+		}
+		return
+	}
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err == nil {
+		err = os.MkdirAll(path.Dir(settings.DumpPath), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(settings.DumpPath, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		logWarnf("unable to write the state to %s: %s", settings.DumpPath, err)
+		return
+	}
+	stacks := settings.DumpPath + ".goroutines"
+	var buffer bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&buffer, 2)
+	if err := os.WriteFile(stacks, buffer.Bytes(), 0644); err != nil {
+		logWarnf("unable to write the goroutines to %s: %s", stacks, err)
+	}
+	logInfof("wrote the state to %s and the goroutines to %s", settings.DumpPath, stacks)
+}
+
+// serveStateDump returns the state as JSON.
+func serveStateDump(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(currentStateDump()); err != nil {
+		http.Error(w, fmt.Sprintf("unable to encode the state: %s", err), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux && !darwin
+
+package main
+
+// handleDumpSignals does nothing on platforms without SIGUSR1, where the
+// watch API serves the state dump.
+func handleDumpSignals() {}
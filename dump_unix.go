@@ -0,0 +1,20 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleDumpSignals writes the state dump on SIGUSR1.
+func handleDumpSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			writeStateDump()
+		}
+	}()
+}
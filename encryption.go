@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// encryptionSettings configure encrypting attachments with age before they
+// are written to the target photo path, for vaults synced through storage
+// that is not trusted.
+type encryptionSettings struct {
+	Command    string   `yaml:"command"`
+	Recipients []string `yaml:"recipients"`
+}
+
+const encryptedExtension = ".age"
+
+func ageCommand(command string) string {
+	if command == "" {
+		return "age"
+	}
+	return command
+}
+
+// encryptFile writes an age encrypted copy of the source file to target.
+func encryptFile(source string, target string, settings *encryptionSettings) error {
+	if len(settings.Recipients) == 0 {
+		return fmt.Errorf("no encryption recipients configured")
+	}
+
+	args := make([]string, 0)
+	for _, recipient := range settings.Recipients {
+		args = append(args, "-r", recipient)
+	}
+	args = append(args, "-o", target, source)
+
+	output, err := exec.Command(ageCommand(settings.Command), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unable to encrypt %s: %v: %s", source, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// runDecrypt implements the decrypt command, which restores the original
+// photos from encrypted attachments.
+func runDecrypt(args []string) {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	identity := flags.String("i", "", "Identity file")
+	outputPath := flags.String("o", ".", "Output directory")
+	command := flags.String("age", "age", "age command")
+	flags.Parse(args)
+
+	if *identity == "" {
+		log.Fatal("Missing -i argument")
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: diary-automation decrypt -i identity [-o dir] file.age...")
+		os.Exit(2)
+	}
+
+	for _, file := range flags.Args() {
+		target := filepath.Join(*outputPath, strings.TrimSuffix(filepath.Base(file), encryptedExtension))
+		output, err := exec.Command(ageCommand(*command), "-d", "-i", *identity, "-o", target, file).CombinedOutput()
+		if err != nil {
+			log.Fatalf("unable to decrypt %s: %s: %s", file, err, strings.TrimSpace(string(output)))
+		}
+		logInfof("decrypted %s to %s", file, target)
+	}
+}
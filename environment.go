@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the environment variables that override settings. The
+// rest of the name is the path of the setting in capitals, so
+// DIARY_OBSIDIAN_FILE_PATH overrides obsidian_file_path and
+// DIARY_WATCH_POLL_INTERVAL_SECONDS overrides watch.poll_interval_seconds.
+// Lists are separated by commas. Lists of objects and maps, like sources
+// and profiles, can only be set in the settings file.
+const envPrefix = "DIARY_"
+
+// defaultSettingsFile is read when neither --config nor DIARY_CONFIG says
+// otherwise.
+const defaultSettingsFile = "settings.yaml"
+
+var errSettingsFileOnly = errors.New("only the settings file can set it")
+
+// settingsFilePath resolves the settings file from the flag, DIARY_CONFIG
+// or the settings.yaml of the working directory.
+func settingsFilePath(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if value := os.Getenv(envPrefix + "CONFIG"); value != "" {
+		return value
+	}
+	return defaultSettingsFile
+}
+
+// applyEnvironment overrides the settings with the environment variables.
+func applyEnvironment(settings *appSettings) error {
+	_, err := applyEnvironmentTo(reflect.ValueOf(settings).Elem(), envPrefix)
+	return err
+}
+
+// applyEnvironmentTo sets the fields of the struct from the variables that
+// start with the prefix. It tells whether any field was set, so optional
+// sections are only created when a variable sets something in them.
+func applyEnvironmentTo(value reflect.Value, prefix string) (bool, error) {
+	set := false
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("yaml")
+		name := strings.Split(tag, ",")[0]
+		fieldValue := value.Field(i)
+		if field.Anonymous && strings.Contains(tag, ",inline") {
+			fieldSet, err := applyEnvironmentTo(fieldValue, prefix)
+			if err != nil {
+				return set, err
+			}
+			set = set || fieldSet
+			continue
+		}
+		if name == "" || name == "-" || !fieldValue.CanSet() {
+			continue
+		}
+
+		key := prefix + strings.ToUpper(name)
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			fieldSet, err := applyEnvironmentTo(fieldValue, key+"_")
+			if err != nil {
+				return set, err
+			}
+			set = set || fieldSet
+		case field.Type.Kind() == reflect.Ptr && field.Type.Elem().Kind() == reflect.Struct:
+			section := reflect.New(field.Type.Elem())
+			if !fieldValue.IsNil() {
+				section.Elem().Set(fieldValue.Elem())
+			}
+			fieldSet, err := applyEnvironmentTo(section.Elem(), key+"_")
+			if err != nil {
+				return set, err
+			}
+			if fieldSet {
+				fieldValue.Set(section)
+				set = true
+			}
+		default:
+			text, ok := os.LookupEnv(key)
+			if !ok {
+				continue
+			}
+			if err := setFromText(fieldValue, text); err != nil {
+				return set, fmt.Errorf("invalid %s: %v", key, err)
+			}
+			set = true
+		}
+	}
+	return set, nil
+}
+
+func setFromText(value reflect.Value, text string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(text)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int, reflect.Int64:
+		parsed, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return err
+		}
+		value.SetInt(parsed)
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return err
+		}
+		value.SetFloat(parsed)
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return errSettingsFileOnly
+		}
+		items := make([]string, 0)
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		value.Set(reflect.ValueOf(items))
+	default:
+		return errSettingsFileOnly
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventPhotoDiscovered  = "photo.discovered"
+	eventPhotoImported    = "photo.imported"
+	eventPhotoQuarantined = "photo.quarantined"
+	eventPhotoRejected    = "photo.rejected"
+	eventPhotoStaged      = "photo.staged"
+	eventNoteCreated      = "note.created"
+	eventNoteUpdated      = "note.updated"
+	eventError            = "error"
+)
+
+// importEvent is published on the event bus when something happens in an
+// import. Subscribers get it as a JSON object.
+type importEvent struct {
+	Type    string `json:"type"`
+	Time    string `json:"time"`
+	Date    string `json:"date,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Note    string `json:"note,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// subscriberSettings subscribe a hook, a webhook or an audit log to the
+// events of the import. Events lists the event types, with photo.* for
+// every photo event, and all of them when it is empty. The command gets
+// the event as JSON on its standard input, the webhook as the body of a
+// POST request and the audit log as a JSON line.
+type subscriberSettings struct {
+	Name           string   `yaml:"name"`
+	Events         []string `yaml:"events"`
+	Command        []string `yaml:"command"`
+	Webhook        string   `yaml:"webhook"`
+	AuditLog       string   `yaml:"audit_log"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+}
+
+// eventSubscriber handles the events it subscribed to.
+type eventSubscriber interface {
+	handleEvent(event importEvent) error
+}
+
+type subscription struct {
+	name       string
+	events     []string
+	subscriber eventSubscriber
+}
+
+// eventBus delivers the published events to the subscribers in the order
+// they subscribed, the internal ones first. Delivery is synchronous, so the
+// events of a run are delivered before it exits.
+var eventBus = struct {
+	sync.Mutex
+	internal      []subscription
+	subscriptions []subscription
+}{}
+
+// configureEvents replaces the subscribers with the ones of the settings.
+func configureEvents(settings *appSettings) error {
+	subscriptions, err := settingsSubscriptions(settings)
+	if err != nil {
+		return err
+	}
+	eventBus.Lock()
+	defer eventBus.Unlock()
+	eventBus.subscriptions = subscriptions
+	return nil
+}
+
+// subscribeToEvents subscribes the subscribers of the settings for an
+// import. Other commands publish nothing.
+func subscribeToEvents(settings *appSettings) {
+	subscribe("status", nil, currentStatus)
+	subscribe("metrics", nil, currentMetrics)
+	subscribe("notifications", nil, currentNotifications)
+	subscribe("cast", nil, currentCast)
+	if err := configureEvents(settings); err != nil {
+		log.Fatalf("unable to subscribe to the events: %s", err)
+	}
+}
+
+// subscribe adds an internal subscriber, which stays subscribed when the
+// subscribers of the settings change.
+func subscribe(name string, events []string, subscriber eventSubscriber) {
+	eventBus.Lock()
+	defer eventBus.Unlock()
+	eventBus.internal = append(eventBus.internal, subscription{name, events, subscriber})
+}
+
+// settingsSubscriptions returns the subscriptions the settings declare.
+func settingsSubscriptions(settings *appSettings) ([]subscription, error) {
+	subscriptions := make([]subscription, 0, len(settings.Subscribers))
+	for i, config := range settings.Subscribers {
+		name := config.Name
+		if name == "" {
+			name = fmt.Sprintf("subscriber %d", i+1)
+		}
+		for _, eventType := range config.Events {
+			if !knownEventType(eventType) {
+				return nil, fmt.Errorf("%s: unknown event %s", name, eventType)
+			}
+		}
+		timeout := time.Duration(config.TimeoutSeconds) * time.Second
+		if timeout <= 0 {
+			timeout = 10 * time.Second
+		}
+
+		var subscriber eventSubscriber
+		switch {
+		case len(config.Command) > 0:
+			subscriber = commandSubscriber{config.Command, timeout}
+		case config.Webhook != "":
+			subscriber = webhookSubscriber{config.Webhook, &http.Client{Timeout: timeout}}
+		case config.AuditLog != "":
+			subscriber = auditLogSubscriber{config.AuditLog}
+		default:
+			return nil, fmt.Errorf("%s: set a command, a webhook or an audit log", name)
+		}
+		subscriptions = append(subscriptions, subscription{name, config.Events, subscriber})
+	}
+	return subscriptions, nil
+}
+
+func knownEventType(eventType string) bool {
+	switch eventType {
+	case eventPhotoDiscovered, eventPhotoImported, eventPhotoQuarantined, eventPhotoRejected, eventPhotoStaged, eventNoteCreated, eventNoteUpdated, eventError, "photo.*":
+		return true
+	}
+	return false
+}
+
+func (s subscription) wants(eventType string) bool {
+	if len(s.events) == 0 {
+		return true
+	}
+	for _, wanted := range s.events {
+		if wanted == eventType || (wanted == "photo.*" && strings.HasPrefix(eventType, "photo.")) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish delivers the event to its subscribers. A subscriber that fails
+// is logged as a warning, so its failure doesn't publish another error.
+func publish(event importEvent) {
+	eventBus.Lock()
+	subscriptions := append(append([]subscription{}, eventBus.internal...), eventBus.subscriptions...)
+	eventBus.Unlock()
+
+	if event.Time == "" {
+		event.Time = time.Now().Format(time.RFC3339)
+	}
+	for _, s := range subscriptions {
+		if !s.wants(event.Type) {
+			continue
+		}
+		if err := s.subscriber.handleEvent(event); err != nil {
+			logWarnf("%s failed to handle %s: %s", s.name, event.Type, err)
+		}
+	}
+}
+
+// commandSubscriber runs a hook command with the event on its standard
+// input.
+type commandSubscriber struct {
+	command []string
+	timeout time.Duration
+}
+
+func (s commandSubscriber) handleEvent(event importEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", s.command[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// webhookSubscriber posts the event to a URL.
+type webhookSubscriber struct {
+	url    string
+	client *http.Client
+}
+
+func (s webhookSubscriber) handleEvent(event importEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	response, err := s.client.Post(s.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", s.url, response.Status)
+	}
+	return nil
+}
+
+// auditLogSubscriber appends the events to a JSON lines file.
+type auditLogSubscriber struct {
+	filePath string
+}
+
+func (s auditLogSubscriber) handleEvent(event importEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(s.filePath), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
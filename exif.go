@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	exifIFDPointerTag    = 0x8769
+	gpsIFDPointerTag     = 0x8825
+	dateTimeOriginalTag  = 0x9003
+	dateTimeDigitizedTag = 0x9004
+	gpsLatitudeRefTag    = 0x0001
+	gpsLatitudeTag       = 0x0002
+	gpsLongitudeRefTag   = 0x0003
+	gpsLongitudeTag      = 0x0004
+	exifASCIIType        = 2
+	exifRationalType     = 5
+	exifMaxSegmentSize   = 1 << 16
+)
+
+// exifDate returns the time the photo was taken, read from the
+// DateTimeOriginal tag of its EXIF data, or DateTimeDigitized when the
+// original time is missing.
+func exifDate(tiff []byte) (time.Time, bool) {
+	for _, tag := range []uint16{dateTimeOriginalTag, dateTimeDigitizedTag} {
+		value, ok := exifString(tiff, tag)
+		if !ok {
+			continue
+		}
+		date, err := time.ParseInLocation("2006:01:02 15:04:05", value, time.Local)
+		if err == nil && date.Year() > 1900 {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// readEXIF returns the TIFF structure of the EXIF data of the photo, or nil
+// when it has none. JPEG and PNG photos are supported.
+func readEXIF(filePath string) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	header, err := reader.Peek(8)
+	if err != nil {
+		return nil, nil
+	}
+	if bytes.HasPrefix(header, []byte{0xFF, 0xD8}) {
+		reader.Discard(2)
+		return readJPEGEXIF(reader)
+	}
+	if bytes.Equal(header, []byte("\x89PNG\r\n\x1a\n")) {
+		reader.Discard(8)
+		return readPNGEXIF(reader)
+	}
+	return nil, nil
+}
+
+func readJPEGEXIF(reader *bufio.Reader) ([]byte, error) {
+	for {
+		var marker [4]byte
+		if _, err := io.ReadFull(reader, marker[:]); err != nil {
+			return nil, err
+		}
+		if marker[0] != 0xFF {
+			return nil, fmt.Errorf("invalid JPEG marker")
+		}
+		// The image data starts at the start of scan, metadata comes before it.
+		if marker[1] == 0xDA || marker[1] == 0xD9 {
+			return nil, nil
+		}
+		size := int(binary.BigEndian.Uint16(marker[2:])) - 2
+		if size < 0 {
+			return nil, fmt.Errorf("invalid JPEG segment")
+		}
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return nil, err
+		}
+		if marker[1] == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+func readPNGEXIF(reader *bufio.Reader) ([]byte, error) {
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			return nil, err
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		chunk := string(header[4:])
+		if chunk == "IDAT" || chunk == "IEND" {
+			return nil, nil
+		}
+		if chunk != "eXIf" || size > exifMaxSegmentSize {
+			if _, err := reader.Discard(int(size) + 4); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+}
+
+// exifGPS returns the location of the photo in decimal degrees, read from
+// the GPS IFD of its EXIF data.
+func exifGPS(tiff []byte) (float64, float64, bool) {
+	order, ok := tiffByteOrder(tiff)
+	if !ok {
+		return 0, 0, false
+	}
+	gpsIFD, ok := subIFD(tiff, order, gpsIFDPointerTag)
+	if !ok {
+		return 0, 0, false
+	}
+
+	coordinate := func(refTag uint16, valueTag uint16, negative string) (float64, bool) {
+		ref, ok := ifdString(tiff, order, gpsIFD, refTag)
+		if !ok {
+			return 0, false
+		}
+		entry, ok := ifdEntry(tiff, order, gpsIFD, valueTag)
+		if !ok || order.Uint16(entry[2:]) != exifRationalType || order.Uint32(entry[4:]) != 3 {
+			return 0, false
+		}
+		offset := order.Uint32(entry[8:])
+		if uint64(offset)+24 > uint64(len(tiff)) {
+			return 0, false
+		}
+		degrees := 0.0
+		for i, scale := range []float64{1, 60, 3600} {
+			numerator := order.Uint32(tiff[int(offset)+i*8:])
+			denominator := order.Uint32(tiff[int(offset)+i*8+4:])
+			if denominator == 0 {
+				return 0, false
+			}
+			degrees += float64(numerator) / float64(denominator) / scale
+		}
+		if ref == negative {
+			degrees = -degrees
+		}
+		return degrees, true
+	}
+
+	latitude, ok := coordinate(gpsLatitudeRefTag, gpsLatitudeTag, "S")
+	if !ok {
+		return 0, 0, false
+	}
+	longitude, ok := coordinate(gpsLongitudeRefTag, gpsLongitudeTag, "W")
+	if !ok {
+		return 0, 0, false
+	}
+	return latitude, longitude, true
+}
+
+func tiffByteOrder(tiff []byte) (binary.ByteOrder, bool) {
+	if len(tiff) < 8 {
+		return nil, false
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return binary.LittleEndian, true
+	case "MM":
+		return binary.BigEndian, true
+	}
+	return nil, false
+}
+
+// subIFD returns the offset of the IFD the pointer tag of IFD0 points to.
+func subIFD(tiff []byte, order binary.ByteOrder, pointerTag uint16) (uint32, bool) {
+	pointer, ok := ifdEntry(tiff, order, order.Uint32(tiff[4:]), pointerTag)
+	if !ok {
+		return 0, false
+	}
+	return order.Uint32(pointer[8:]), true
+}
+
+// exifString returns the ASCII value of the tag in the EXIF sub-IFD.
+func exifString(tiff []byte, tag uint16) (string, bool) {
+	order, ok := tiffByteOrder(tiff)
+	if !ok {
+		return "", false
+	}
+	exifIFD, ok := subIFD(tiff, order, exifIFDPointerTag)
+	if !ok {
+		return "", false
+	}
+	return ifdString(tiff, order, exifIFD, tag)
+}
+
+// ifdString returns the ASCII value of the tag in the IFD at the offset.
+func ifdString(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) (string, bool) {
+	entry, ok := ifdEntry(tiff, order, offset, tag)
+	if !ok || order.Uint16(entry[2:]) != exifASCIIType {
+		return "", false
+	}
+
+	count := order.Uint32(entry[4:])
+	value := entry[8:12]
+	if count > 4 {
+		offset := order.Uint32(entry[8:])
+		if uint64(offset)+uint64(count) > uint64(len(tiff)) {
+			return "", false
+		}
+		value = tiff[offset : offset+count]
+	} else {
+		value = value[:count]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00")), true
+}
+
+// ifdEntry returns the 12 byte entry of the tag in the IFD at the offset.
+func ifdEntry(tiff []byte, order binary.ByteOrder, offset uint32, tag uint16) ([]byte, bool) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, false
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(tiff) {
+			return nil, false
+		}
+		entry := tiff[start : start+12]
+		if order.Uint16(entry) == tag {
+			return entry, true
+		}
+	}
+	return nil, false
+}
@@ -2,4 +2,14 @@ module github.com/saaste/diary-automation
 
 go 1.18
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	github.com/expr-lang/expr v1.16.9
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/tetratelabs/wazero v1.3.1
+	go.etcd.io/bbolt v1.3.9
+	golang.org/x/text v0.14.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.5.0 // indirect
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// dateGuardSettings configure rejecting photos dated in the future or
+// before the earliest plausible date, e.g. after a camera clock reset to
+// 1970. Rejected photos are moved to the quarantine path when one is set
+// and otherwise left where they are.
+type dateGuardSettings struct {
+	EarliestDate   string `yaml:"earliest_date"`
+	QuarantinePath string `yaml:"quarantine_path"`
+}
+
+// guardPhotoDates removes the dates that are not plausible from the
+// scanned photos and quarantines their photos.
+func guardPhotoDates(photos map[string][]string, settings *appSettings) map[string][]string {
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+		if isPlausibleDate(date, settings.DateGuard) {
+			result[date] = datePhotos
+			continue
+		}
+
+		logWarnf("rejected %d photos with implausible date %s", len(datePhotos), date)
+		if settings.DateGuard.QuarantinePath == "" || settings.SourceReadOnly {
+			// The photos stay in the source folder and are rejected again by
+			// every import, so they are published only the first time.
+			for _, photo := range datePhotos {
+				if !rejectedPhotos[photo] {
+					rejectedPhotos[photo] = true
+					publish(importEvent{Type: eventPhotoRejected, Date: date, Source: photo, Message: "implausible date " + date})
+				}
+			}
+			continue
+		}
+		for _, photo := range datePhotos {
+			target := path.Join(settings.DateGuard.QuarantinePath, path.Base(photo))
+			if err := quarantinePhoto(photo, target); err != nil {
+				logFields{"source": photo, "target": target}.errorf("unable to quarantine %s: %s", photo, err)
+				continue
+			}
+			publish(importEvent{Type: eventPhotoQuarantined, Date: date, Source: photo, Target: target, Message: "implausible date " + date})
+		}
+	}
+
+	return result
+}
+
+func isPlausibleDate(date string, settings *dateGuardSettings) bool {
+	earliest := time.Date(1990, 1, 1, 0, 0, 0, 0, time.Local)
+	if settings.EarliestDate != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", settings.EarliestDate, time.Local)
+		if err != nil {
+			logErrorf("invalid earliest_date %s: %s", settings.EarliestDate, err)
+		} else {
+			earliest = parsed
+		}
+	}
+
+	day, err := parseDateKey(date)
+	return err == nil && !day.After(time.Now()) && !day.Before(earliest)
+}
+
+// parseDateKey parses the date of a note, which is either a day or, for
+// monthly notes, a month.
+func parseDateKey(date string) (time.Time, error) {
+	if isMonthKey(date) {
+		return time.ParseInLocation("2006-01", date, time.Local)
+	}
+	return time.ParseInLocation("2006-01-02", date, time.Local)
+}
+
+// rejectedPhotos are the photos left in the source folders that were
+// published as rejected.
+var rejectedPhotos = make(map[string]bool)
+
+// quarantinePhoto moves the photo to the target, unless an earlier photo
+// with the same name is there already.
+func quarantinePhoto(photo string, target string) error {
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", path.Dir(target), err)
+	}
+	if fileExists(target) {
+		return fmt.Errorf("the quarantine already has a file by that name")
+	}
+	return moveFile(photo, target)
+}
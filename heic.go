@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// heicSettings configure converting HEIC photos, which Obsidian can't show,
+// to JPEG with an external converter before they are imported. The command
+// gets {input} and {output} replaced with the file paths. The HEIC original
+// is deleted unless an original path is given to keep it in.
+type heicSettings struct {
+	Command      []string `yaml:"command"`
+	OriginalPath string   `yaml:"original_path"`
+}
+
+var heicBrands = []string{"heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1"}
+
+// convertHEICPhotos converts the HEIC photos in the original photo path to
+// JPEG photos with the same base name. It returns the HEIC names by the
+// JPEG names.
+func convertHEICPhotos(settings *appSettings) map[string]string {
+	converted := make(map[string]string)
+	files, err := os.ReadDir(settings.OriginalPhotoPath)
+	if err != nil {
+		logErrorf("unable to read path %s: %s", settings.OriginalPhotoPath, err)
+		return converted
+	}
+
+	for _, file := range files {
+		if isSkippedEntry(file, settings) || photoExtension(file.Name()) != "" {
+			continue
+		}
+		source := path.Join(settings.OriginalPhotoPath, file.Name())
+		if !isHEIC(source) {
+			continue
+		}
+
+		name := strings.TrimSuffix(file.Name(), path.Ext(file.Name())) + ".jpg"
+		target := path.Join(settings.OriginalPhotoPath, name)
+		if fileExists(target) {
+			logFields{"source": source, "target": target}.infof("skipped %s, %s already exists", file.Name(), name)
+			continue
+		}
+		start := time.Now()
+		err := convertHEIC(source, target, settings.HEIC)
+		timeFileStage(target, "convert", start)
+		if err != nil {
+			os.Remove(target)
+			logFields{"source": source, "target": target}.errorf("unable to convert %s: %s", file.Name(), err)
+			continue
+		}
+		if err := disposeConverted(source, settings.HEIC.OriginalPath); err != nil {
+			logFields{"source": source}.errorf("unable to remove %s: %s", file.Name(), err)
+		}
+		logFields{"source": source, "target": target}.infof("converted %s to %s", file.Name(), name)
+		converted[name] = file.Name()
+	}
+	return converted
+}
+
+// isHEIC tells by the file type box whether the file is a HEIC or HEIF
+// image, whatever its extension.
+func isHEIC(filePath string) bool {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	if !bytes.Equal(header[4:8], []byte("ftyp")) {
+		return false
+	}
+	brand := string(header[8:])
+	for _, heicBrand := range heicBrands {
+		if brand == heicBrand {
+			return true
+		}
+	}
+	return false
+}
+
+func isHEICName(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".heic" || ext == ".heif"
+}
+
+func convertHEIC(source string, target string, settings *heicSettings) error {
+	command := settings.Command
+	if len(command) == 0 {
+		command = defaultHEICCommand()
+	}
+	return runImageCommand(command, source, target, nil, 0)
+}
+
+// defaultHEICCommand uses sips, which comes with macOS, or heif-convert
+// from libheif elsewhere.
+func defaultHEICCommand() []string {
+	if runtime.GOOS == "darwin" {
+		return []string{"sips", "-s", "format", "jpeg", "{input}", "--out", "{output}"}
+	}
+	return []string{"heif-convert", "-q", "90", "{input}", "{output}"}
+}
+
+// disposeConverted deletes the original of a converted photo, or moves it
+// to the original path when one is given.
+func disposeConverted(source string, originalPath string) error {
+	if originalPath == "" {
+		return os.Remove(source)
+	}
+	if err := os.MkdirAll(originalPath, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", originalPath, err)
+	}
+	return moveFile(source, path.Join(originalPath, path.Base(source)))
+}
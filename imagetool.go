@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imageToolSettings hand the image work Go can't do well, like 16-bit TIFF
+// scans, to an external tool such as vips or ImageMagick. The resize
+// command replaces the built-in downscaling of the quota and the offload
+// placeholders and gets {input}, {output} and {size}, the longer side in
+// pixels. The convert command turns the files with the given extensions
+// into JPEG photos before they are imported and gets {input} and {output}.
+// The converted original is deleted unless an original path is given to
+// keep it in.
+type imageToolSettings struct {
+	Resize         []string `yaml:"resize"`
+	Convert        []string `yaml:"convert"`
+	Extensions     []string `yaml:"extensions"`
+	OriginalPath   string   `yaml:"original_path"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+}
+
+var imageToolPlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+// validateImageCommand checks that the command only uses the known
+// placeholders, and none in the program name.
+func validateImageCommand(name string, command []string, placeholders ...string) error {
+	if len(command) == 0 {
+		return nil
+	}
+	if imageToolPlaceholder.MatchString(command[0]) {
+		return fmt.Errorf("%s: the program can't be a placeholder", name)
+	}
+	known := strings.Join(placeholders, " ")
+	used := make(map[string]bool)
+	for _, arg := range command[1:] {
+		for _, placeholder := range imageToolPlaceholder.FindAllString(arg, -1) {
+			if !strings.Contains(known, placeholder) {
+				return fmt.Errorf("%s: unknown placeholder %s", name, placeholder)
+			}
+			used[placeholder] = true
+		}
+	}
+	for _, placeholder := range []string{"{input}", "{output}"} {
+		if !used[placeholder] {
+			return fmt.Errorf("%s: the command needs %s", name, placeholder)
+		}
+	}
+	return nil
+}
+
+// runImageCommand runs the command on a copy of the source in a scratch
+// folder and moves its output to the target. The tool only ever sees the
+// names input and output there, so file names that look like options or
+// ImageMagick coders, like -rf.jpg or msl:x.jpg, can't change what it
+// does. It runs without a shell, with a minimal environment and a timeout.
+func runImageCommand(command []string, source string, target string, values map[string]string, timeout time.Duration) error {
+	dir, err := os.MkdirTemp("", "diary-image-")
+	if err != nil {
+		return fmt.Errorf("unable to create a scratch folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := path.Join(dir, "input"+strings.ToLower(path.Ext(source)))
+	output := path.Join(dir, "output"+strings.ToLower(path.Ext(target)))
+	if err := os.Link(source, input); err != nil {
+		if err := copyFile(source, input); err != nil {
+			return fmt.Errorf("unable to copy %s: %v", source, err)
+		}
+	}
+
+	replacements := []string{"{input}", input, "{output}", output}
+	for placeholder, value := range values {
+		replacements = append(replacements, placeholder, value)
+	}
+	replacer := strings.NewReplacer(replacements...)
+	args := make([]string, 0, len(command)-1)
+	for _, arg := range command[1:] {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + os.Getenv("HOME"), "TMPDIR=" + dir}
+	result, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s did not finish in %s", command[0], timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", command[0], err, strings.TrimSpace(string(result)))
+	}
+	if !fileExists(output) {
+		return fmt.Errorf("%s did not write its output", command[0])
+	}
+	return moveFile(output, target)
+}
+
+func imageToolTimeout(settings *imageToolSettings) time.Duration {
+	return time.Duration(settings.TimeoutSeconds) * time.Second
+}
+
+// resizeWithImageTool scales the photo down with the resize command,
+// keeping its name and modification time. It returns the number of bytes
+// saved.
+func resizeWithImageTool(filePath string, maxDimension int, settings *imageToolSettings) (int64, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	ext := path.Ext(filePath)
+	temp := strings.TrimSuffix(filePath, ext) + ".tmp" + ext
+	values := map[string]string{"{size}": strconv.Itoa(maxDimension)}
+	if err := runImageCommand(settings.Resize, filePath, temp, values, imageToolTimeout(settings)); err != nil {
+		os.Remove(temp)
+		return 0, err
+	}
+	if err := os.Chtimes(temp, time.Now(), info.ModTime()); err != nil {
+		os.Remove(temp)
+		return 0, err
+	}
+	scaledInfo, err := os.Stat(temp)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(temp, filePath); err != nil {
+		os.Remove(temp)
+		return 0, err
+	}
+	return info.Size() - scaledInfo.Size(), nil
+}
+
+// isImageToolExtension tells whether the file is converted by the convert
+// command.
+func isImageToolExtension(name string, settings *imageToolSettings) bool {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	for _, extension := range settings.Extensions {
+		if strings.ToLower(strings.TrimPrefix(extension, ".")) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// convertImageToolPhotos converts the files with the configured extensions
+// in the original photo path to JPEG photos with the same base name. It
+// returns the original names by the JPEG names.
+func convertImageToolPhotos(settings *appSettings) map[string]string {
+	converted := make(map[string]string)
+	tool := settings.ImageTool
+	files, err := os.ReadDir(settings.OriginalPhotoPath)
+	if err != nil {
+		logErrorf("unable to read path %s: %s", settings.OriginalPhotoPath, err)
+		return converted
+	}
+
+	for _, file := range files {
+		if isSkippedEntry(file, settings) || !isImageToolExtension(file.Name(), tool) {
+			continue
+		}
+		source := path.Join(settings.OriginalPhotoPath, file.Name())
+		name := strings.TrimSuffix(file.Name(), path.Ext(file.Name())) + ".jpg"
+		target := path.Join(settings.OriginalPhotoPath, name)
+		fields := logFields{"source": source, "target": target}
+		if fileExists(target) {
+			fields.infof("skipped %s, %s already exists", file.Name(), name)
+			continue
+		}
+		start := time.Now()
+		err := runImageCommand(tool.Convert, source, target, nil, imageToolTimeout(tool))
+		timeFileStage(target, "convert", start)
+		if err != nil {
+			os.Remove(target)
+			fields.errorf("unable to convert %s: %s", file.Name(), err)
+			continue
+		}
+		if err := disposeConverted(source, tool.OriginalPath); err != nil {
+			logFields{"source": source}.errorf("unable to remove %s: %s", file.Name(), err)
+		}
+		fields.infof("converted %s to %s", file.Name(), name)
+		converted[name] = file.Name()
+	}
+	return converted
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapSettings configure importing the image attachments of the unread
+// messages in an IMAP folder, dated by the Date header or a date in the
+// subject. Imported messages are marked read or moved to another folder;
+// other messages are left as they are.
+type imapSettings struct {
+	URL      string   `yaml:"url"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	Folder   string   `yaml:"folder"`
+	From     []string `yaml:"from"`
+	DateFrom string   `yaml:"date_from"`
+	MoveTo   string   `yaml:"move_to"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+// imapResponse is an untagged response of the server with the literals
+// it contained.
+type imapResponse struct {
+	text     string
+	literals [][]byte
+}
+
+type imapConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	tag    int
+}
+
+const imapTimeout = 60 * time.Second
+
+var (
+	imapLiteralPattern      = regexp.MustCompile(`\{(\d+)\}\r\n$`)
+	imapInternalDatePattern = regexp.MustCompile(`INTERNALDATE "([^"]+)"`)
+	subjectDatePatterns     = []*regexp.Regexp{
+		regexp.MustCompile(`\b(\d{4})-(\d{1,2})-(\d{1,2})\b`),
+		regexp.MustCompile(`\b(\d{1,2})\.(\d{1,2})\.(\d{4})\b`),
+	}
+)
+
+// importIMAPMessages copies the image attachments of the unread messages
+// in the folder into the original photo path, named by the message date.
+func importIMAPMessages(settings *appSettings) (int, error) {
+	config := settings.IMAP
+	if config.DateFrom != "" && config.DateFrom != "header" && config.DateFrom != "subject" {
+		return 0, fmt.Errorf("unknown imap date_from %q", config.DateFrom)
+	}
+	folder := config.Folder
+	if folder == "" {
+		folder = "INBOX"
+	}
+
+	client, err := dialIMAP(config.URL)
+	if err != nil {
+		return 0, err
+	}
+	defer client.close()
+
+	if _, err := client.command("LOGIN %s %s", imapQuote(config.Username), imapQuote(config.Password)); err != nil {
+		return 0, fmt.Errorf("unable to log in: %v", err)
+	}
+	if _, err := client.command("SELECT %s", imapQuote(folder)); err != nil {
+		return 0, fmt.Errorf("unable to select %s: %v", folder, err)
+	}
+
+	responses, err := client.command("UID SEARCH UNSEEN%s", imapFromCriteria(config.From))
+	if err != nil {
+		return 0, fmt.Errorf("unable to search %s: %v", folder, err)
+	}
+	uids := make([]string, 0)
+	for _, response := range responses {
+		if fields := strings.Fields(response.text); len(fields) > 1 && fields[0] == "SEARCH" {
+			uids = append(uids, fields[1:]...)
+		}
+	}
+
+	count := 0
+	for _, uid := range uids {
+		responses, err := client.command("UID FETCH %s (INTERNALDATE BODY.PEEK[])", uid)
+		if err != nil {
+			return count, fmt.Errorf("unable to fetch message %s: %v", uid, err)
+		}
+		var data []byte
+		received := time.Now()
+		for _, response := range responses {
+			if !strings.Contains(response.text, "FETCH") || len(response.literals) == 0 {
+				continue
+			}
+			data = response.literals[0]
+			if match := imapInternalDatePattern.FindStringSubmatch(response.text); match != nil {
+				if date, err := time.Parse("2-Jan-2006 15:04:05 -0700", strings.TrimSpace(match[1])); err == nil {
+					received = date
+				}
+			}
+		}
+		if data == nil {
+			continue
+		}
+
+		message, err := mail.ReadMessage(bytes.NewReader(data))
+		if err != nil {
+			logWarnf("unable to read the message %s from %s: %s", uid, folder, err)
+			continue
+		}
+		date := messageDate(message, received, config)
+		attachments, err := imageAttachments(message)
+		if err != nil {
+			logWarnf("unable to read the attachments of the message %s from %s: %s", uid, folder, err)
+			continue
+		}
+		if len(attachments) == 0 {
+			continue
+		}
+
+		for _, attachment := range attachments {
+			name, err := freePhotoName(date, attachment.ext, settings)
+			if err != nil {
+				return count, err
+			}
+			if err := os.WriteFile(path.Join(settings.OriginalPhotoPath, name), attachment.data, 0644); err != nil {
+				return count, fmt.Errorf("unable to write %s: %v", name, err)
+			}
+			count++
+		}
+
+		if config.MoveTo != "" {
+			_, err = client.command("UID MOVE %s %s", uid, imapQuote(config.MoveTo))
+		} else {
+			_, err = client.command("UID STORE %s +FLAGS.SILENT (\\Seen)", uid)
+		}
+		if err != nil {
+			return count, fmt.Errorf("unable to mark the message %s imported: %v", uid, err)
+		}
+	}
+
+	client.command("LOGOUT")
+	return count, nil
+}
+
+// messageDate returns the date of the message: a date in the subject with
+// date_from subject, or the Date header, or when the server received it.
+func messageDate(message *mail.Message, received time.Time, config *imapSettings) time.Time {
+	if config.DateFrom == "subject" {
+		subject := message.Header.Get("Subject")
+		if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err == nil {
+			subject = decoded
+		}
+		if date, ok := subjectDate(subject); ok {
+			return date
+		}
+	}
+	if date, err := message.Header.Date(); err == nil {
+		return date.Local()
+	}
+	return received.Local()
+}
+
+// subjectDate finds a date like 2024-05-01 or 1.5.2024 in the subject.
+func subjectDate(subject string) (time.Time, bool) {
+	for i, pattern := range subjectDatePatterns {
+		match := pattern.FindStringSubmatch(subject)
+		if match == nil {
+			continue
+		}
+		year, _ := strconv.Atoi(match[1])
+		month, _ := strconv.Atoi(match[2])
+		day, _ := strconv.Atoi(match[3])
+		if i == 1 {
+			year, day = day, year
+		}
+		value := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+		if date, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+type mailAttachment struct {
+	ext  string
+	data []byte
+}
+
+// imageAttachments returns the JPEG and PNG parts of the message.
+func imageAttachments(message *mail.Message) ([]mailAttachment, error) {
+	return imageParts(textproto.MIMEHeader(message.Header), message.Body)
+}
+
+func imageParts(header textproto.MIMEHeader, body io.Reader) ([]mailAttachment, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		result := make([]mailAttachment, 0)
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return result, nil
+			}
+			if err != nil {
+				return result, err
+			}
+			attachments, err := imageParts(part.Header, part)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, attachments...)
+		}
+	}
+
+	ext := extensionForContentType(mediaType)
+	if ext == "" && mediaType == "application/octet-stream" {
+		ext = extensionForContentType(mime.TypeByExtension(strings.ToLower(path.Ext(attachmentName(header)))))
+	}
+	if ext == "" {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Get("Content-Transfer-Encoding"))) {
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	return []mailAttachment{{ext: ext, data: data}}, nil
+}
+
+func attachmentName(header textproto.MIMEHeader) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return params["filename"]
+	}
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+		return params["name"]
+	}
+	return ""
+}
+
+// imapFromCriteria limits the search to the senders, if any are set.
+func imapFromCriteria(from []string) string {
+	if len(from) == 0 {
+		return ""
+	}
+	criteria := "FROM " + imapQuote(from[len(from)-1])
+	for i := len(from) - 2; i >= 0; i-- {
+		criteria = "OR FROM " + imapQuote(from[i]) + " " + criteria
+	}
+	return " " + criteria
+}
+
+func imapQuote(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+// dialIMAP connects to an imaps:// URL over TLS or to an imap:// URL
+// without it, which is only meant for local bridges.
+func dialIMAP(rawURL string) (*imapConn, error) {
+	address, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid imap url: %v", err)
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: imapTimeout}
+	switch address.Scheme {
+	case "imaps":
+		host := address.Host
+		if address.Port() == "" {
+			host = net.JoinHostPort(address.Hostname(), "993")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: address.Hostname()})
+	case "imap":
+		host := address.Host
+		if address.Port() == "" {
+			host = net.JoinHostPort(address.Hostname(), "143")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	default:
+		return nil, fmt.Errorf("unsupported imap url %s, use imaps://", rawURL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %v", address.Host, err)
+	}
+
+	client := &imapConn{conn: conn, reader: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(imapTimeout))
+	greeting, err := client.readLine()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to read the greeting of %s: %v", address.Host, err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("%s refused the connection: %s", address.Host, strings.TrimSpace(greeting))
+	}
+	return client, nil
+}
+
+func (c *imapConn) close() {
+	c.conn.Close()
+}
+
+func (c *imapConn) readLine() (string, error) {
+	return c.reader.ReadString('\n')
+}
+
+// command sends a command and returns the untagged responses, or an error
+// if the server did not answer OK.
+func (c *imapConn) command(format string, args ...interface{}) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+	c.conn.SetDeadline(time.Now().Add(imapTimeout))
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	responses := make([]imapResponse, 0)
+	for {
+		response, err := c.readResponse()
+		if err != nil {
+			return responses, err
+		}
+		switch {
+		case strings.HasPrefix(response.text, "* "):
+			response.text = strings.TrimPrefix(response.text, "* ")
+			responses = append(responses, response)
+		case strings.HasPrefix(response.text, tag+" "):
+			status := strings.TrimPrefix(response.text, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return responses, fmt.Errorf("%s", status)
+			}
+			return responses, nil
+		}
+	}
+}
+
+// readResponse reads a response line with the literals it continues on.
+func (c *imapConn) readResponse() (imapResponse, error) {
+	var response imapResponse
+	var text strings.Builder
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return response, err
+		}
+		match := imapLiteralPattern.FindStringSubmatch(line)
+		if match == nil {
+			text.WriteString(strings.TrimRight(line, "\r\n"))
+			response.text = text.String()
+			return response, nil
+		}
+		text.WriteString(line[:len(line)-len(match[0])])
+		size, _ := strconv.Atoi(match[1])
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.reader, literal); err != nil {
+			return response, err
+		}
+		response.literals = append(response.literals, literal)
+	}
+}
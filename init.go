@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// initSettings are the settings the init command asks for, written in the
+// order of settings.sample.
+type initSettings struct {
+	OriginalPhotoPath string `yaml:"original_photo_path"`
+	TargetPhotoPath   string `yaml:"target_photo_path"`
+	ObsidianFilePath  string `yaml:"obsidian_file_path"`
+	ImagePrefix       string `yaml:"image_prefix"`
+}
+
+const initHeader = `# Written by diary-automation init. See settings.sample for the optional
+# settings.
+`
+
+// runInit asks for the folders and the image prefix, checks that the
+// folders exist and writes a settings file with them.
+func runInit(args []string) {
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	output := flags.String("o", settingsFilePath(""), "Settings file to write")
+	force := flags.Bool("force", false, "Overwrite an existing settings file")
+	flags.Parse(args)
+
+	if fileExists(*output) && !*force {
+		log.Fatalf("%s exists already, use -force to overwrite it", *output)
+	}
+
+	prompt := initPrompt{bufio.NewReader(os.Stdin), os.Stdout}
+	var settings initSettings
+	var err error
+	if settings.OriginalPhotoPath, err = prompt.folder("Folder the photos are synced to", "", false); err != nil {
+		log.Fatal(err)
+	}
+	if settings.ObsidianFilePath, err = prompt.folder("Folder of the daily notes in the vault", "", false); err != nil {
+		log.Fatal(err)
+	}
+	attachments := filepath.Join(settings.ObsidianFilePath, "diary-attachments")
+	if settings.TargetPhotoPath, err = prompt.folder("Attachment folder the photos are moved to", attachments, true); err != nil {
+		log.Fatal(err)
+	}
+	if settings.ImagePrefix, err = prompt.ask("Prefix of the photo names in the vault", "diary-image-"); err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		log.Fatalf("unable to encode the settings: %s", err)
+	}
+	if err := os.WriteFile(*output, append([]byte(initHeader), data...), 0644); err != nil {
+		log.Fatalf("unable to write %s: %s", *output, err)
+	}
+	if _, err := readSettings(*output, ""); err != nil {
+		log.Fatalf("wrote %s, but it is not valid: %s", *output, err)
+	}
+	fmt.Printf("Wrote %s. Run diary-automation --config %s to import.\n", *output, *output)
+}
+
+type initPrompt struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// ask asks for a value, which is the default when the answer is empty.
+func (p initPrompt) ask(question string, defaultValue string) (string, error) {
+	for {
+		if defaultValue != "" {
+			fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", question)
+		}
+		answer, err := p.in.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if err != nil && (err != io.EOF || answer == "") {
+			return "", fmt.Errorf("no answer to %q", question)
+		}
+		if answer == "" {
+			answer = defaultValue
+		}
+		if answer != "" {
+			return answer, nil
+		}
+	}
+}
+
+// folder asks for a folder until the answer is one that exists. A missing
+// folder is created on request when create is set.
+func (p initPrompt) folder(question string, defaultValue string, create bool) (string, error) {
+	for {
+		answer, err := p.ask(question, defaultValue)
+		if err != nil {
+			return "", err
+		}
+		folder, err := absoluteFolder(answer)
+		if err != nil {
+			fmt.Fprintf(p.out, "%s\n", err)
+			continue
+		}
+
+		info, err := os.Stat(folder)
+		if err == nil && info.IsDir() {
+			return folder, nil
+		}
+		if err == nil {
+			fmt.Fprintf(p.out, "%s is not a folder\n", folder)
+			continue
+		}
+		if !os.IsNotExist(err) {
+			fmt.Fprintf(p.out, "unable to read %s: %s\n", folder, err)
+			continue
+		}
+		if !create {
+			fmt.Fprintf(p.out, "%s does not exist\n", folder)
+			continue
+		}
+		confirm, err := p.ask(fmt.Sprintf("%s does not exist, create it? (y/n)", folder), "y")
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(strings.ToLower(confirm), "y") {
+			continue
+		}
+		if err := os.MkdirAll(folder, 0755); err != nil {
+			fmt.Fprintf(p.out, "unable to create %s: %s\n", folder, err)
+			continue
+		}
+		return folder, nil
+	}
+}
+
+// absoluteFolder expands ~ to the home folder and makes the path absolute,
+// so the settings work from any working directory.
+func absoluteFolder(folder string) (string, error) {
+	if folder == "~" || strings.HasPrefix(folder, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("unable to find the home folder: %v", err)
+		}
+		folder = filepath.Join(home, strings.TrimPrefix(folder, "~"))
+	}
+	return filepath.Abs(folder)
+}
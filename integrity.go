@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const linkReportNoteName = "Link Report.md"
+
+var managedLinkPattern = regexp.MustCompile(`\[\[([^\]|#]+)[^\]]*\]\]|\[([^\]]+)\]\(<((?:file|https?)://[^>]*)>\)`)
+
+// brokenLink is a link to a managed attachment that doesn't resolve.
+type brokenLink struct {
+	note   string
+	name   string
+	reason string
+}
+
+// updateLinkReport checks the links of the notes a batch operation changed
+// and rewrites the link report note with the links that don't resolve.
+func updateLinkReport(operation string, notes []string, settings *appSettings) error {
+	broken, err := checkLinkIntegrity(notes, settings)
+	if err != nil {
+		return err
+	}
+
+	report := fmt.Sprintf("# Link Report\n\nChecked %d notes after %s on %s.\n\n", len(notes), operation, time.Now().Format("2006-01-02 15:04"))
+	if len(broken) == 0 {
+		report = report + "Every link resolves.\n"
+	}
+	for _, link := range broken {
+		report = report + fmt.Sprintf("- [[%s]]: %s %s\n", strings.TrimSuffix(link.note, ".md"), link.name, link.reason)
+	}
+	if len(broken) > 0 {
+		logInfof("found %d broken links, see %s", len(broken), linkReportNoteName)
+	}
+
+	reportPath := path.Join(settings.ObsidianFilePath, linkReportNoteName)
+	return rewriteNote(reportPath, settings, func(original []byte) []byte {
+		return []byte(report)
+	})
+}
+
+// checkLinkIntegrity returns the links to the attachments of the source
+// folders in the notes that point to missing files. Links to offloaded
+// attachments must be in the offload index.
+func checkLinkIntegrity(notes []string, settings *appSettings) ([]brokenLink, error) {
+	prefixes := make([]string, 0)
+	dirs := make([]string, 0)
+	for _, folder := range sourceFolders(settings) {
+		prefixes = append(prefixes, folder.ImagePrefix)
+		dirs = append(dirs, folder.TargetPhotoPath)
+		if folder.LargeFilePath != "" {
+			dirs = append(dirs, folder.LargeFilePath)
+		}
+	}
+	offloaded := make(map[string]*offloadedFile)
+	if settings.Offload != nil {
+		index, err := readOffloadIndex(settings)
+		if err != nil {
+			return nil, err
+		}
+		offloaded = index
+	}
+
+	broken := make([]brokenLink, 0)
+	for _, note := range notes {
+		data, err := os.ReadFile(path.Join(settings.ObsidianFilePath, note))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read note %s: %v", note, err)
+		}
+
+		for _, match := range managedLinkPattern.FindAllStringSubmatch(string(data), -1) {
+			name := normalizeName(path.Base(strings.TrimSpace(match[1] + match[2])))
+			if !hasAnyPrefix(name, prefixes) {
+				continue
+			}
+			if reason := resolveLink(name, match[3], dirs, offloaded); reason != "" {
+				broken = append(broken, brokenLink{note, name, reason})
+			}
+		}
+	}
+	return broken, nil
+}
+
+// resolveLink returns why the link to the attachment doesn't resolve, or an
+// empty string when it does.
+func resolveLink(name string, target string, dirs []string, offloaded map[string]*offloadedFile) string {
+	if strings.HasPrefix(target, "http") {
+		if _, ok := offloaded[name]; !ok {
+			return "links to a remote copy that is not in the offload index"
+		}
+		return ""
+	}
+	if target != "" {
+		u, err := url.Parse(target)
+		if err != nil || !fileExists(u.Path) {
+			return "links to a missing file"
+		}
+		return ""
+	}
+
+	for _, dir := range dirs {
+		if _, ok := findFile(dir, name); ok {
+			return ""
+		}
+	}
+	if _, ok := offloaded[name]; ok {
+		return "is offloaded but still linked as an attachment"
+	}
+	return "is missing from the attachments"
+}
+
+// reportAttachmentLinks updates the link report for the notes that link to
+// the attachments, along with the given notes.
+func reportAttachmentLinks(operation string, attachments []string, notes []string, settings *appSettings) error {
+	linking, err := notesLinking(attachments, settings)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool)
+	affected := make([]string, 0, len(notes)+len(linking))
+	for _, note := range append(notes, linking...) {
+		if !seen[note] {
+			seen[note] = true
+			affected = append(affected, note)
+		}
+	}
+	sort.Strings(affected)
+	return updateLinkReport(operation, affected, settings)
+}
+
+// notesForDates returns the notes of the dates.
+func notesForDates(dates []string, settings *appSettings) []string {
+	notes := make([]string, 0, len(dates))
+	for _, date := range dates {
+		if relative, err := filepath.Rel(settings.ObsidianFilePath, notePath(date, settings)); err == nil {
+			notes = append(notes, filepath.ToSlash(relative))
+		}
+	}
+	return notes
+}
+
+// notesLinking returns the notes that link to any of the attachments.
+func notesLinking(attachments []string, settings *appSettings) ([]string, error) {
+	names := make(map[string]bool)
+	for _, attachment := range attachments {
+		names[normalizeName(path.Base(attachment))] = true
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	notes, err := noteFiles(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0)
+	for _, note := range notes {
+		if note == linkReportNoteName {
+			continue
+		}
+		data, err := os.ReadFile(path.Join(settings.ObsidianFilePath, note))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read note %s: %v", note, err)
+		}
+		for _, match := range managedLinkPattern.FindAllStringSubmatch(string(data), -1) {
+			if names[normalizeName(path.Base(strings.TrimSpace(match[1]+match[2])))] {
+				result = append(result, note)
+				break
+			}
+		}
+	}
+	return result, nil
+}
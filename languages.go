@@ -0,0 +1,88 @@
+package main
+
+import "strings"
+
+// languageSettings hold the texts of a note in one language. With several
+// languages the texts are joined with the language separator, so headings
+// and captions appear in every language.
+type languageSettings struct {
+	Lang          string `yaml:"lang"`
+	Heading       string `yaml:"heading"`
+	EventsHeading string `yaml:"events_heading"`
+	MorePhotos    string `yaml:"more_photos"`
+}
+
+type noteLabels struct {
+	section    string
+	events     string
+	morePhotos string
+}
+
+// labelsForNote returns the headings and titles used in the notes.
+func labelsForNote(settings *appSettings) noteLabels {
+	if len(settings.Languages) == 0 {
+		return noteLabels{sectionHeading, "### Tapahtumat", "Lisää kuvia"}
+	}
+
+	headings := make([]string, 0, len(settings.Languages))
+	events := make([]string, 0, len(settings.Languages))
+	morePhotos := make([]string, 0, len(settings.Languages))
+	for _, language := range settings.Languages {
+		headings = append(headings, language.Heading)
+		events = append(events, language.EventsHeading)
+		morePhotos = append(morePhotos, language.MorePhotos)
+	}
+	separator := languageSeparator(settings)
+	return noteLabels{
+		section:    "### " + joinTexts(headings, separator),
+		events:     "### " + joinTexts(events, separator),
+		morePhotos: joinTexts(morePhotos, separator),
+	}
+}
+
+// isSectionHeading tells whether the line starts a photo section, either in
+// the configured languages or with the default heading.
+func isSectionHeading(line string, settings *appSettings) bool {
+	line = strings.TrimSpace(line)
+	return line == sectionHeading || line == labelsForNote(settings).section
+}
+
+// localizedCaption returns the caption in every configured language that
+// has one, falling back to the default caption.
+func localizedCaption(sidecar *xmpSidecar, settings *appSettings) string {
+	if len(settings.Languages) == 0 {
+		return sidecar.Caption
+	}
+
+	captions := make([]string, 0, len(settings.Languages))
+	for _, language := range settings.Languages {
+		caption, ok := sidecar.Captions[language.Lang]
+		if !ok {
+			caption = sidecar.Caption
+		}
+		captions = append(captions, caption)
+	}
+	return joinTexts(captions, languageSeparator(settings))
+}
+
+func languageSeparator(settings *appSettings) string {
+	if settings.LanguageSeparator == "" {
+		return " / "
+	}
+	return settings.LanguageSeparator
+}
+
+// joinTexts joins the non-empty texts, leaving out repeated ones.
+func joinTexts(texts []string, separator string) string {
+	result := make([]string, 0, len(texts))
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		text = strings.TrimSpace(text)
+		if text == "" || seen[text] {
+			continue
+		}
+		seen[text] = true
+		result = append(result, text)
+	}
+	return strings.Join(result, separator)
+}
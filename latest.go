@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// latestFolderDate is the day the latest photo folder was last rebuilt
+// for.
+var latestFolderDate string
+
+// updateLatestFolder rebuilds the latest photo folder so it contains only
+// the photos imported for today. The folder is meant for digital photo
+// frames and such, so anything else in it is removed. It is rebuilt only
+// when the import moved photos or the day changed, so a frame syncing the
+// folder doesn't see it emptied on every poll.
+func updateLatestFolder(settings *appSettings, imported map[string][]string) error {
+	today := time.Now().Format("2006-01-02")
+	if len(imported) == 0 && latestFolderDate == today {
+		return nil
+	}
+	latestPath := settings.LatestPhotoPath
+	if err := os.MkdirAll(latestPath, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", latestPath, err)
+	}
+
+	existing, err := os.ReadDir(latestPath)
+	if err != nil {
+		return fmt.Errorf("unable to read path %s: %v", latestPath, err)
+	}
+	for _, file := range existing {
+		if file.IsDir() {
+			continue
+		}
+		if err := os.Remove(path.Join(latestPath, file.Name())); err != nil {
+			return fmt.Errorf("unable to remove %s: %v", file.Name(), err)
+		}
+	}
+
+	photos, err := todaysPhotos(settings)
+	if err != nil {
+		return err
+	}
+
+	for _, photo := range photos {
+		source, name := photo.path, photo.name
+		target := path.Join(latestPath, name)
+		if settings.LatestUseSymlinks {
+			err = os.Symlink(source, target)
+		} else {
+			err = copyFile(source, target)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to add %s to %s: %v", name, latestPath, err)
+		}
+	}
+
+	latestFolderDate = today
+	return nil
+}
+
+// latestPhoto is a photo of today and the name it has in the latest photo
+// folder.
+type latestPhoto struct {
+	path string
+	name string
+}
+
+// todaysPhotos returns the moved photos and videos of every source folder
+// that belong to today's note. Sidecars and encrypted attachments are left
+// out, and so are the folders of sources that share a target photo path.
+// A photo with the same name as one of another folder is named after its
+// source.
+func todaysPhotos(settings *appSettings) ([]latestPhoto, error) {
+	result := make([]latestPhoto, 0)
+	seenFolders := make(map[string]bool)
+	seenNames := make(map[string]bool)
+	today := time.Now().Format("2006-01-02")
+	for _, folder := range sourceFolders(settings) {
+		if seenFolders[folder.TargetPhotoPath] {
+			continue
+		}
+		seenFolders[folder.TargetPhotoPath] = true
+
+		files, err := os.ReadDir(folder.TargetPhotoPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read path %s: %v", folder.TargetPhotoPath, err)
+		}
+
+		for _, file := range files {
+			if file.IsDir() || !strings.HasPrefix(file.Name(), folder.ImagePrefix) {
+				continue
+			}
+			if date, ok := getDateFromFile(strings.TrimPrefix(file.Name(), folder.ImagePrefix)); !ok || date != today {
+				continue
+			}
+			name := file.Name()
+			if seenNames[name] {
+				name = sourceName(folder) + "-" + name
+			}
+			seenNames[name] = true
+			result = append(result, latestPhoto{path.Join(folder.TargetPhotoPath, file.Name()), name})
+		}
+	}
+	return result, nil
+}
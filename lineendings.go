@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+)
+
+// formatForNote converts the line endings of the appended content to the
+// configured ones. With "auto" the content follows the note, so notes
+// edited on Windows don't end up with mixed line endings.
+func formatForNote(diaryFilePath string, content string, settings *appSettings) string {
+	if settings.StripTrailingNewline {
+		content = strings.TrimRight(content, "\n")
+	}
+
+	crlf := false
+	switch settings.LineEndings {
+	case "crlf":
+		crlf = true
+	case "auto":
+		if data, err := os.ReadFile(diaryFilePath); err == nil {
+			crlf = bytes.Contains(data, []byte("\r\n"))
+		}
+	}
+
+	if crlf {
+		return strings.ReplaceAll(content, "\n", "\r\n")
+	}
+	return content
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// isSkippedEntry tells whether the import ignores the directory entry,
+// which it does for directories, photos marked as processed and, unless
+// symlinks are followed, for symlinks.
+func isSkippedEntry(file fs.DirEntry, settings *appSettings) bool {
+	if file.IsDir() || isMarkedProcessed(file, settings) {
+		return true
+	}
+	return file.Type()&fs.ModeSymlink != 0 && settings.Symlinks == "skip"
+}
+
+// hardlinkPhoto links the photo, or the file a symlinked photo points to,
+// to the target. It returns false when the files are on different file
+// systems or the link fails otherwise, so the photo can be copied instead.
+func hardlinkPhoto(photo string, target string) bool {
+	resolved, err := filepath.EvalSymlinks(photo)
+	if err != nil {
+		return false
+	}
+	return os.Link(resolved, target) == nil
+}
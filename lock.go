@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// errInstanceRunning tells that another instance holds the lock.
+var errInstanceRunning = errors.New("another instance is running")
+
+// instanceLock keeps two instances, e.g. a service and a manual run, from
+// importing the same photos or writing the same note at the same time. The
+// operating system releases the lock when the process exits.
+type instanceLock struct {
+	file *os.File
+}
+
+// heldLock is the lock of a command that runs with the lock until it exits.
+var heldLock *instanceLock
+
+// instanceLockPath returns the lock file, by default one per vault in the
+// user cache folder. The vault is often synced or on a network share, where
+// the lock would travel to other machines and flock isn't reliable.
+func instanceLockPath(settings *appSettings) string {
+	if settings.LockFile != "" {
+		return settings.LockFile
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	vault, err := filepath.Abs(settings.ObsidianFilePath)
+	if err != nil {
+		vault = settings.ObsidianFilePath
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(vault)))
+	return path.Join(dir, "diary-automation", fmt.Sprintf("%x.lock", sum[:8]))
+}
+
+// lockInstance takes the lock or returns errInstanceRunning when another
+// instance holds it.
+func lockInstance(settings *appSettings) (*instanceLock, error) {
+	lockPath := instanceLockPath(settings)
+	if err := os.MkdirAll(path.Dir(lockPath), 0755); err != nil {
+		return nil, err
+	}
+	file, err := openLockFile(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	}
+	return &instanceLock{file}, nil
+}
+
+func (lock *instanceLock) release() {
+	lock.file.Close()
+}
+
+// holdInstanceLock takes the lock for the rest of the run and exits with
+// code 3 when another instance holds it.
+func holdInstanceLock(settings *appSettings) {
+	lock, err := lockInstance(settings)
+	if errors.Is(err, errInstanceRunning) {
+		logWarnf("%s, exiting", err)
+		os.Exit(3)
+	}
+	if err != nil {
+		log.Fatalf("unable to lock %s: %s", instanceLockPath(settings), err)
+	}
+	heldLock = lock
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "os"
+
+// openLockFile doesn't lock on platforms without file locks.
+func openLockFile(lockPath string) (*os.File, error) {
+	return os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+func openLockFile(lockPath string) (*os.File, error) {
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		defer file.Close()
+		if err == syscall.EWOULDBLOCK {
+			pid := make([]byte, 32)
+			n, _ := file.Read(pid)
+			return nil, fmt.Errorf("%w (pid %s)", errInstanceRunning, strings.TrimSpace(string(pid[:n])))
+		}
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// openLockFile opens the lock file without sharing it, so opening it again
+// fails while the lock is held.
+func openLockFile(lockPath string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errorSharingViolation {
+		return nil, errInstanceRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(handle), lockPath), nil
+}
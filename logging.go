@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+// logFields are logged with a message, like the source, target and note
+// paths of a photo. The text format leaves them out since the messages
+// name the files already.
+type logFields map[string]string
+
+var logOutput = struct {
+	sync.Mutex
+	level logLevel
+	json  bool
+}{level: levelInfo}
+
+// configureLogging sets the lowest level that is logged, debug, info, warn
+// or error, and the format, text or json. JSON lines carry the fields so
+// log shippers like journald and Loki can filter by them.
+func configureLogging(level string, format string) error {
+	value := levelInfo
+	if level != "" {
+		found := false
+		for candidate, name := range logLevelNames {
+			if name == level {
+				value = candidate
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown log level %s", level)
+		}
+	}
+	var useJSON bool
+	switch format {
+	case "", "text":
+		useJSON = false
+	case "json":
+		useJSON = true
+	default:
+		return fmt.Errorf("unknown log format %s", format)
+	}
+
+	logOutput.Lock()
+	defer logOutput.Unlock()
+	logOutput.level = value
+	logOutput.json = useJSON
+	return nil
+}
+
+// logMessage logs the message and publishes errors on the event bus.
+func logMessage(level logLevel, fields logFields, format string, args ...interface{}) {
+	message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+	writeLogMessage(level, fields, message)
+	if level == levelError {
+		publish(importEvent{Type: eventError, Date: fields["date"], Source: fields["source"], Target: fields["target"], Note: fields["note"], Message: message})
+	}
+}
+
+func writeLogMessage(level logLevel, fields logFields, message string) {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+	if level < logOutput.level {
+		return
+	}
+
+	if !logOutput.json {
+		log.Printf("%s %s\n", strings.ToUpper(logLevelNames[level]), message)
+		return
+	}
+
+	entry := make(map[string]string, len(fields)+3)
+	for key, value := range fields {
+		entry[key] = value
+	}
+	entry["time"] = time.Now().Format(time.RFC3339)
+	entry["level"] = logLevelNames[level]
+	entry["msg"] = message
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("unable to encode a log entry: %s\n", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, string(data))
+}
+
+func logDebugf(format string, args ...interface{}) {
+	logMessage(levelDebug, nil, format, args...)
+}
+
+func logInfof(format string, args ...interface{}) {
+	logMessage(levelInfo, nil, format, args...)
+}
+
+func logWarnf(format string, args ...interface{}) {
+	logMessage(levelWarn, nil, format, args...)
+}
+
+func logErrorf(format string, args ...interface{}) {
+	logMessage(levelError, nil, format, args...)
+}
+
+func (fields logFields) debugf(format string, args ...interface{}) {
+	logMessage(levelDebug, fields, format, args...)
+}
+
+func (fields logFields) infof(format string, args ...interface{}) {
+	logMessage(levelInfo, fields, format, args...)
+}
+
+func (fields logFields) warnf(format string, args ...interface{}) {
+	logMessage(levelWarn, fields, format, args...)
+}
+
+func (fields logFields) errorf(format string, args ...interface{}) {
+	logMessage(levelError, fields, format, args...)
+}
@@ -1,57 +1,270 @@
 package main
 
 import (
+	"crypto/sha256"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 type appSettings struct {
+	Profile  string               `yaml:"profile"`
+	Profiles map[string]yaml.Node `yaml:"profiles"`
+
 	OriginalPhotoPath string `yaml:"original_photo_path"`
 	TargetPhotoPath   string `yaml:"target_photo_path"`
 	ObsidianFilePath  string `yaml:"obsidian_file_path"`
 	ImagePrefix       string `yaml:"image_prefix"`
+
+	NoteNameFormat   string `yaml:"note_name_format"`
+	NoteFolderFormat string `yaml:"note_folder_format"`
+
+	DateLayouts  []string             `yaml:"date_layouts"`
+	ExifDates    bool                 `yaml:"exif_dates"`
+	ExifTool     *exiftoolSettings    `yaml:"exiftool"`
+	PartialDates *partialDateSettings `yaml:"partial_dates"`
+	DateGuard    *dateGuardSettings   `yaml:"date_guard"`
+	RetroEdits   *retroEditSettings   `yaml:"retro_edits"`
+
+	MaxEmbedsPerNote int  `yaml:"max_embeds_per_note"`
+	CaptionsBelow    bool `yaml:"captions_below"`
+	ChecksumManifest bool `yaml:"checksum_manifest"`
+	VerifyWrites     bool `yaml:"verify_writes"`
+
+	SortPhotoBlocks bool `yaml:"sort_photo_blocks"`
+	WriteSidecars   bool `yaml:"write_sidecars"`
+	PhotoStats      bool `yaml:"photo_stats"`
+	LinkReport      bool `yaml:"link_report"`
+
+	LogLevel  string          `yaml:"log_level"`
+	LogFormat string          `yaml:"log_format"`
+	Timing    *timingSettings `yaml:"timing"`
+	DumpPath  string          `yaml:"dump_path"`
+
+	RetryAttempts  int    `yaml:"retry_attempts"`
+	QuarantinePath string `yaml:"quarantine_path"`
+
+	NoteWriteIntervalSeconds int                 `yaml:"note_write_interval_seconds"`
+	LockFile                 string              `yaml:"lock_file"`
+	StatePath                string              `yaml:"state_path"`
+	VaultIndex               *vaultIndexSettings `yaml:"vault_index"`
+
+	Symlinks   string             `yaml:"symlinks"`
+	Hardlink   bool               `yaml:"hardlink"`
+	CloudFiles *cloudFileSettings `yaml:"cloud_files"`
+
+	ProcessedMarkers *processedMarkerSettings `yaml:"processed_markers"`
+
+	HEIC         *heicSettings         `yaml:"heic"`
+	ImageTool    *imageToolSettings    `yaml:"image_tool"`
+	Videos       *videoSettings        `yaml:"videos"`
+	Montage      *montageSettings      `yaml:"montage"`
+	ContactSheet *contactSheetSettings `yaml:"contact_sheet"`
+
+	LineEndings          string `yaml:"line_endings"`
+	StripTrailingNewline bool   `yaml:"strip_trailing_newline"`
+
+	LargeFilePath        string `yaml:"large_file_path"`
+	LargeFileThresholdMB int64  `yaml:"large_file_threshold_mb"`
+
+	Quota   *quotaSettings   `yaml:"quota"`
+	Offload *offloadSettings `yaml:"offload"`
+
+	UnsortedPhotoPath string `yaml:"unsorted_photo_path"`
+	UnsortedAfterDays int    `yaml:"unsorted_after_days"`
+
+	MinRating        int              `yaml:"min_rating"`
+	Keywords         *keywordSettings `yaml:"keywords"`
+	ArchivePhotoPath string           `yaml:"archive_photo_path"`
+
+	LatestPhotoPath   string `yaml:"latest_photo_path"`
+	LatestUseSymlinks bool   `yaml:"latest_use_symlinks"`
+
+	SectionTemplate       string              `yaml:"section_template"`
+	Provenance            *provenanceSettings `yaml:"provenance"`
+	Scripts               []scriptRule        `yaml:"scripts"`
+	DailyNoteTemplatePath string              `yaml:"daily_note_template_path"`
+
+	Habits           []string                 `yaml:"habits"`
+	DateRulesFile    string                   `yaml:"date_rules_file"`
+	PlaceholderNotes *placeholderNoteSettings `yaml:"placeholder_notes"`
+
+	Languages         []languageSettings `yaml:"languages"`
+	LanguageSeparator string             `yaml:"language_separator"`
+
+	Sources        []sourceFolderSettings `yaml:"sources"`
+	SourceReadOnly bool                   `yaml:"source_read_only"`
+	SourceName     string                 `yaml:"-"`
+	SourcePattern  string                 `yaml:"-"`
+	SourceSection  string                 `yaml:"-"`
+
+	CalDAV   *calDAVSettings   `yaml:"caldav"`
+	Signal   *signalSettings   `yaml:"signal"`
+	Matrix   *matrixSettings   `yaml:"matrix"`
+	Telegram *telegramSettings `yaml:"telegram"`
+	IMAP     *imapSettings     `yaml:"imap"`
+	Plugins  []pluginSettings  `yaml:"plugins"`
+
+	Backup     *backupSettings     `yaml:"backup"`
+	Cast       *castSettings       `yaml:"cast"`
+	Encryption *encryptionSettings `yaml:"encryption"`
+
+	Removable *removableSettings `yaml:"removable"`
+	Camera    *cameraSettings    `yaml:"camera"`
+	XMP       *xmpSettings       `yaml:"xmp"`
+
+	ObsidianURI   *obsidianURISettings  `yaml:"obsidian_uri"`
+	Watch         *watchSettings        `yaml:"watch"`
+	Subscribers   []subscriberSettings  `yaml:"subscribers"`
+	StatusFile    *statusFileSettings   `yaml:"status_file"`
+	Notifications *notificationSettings `yaml:"notifications"`
+	Travel        *travelSettings       `yaml:"travel"`
+	Resources     *resourceSettings     `yaml:"resources"`
+	Storage       *storageSettings      `yaml:"storage"`
+
+	settingsFile string
 }
 
-func readSettings(filePath string) (*appSettings, error) {
+const (
+	sectionHeading = "### Iltakirjoitus"
+	appendAttempts = 5
+)
+
+var (
+	photoFilePattern      = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(-\d{2})?\.(jpg|png)$`)
+	monthPhotoFilePattern = regexp.MustCompile(`^(\d{4}-\d{2})(_\d{2})?\.(jpg|png)$`)
+)
+
+// readSettings reads the settings file with the named profile applied, or
+// the profile the file selects when the name is empty.
+func readSettings(filePath string, profile string) (*appSettings, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read settings.yaml: %v", err)
+		return nil, fmt.Errorf("failed to read %s: %v", filePath, err)
 	}
 
 	var appSettings appSettings
 	if err := yaml.Unmarshal(data, &appSettings); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal settings.yaml: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", filePath, err)
+	}
+	if profile == "" {
+		profile = os.Getenv(envPrefix + "PROFILE")
+	}
+	if err := applyProfile(&appSettings, profile); err != nil {
+		return nil, err
+	}
+	if err := applyEnvironment(&appSettings); err != nil {
+		return nil, err
+	}
+	appSettings.settingsFile = filePath
+	appSettings.ImagePrefix = normalizeName(appSettings.ImagePrefix)
+	for i, source := range appSettings.Sources {
+		appSettings.Sources[i].ImagePrefix = normalizeName(source.ImagePrefix)
+		if _, err := regexp.Compile(source.Pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern of source %s: %v", source.Name, err)
+		}
+	}
+	for _, plugin := range appSettings.Plugins {
+		if plugin.WASM != "" && plugin.Kind == "source" {
+			return nil, fmt.Errorf("plugin %s: source plugins can't be WASM modules", plugin.Name)
+		}
+	}
+	if tool := appSettings.ImageTool; tool != nil {
+		if err := validateImageCommand("image_tool.resize", tool.Resize, "{input}", "{output}", "{size}"); err != nil {
+			return nil, err
+		}
+		if err := validateImageCommand("image_tool.convert", tool.Convert, "{input}", "{output}"); err != nil {
+			return nil, err
+		}
+		if len(tool.Convert) > 0 && len(tool.Extensions) == 0 {
+			return nil, fmt.Errorf("image_tool.extensions is not set")
+		}
+	}
+	if appSettings.ProcessedMarkers != nil {
+		if err := validateProcessedMarkers(appSettings.ProcessedMarkers); err != nil {
+			return nil, err
+		}
+	}
+	for _, folder := range sourceFolders(&appSettings) {
+		if folder.SourceReadOnly && appSettings.StatePath == "" {
+			return nil, fmt.Errorf("the read-only source %s needs state_path to skip imported photos", folder.OriginalPhotoPath)
+		}
+	}
+	if appSettings.Storage != nil {
+		if err := validateStorage(appSettings.Storage); err != nil {
+			return nil, fmt.Errorf("invalid storage: %v", err)
+		}
+	}
+	if appSettings.VaultIndex != nil && appSettings.StatePath == "" {
+		return nil, fmt.Errorf("vault_index needs state_path to keep the index")
+	}
+	if watch := appSettings.Watch; watch != nil && watch.UploadInbox != "" && !isSourceFolder(watch.UploadInbox, &appSettings) {
+		return nil, fmt.Errorf("watch.upload_inbox %s is not a source folder", watch.UploadInbox)
+	}
+	if _, err := settingsSubscriptions(&appSettings); err != nil {
+		return nil, fmt.Errorf("invalid subscribers: %v", err)
+	}
+	if appSettings.Notifications != nil {
+		if err := validateNotifications(appSettings.Notifications); err != nil {
+			return nil, fmt.Errorf("invalid notifications: %v", err)
+		}
+	}
+	if appSettings.Travel != nil && appSettings.Travel.CollectPath == "" {
+		return nil, fmt.Errorf("travel.collect_path is not set")
+	}
+	if _, err := compileScripts(appSettings.Scripts); err != nil {
+		return nil, fmt.Errorf("invalid scripts: %v", err)
+	}
+	if appSettings.SectionTemplate != "" {
+		if _, err := parseSectionTemplate(appSettings.SectionTemplate); err != nil {
+			return nil, fmt.Errorf("invalid section_template: %v", err)
+		}
+	}
+	if appSettings.Provenance != nil {
+		if err := validateProvenance(appSettings.Provenance); err != nil {
+			return nil, fmt.Errorf("invalid provenance: %v", err)
+		}
 	}
 
 	return &appSettings, nil
 }
 
-func checkPhotos(photoPath string) map[string][]string {
+func checkPhotos(photoPath string, settings *appSettings) (map[string][]string, error) {
 	result := make(map[string][]string)
 
-	files, err := os.ReadDir(photoPath)
-	if err != nil {
-		log.Fatalf("unable to read path %s, %s", photoPath, err)
-	}
-
-	r, err := regexp.Compile(`^\d{4}-\d{2}-\d{2}(-\d{2})?.(jpg|png)$`)
+	var files []fs.DirEntry
+	err := withRetry(settings, func() error {
+		var err error
+		files, err = os.ReadDir(photoPath)
+		return err
+	})
 	if err != nil {
-		log.Fatalf("unable to compile regular expression: %s", err)
+		return nil, fmt.Errorf("unable to read path %s: %v", photoPath, err)
 	}
 
 	for _, file := range files {
-		if !file.IsDir() {
-			matched := r.MatchString(file.Name())
+		if !isSkippedEntry(file, settings) {
+			matched := isDiaryPhoto(file.Name(), settings)
 
 			if matched {
-				date := getDateFromFile(file.Name())
+				date, ok := getDateFromFile(file.Name())
+				if !ok {
+					continue
+				}
+				if settings.Videos != nil && skipLargeVideo(path.Join(photoPath, file.Name()), settings) {
+					continue
+				}
 				if _, ok := result[date]; !ok {
 					result[date] = make([]string, 0)
 				}
@@ -62,12 +275,23 @@ func checkPhotos(photoPath string) map[string][]string {
 		}
 	}
 
-	return result
+	return result, nil
 }
 
-func getDateFromFile(filePath string) string {
+// getDateFromFile returns the date captured from the name of a diary
+// photo, or false when the name is not one.
+func getDateFromFile(filePath string) (string, bool) {
 	filename := path.Base(filePath)
-	return filename[0:10]
+	if match := photoFilePattern.FindStringSubmatch(filename); match != nil {
+		return match[1], true
+	}
+	if match := monthPhotoFilePattern.FindStringSubmatch(filename); match != nil {
+		return match[1], true
+	}
+	if match := videoFilePattern.FindStringSubmatch(filename); match != nil {
+		return match[1], true
+	}
+	return "", false
 }
 
 func fileExists(filePath string) bool {
@@ -78,86 +302,911 @@ func fileExists(filePath string) bool {
 	return !info.IsDir()
 }
 
-func updateDiaryDocument(date string, photoPaths []string, settings *appSettings) {
-	diaryFile := fmt.Sprintf("%s.md", date)
-	diaryFilePath := path.Join(settings.ObsidianFilePath, diaryFile)
-	content := ""
-	photoLinks := ""
+func dirExists(dirPath string) bool {
+	info, err := os.Stat(dirPath)
+	if err != nil {
+		return false
+	}
+	return info.IsDir()
+}
 
-	for _, photoPath := range photoPaths {
-		filename := path.Base(photoPath)
-		photoLinks = photoLinks + fmt.Sprintf("![[%s]]\n", settings.ImagePrefix+filename)
+// notePhoto is a photo added to a note with the settings of the source
+// folder it came from.
+type notePhoto struct {
+	path     string
+	caption  string
+	original string
+	section  string
+	text     string
+	settings *appSettings
+}
+
+func updateDiaryDocument(date string, photos []notePhoto, settings *appSettings) error {
+	diaryFilePath := notePath(date, settings)
+	if settings.PlaceholderNotes != nil {
+		removePlaceholderMarker(diaryFilePath, settings)
 	}
 
-	if fileExists(diaryFilePath) {
-		content = fmt.Sprintf("\n\n### Iltakirjoitus\n%s", photoLinks)
-	} else {
-		content = fmt.Sprintf("# %s\n\n### Iltakirjoitus\n%s", date, photoLinks)
+	exists := fileExists(diaryFilePath)
+	if exists {
+		photos = unlinkedPhotos(diaryFilePath, photos)
+		if len(photos) == 0 {
+			logFields{"note": diaryFilePath, "date": date}.infof("the photos of %s are already in the note", date)
+			return nil
+		}
+	} else if err := createNoteFolder(diaryFilePath, settings); err != nil {
+		return err
+	}
+
+	embedded := 0
+	if settings.MaxEmbedsPerNote > 0 && exists {
+		embedded = countEmbeds(diaryFilePath, settings)
+	}
+
+	if exists && settings.SectionTemplate == "" {
+		// Photos arriving for a note that already has the photo section, or
+		// the section the scripts route them to, are added to the end of
+		// that section instead of under a new heading.
+		for i, group := range photoGroups(photos, settings) {
+			links := photoLinkList(group.photos, embedded, settings)
+			embedded += countLinkEmbeds(links)
+			if i == 0 && hasPlugin(settings, "enricher") {
+				links = links + enricherSection(date, photos, settings)
+			}
+			links = links + provenanceLine(len(group.photos), settings)
+			if err := addToSection(diaryFilePath, group.heading, links, settings); err != nil {
+				return err
+			}
+		}
+		publish(importEvent{Type: eventNoteUpdated, Date: date, Note: diaryFilePath})
+		return nil
+	}
+
+	content := noteContent(date, photos, exists, embedded, settings)
+	content = formatForNote(diaryFilePath, content, settings)
+
+	var sizeBefore int64
+	if info, err := os.Stat(diaryFilePath); err == nil {
+		sizeBefore = info.Size()
 	}
 
-	f, err := os.OpenFile(diaryFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	err := rewriteNote(diaryFilePath, settings, func(original []byte) []byte {
+		return append([]byte(stampLastImport(string(original), settings)), content...)
+	})
 	if err != nil {
-		log.Fatalf("unable to open file %s: %s", diaryFile, err)
+		return err
 	}
-	defer f.Close()
-	if _, err := f.WriteString(content); err != nil {
-		log.Fatalf("unable to append text to file: %s", err)
+	if exists {
+		publish(importEvent{Type: eventNoteUpdated, Date: date, Note: diaryFilePath})
+	} else {
+		publish(importEvent{Type: eventNoteCreated, Date: date, Note: diaryFilePath})
 	}
+
+	if settings.VerifyWrites {
+		verifyNoteWrite(diaryFilePath, content, sizeBefore, settings)
+	}
+	return nil
 }
 
-func moveImages(photos []string, settings *appSettings) {
-	for _, photo := range photos {
-		filename := path.Base(photo)
-		target := path.Join(settings.TargetPhotoPath, settings.ImagePrefix+filename)
-		log.Printf("moving %s to %s\n", photo, target)
+// noteContent returns the text added to the note of the date, either a
+// new photo section for an existing note or a whole new note.
+func noteContent(date string, photos []notePhoto, exists bool, embedded int, settings *appSettings) string {
+	section := photoSection(date, photos, exists, embedded, settings)
+	if exists {
+		return "\n\n" + section
+	}
+	return stampLastImport(newNote(date, dateRuleSection(date, settings)+eventSection(date, settings)+section+habitSection(settings), settings), settings)
+}
 
-		inputFile, err := os.Open(photo)
-		if err != nil {
-			log.Fatalf("unable to read the input file %s: %s", photo, err)
+// appendToNote appends the content to the note.
+func appendToNote(diaryFilePath string, content string, settings *appSettings) error {
+	return rewriteNote(diaryFilePath, settings, func(original []byte) []byte {
+		return append(original, content...)
+	})
+}
+
+// rewriteNote replaces the note with the edited content through a
+// temporary file. If the note changes between reading it and replacing it,
+// e.g. because Obsidian saved an edit, the edit is retried with the fresh
+// content so the change made in Obsidian isn't lost. Writes that fail with
+// a transient error are retried with a backoff, a note that keeps changing
+// is not.
+func rewriteNote(diaryFilePath string, settings *appSettings, edit func(original []byte) []byte) error {
+	paceNoteWrite(settings)
+	err := withRetry(settings, func() error {
+		return replaceNote(diaryFilePath, edit)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %v", path.Base(diaryFilePath), err)
+	}
+	return nil
+}
+
+func replaceNote(diaryFilePath string, edit func(original []byte) []byte) error {
+	for attempt := 1; attempt <= appendAttempts; attempt++ {
+		original, err := os.ReadFile(diaryFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
 		}
+		originalHash := sha256.Sum256(original)
 
-		outputFile, err := os.Create(target)
+		temp, err := os.CreateTemp(path.Dir(diaryFilePath), "."+path.Base(diaryFilePath)+".*.tmp")
 		if err != nil {
-			log.Fatalf("unable to create the destination file %s: %s", target, err)
+			return err
+		}
+		_, err = temp.Write(edit(original))
+		if closeErr := temp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Chmod(temp.Name(), noteFileMode(diaryFilePath))
 		}
-		defer outputFile.Close()
-
-		_, err = io.Copy(outputFile, inputFile)
-		inputFile.Close()
 		if err != nil {
-			log.Fatalf("unable to copy image %s to %s: %s", photo, target, err)
+			os.Remove(temp.Name())
+			return err
 		}
 
-		err = os.Remove(photo)
+		current, err := os.ReadFile(diaryFilePath)
+		if err != nil && !os.IsNotExist(err) {
+			os.Remove(temp.Name())
+			return err
+		}
+		if sha256.Sum256(current) != originalHash {
+			os.Remove(temp.Name())
+			logFields{"note": diaryFilePath}.warnf("%s changed while writing, retrying", path.Base(diaryFilePath))
+			continue
+		}
+
+		if err := os.Rename(temp.Name(), diaryFilePath); err != nil {
+			os.Remove(temp.Name())
+			return err
+		}
+		return nil
+	}
+	return fmt.Errorf("it kept changing")
+}
+
+func noteFileMode(diaryFilePath string) os.FileMode {
+	if info, err := os.Stat(diaryFilePath); err == nil {
+		return info.Mode().Perm()
+	}
+	return 0644
+}
+
+// photoLinkList embeds the photos until the note has the maximum number of
+// embeds. The rest are listed as plain links in a collapsed callout so that
+// busy days stay readable.
+func photoLinkList(photos []notePhoto, embedded int, settings *appSettings) string {
+	photoLinks := ""
+	overflow := ""
+
+	for _, photo := range photos {
+		link, embed := photoLink(photo)
+		caption := ""
+		if photo.settings.CaptionsBelow && photo.caption != "" {
+			caption = captionLine(photo.caption)
+		}
+		if !embed {
+			photoLinks = photoLinks + link + "\n" + caption
+			continue
+		}
+		if settings.MaxEmbedsPerNote > 0 && embedded >= settings.MaxEmbedsPerNote {
+			entry := strings.TrimPrefix(link, "!")
+			if caption != "" {
+				entry = entry + " " + strings.TrimSuffix(caption, "\n")
+			}
+			overflow = overflow + fmt.Sprintf("> - %s\n", entry)
+			continue
+		}
+		photoLinks = photoLinks + link + "\n" + caption
+		embedded++
+	}
+
+	if overflow != "" {
+		photoLinks = photoLinks + fmt.Sprintf("\n> [!note]- %s\n%s", labelsForNote(settings).morePhotos, overflow)
+	}
+	return photoLinks
+}
+
+// photoLink returns the link to the attachment of the photo and tells
+// whether it is an embed. Large files and large videos are only linked.
+func photoLink(photo notePhoto) (string, bool) {
+	name := targetName(path.Base(photo.path), photo.settings)
+	if isLargeFile(photo.path, photo.settings) {
+		return fmt.Sprintf("[%s](%s)", name, fileURL(targetPath(photo.path, photo.settings))), false
+	}
+	link := name
+	if photo.caption != "" && !photo.settings.CaptionsBelow {
+		link = name + "|" + photo.caption
+	}
+	if linkOnlyVideo(photo.path, photo.settings) {
+		return fmt.Sprintf("[[%s]]", link), false
+	}
+	return fmt.Sprintf("![[%s]]", link), true
+}
+
+// captionLine returns the caption written in italics under the link of
+// the photo with captions_below.
+func captionLine(caption string) string {
+	return "*" + strings.ReplaceAll(caption, "*", "\\*") + "*\n"
+}
+
+// countEmbeds returns the number of attachments of any source folder
+// already embedded in a note.
+func countEmbeds(diaryFilePath string, settings *appSettings) int {
+	data, err := os.ReadFile(diaryFilePath)
+	if err != nil {
+		return 0
+	}
+	prefixes := make([]string, 0)
+	for _, folder := range sourceFolders(settings) {
+		prefixes = append(prefixes, folder.ImagePrefix)
+	}
+	count := 0
+	for _, embed := range strings.Split(string(data), "![[")[1:] {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(embed, prefix) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+func eventSection(date string, settings *appSettings) string {
+	if settings.CalDAV == nil || isMonthKey(date) {
+		return ""
+	}
+
+	events, err := fetchDayEvents(date, settings.CalDAV)
+	if err != nil {
+		logErrorf("unable to fetch calendar events for %s: %s", date, err)
+		return ""
+	}
+	if len(events) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s\n%s\n", labelsForNote(settings).events, formatEvents(events, settings.CalDAV.TitlesOnly))
+}
+
+func habitSection(settings *appSettings) string {
+	if len(settings.Habits) == 0 {
+		return ""
+	}
+
+	tasks := "\n"
+	for _, habit := range settings.Habits {
+		tasks = tasks + fmt.Sprintf("- [ ] %s\n", habit)
+	}
+	return tasks
+}
+
+// targetName returns the name of the attachment a photo is moved to.
+func targetName(filename string, settings *appSettings) string {
+	if settings.Encryption != nil {
+		return settings.ImagePrefix + filename + encryptedExtension
+	}
+	return settings.ImagePrefix + filename
+}
+
+// targetPath returns the path a photo is moved to. Large files go outside
+// the vault so they don't count against sync quotas.
+func targetPath(photo string, settings *appSettings) string {
+	name := targetName(path.Base(photo), settings)
+	if isLargeFile(photo, settings) {
+		return path.Join(settings.LargeFilePath, name)
+	}
+	return path.Join(settings.TargetPhotoPath, name)
+}
+
+func isLargeFile(filePath string, settings *appSettings) bool {
+	if settings.LargeFilePath == "" || settings.LargeFileThresholdMB <= 0 {
+		return false
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return false
+	}
+	return info.Size() > settings.LargeFileThresholdMB*1024*1024
+}
+
+func fileURL(filePath string) string {
+	u := url.URL{Scheme: "file", Path: filePath}
+	return "<" + u.String() + ">"
+}
+
+// moveImages moves the photos to their targets and returns the photos that
+// were moved. A photo that can't be moved is retried, and quarantined when
+// it can't be read either.
+func moveImages(photos []string, settings *appSettings) []string {
+	moved := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		target := targetPath(photo, settings)
+		fields := logFields{"source": photo, "target": target}
+		fields.infof("moving %s to %s", photo, target)
+
+		start := time.Now()
+		err := withRetry(settings, func() error {
+			return moveImage(photo, target, settings)
+		})
+		timeFileStage(photo, "move", start)
 		if err != nil {
-			log.Fatalf("unable to delete the input file %s: %s", photo, err)
+			if checkReadable(photo) != nil {
+				quarantineFailedFile(photo, err, settings)
+			} else {
+				fields.errorf("unable to move %s, leaving it for the next import: %s", photo, err)
+			}
+			continue
 		}
+		moved = append(moved, photo)
+		publish(importEvent{Type: eventPhotoImported, Source: photo, Target: target})
 	}
+	return moved
+}
+
+func moveImage(photo string, target string, settings *appSettings) error {
+	if settings.Encryption != nil {
+		if err := encryptFile(photo, target, settings.Encryption); err != nil {
+			return err
+		}
+		return disposeSource(photo, target, settings)
+	}
+
+	if settings.Hardlink && hardlinkPhoto(photo, target) {
+		return disposeSource(photo, target, settings)
+	}
+
+	inputFile, err := os.Open(photo)
+	if err != nil {
+		return err
+	}
+	defer inputFile.Close()
+	inputInfo, err := inputFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(outputFile, inputFile)
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+	inputFile.Close()
+
+	// Keep the original modification time, which is the closest thing to
+	// the capture time the photo has.
+	if err := os.Chtimes(target, time.Now(), inputInfo.ModTime()); err != nil {
+		logFields{"target": target}.errorf("unable to set the modification time of %s: %s", target, err)
+	}
+	return disposeSource(photo, target, settings)
+}
+
+// unlockedCommands only read the vault or, like watch, take the instance
+// lock for each import, so they run alongside another instance.
+var unlockedCommands = map[string]bool{
+	"preview":       true,
+	"upload":        true,
+	"audit":         true,
+	"preview-names": true,
+	"simulate":      true,
+	"template":      true,
+	"watch":         true,
+	"state":         true,
 }
 
 func main() {
 	var settingsFile string
+	var outputFormat string
+	var once bool
+	var dryRun bool
+	var adoptExisting bool
+	var profile string
+	var logLevel string
 
-	flag.StringVar(&settingsFile, "s", "", "Settings file")
+	flag.StringVar(&settingsFile, "config", "", "Settings file, instead of DIARY_CONFIG or settings.yaml")
+	flag.StringVar(&settingsFile, "s", "", "Settings file, same as --config")
+	flag.StringVar(&profile, "profile", "", "Settings profile, instead of the one the settings file selects")
+	flag.StringVar(&outputFormat, "output", "text", "Output format of the import results, text or json")
+	flag.BoolVar(&once, "once", false, "Run a single import and exit, also instead of watch")
+	flag.StringVar(&logLevel, "log-level", "", "Lowest logged level, debug, info, warn or error, instead of log_level")
+	flag.BoolVar(&dryRun, "dry-run", false, "Report the notes and files the import would change without changing them")
+	flag.BoolVar(&adoptExisting, "adopt-existing", false, "Record the attachments already in the vault in an empty state database before importing")
 	flag.Parse()
 
-	if settingsFile == "" {
-		log.Fatal("Missing --settingsFile argument")
+	if flag.Arg(0) == "decrypt" {
+		runDecrypt(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "init" {
+		runInit(flag.Args()[1:])
+		return
 	}
 
+	settingsFile = settingsFilePath(settingsFile)
+	if flag.Arg(0) == "check-config" {
+		runCheckConfig(settingsFile, profile)
+		return
+	}
+	if flag.Arg(0) == "selftest" {
+		runSelftest(settingsFile, profile, logLevel, flag.Args()[1:])
+		return
+	}
 	if !fileExists(settingsFile) {
 		log.Fatalf("Missing settings file %s", settingsFile)
 	}
 
-	settings, err := readSettings(settingsFile)
+	settings, err := readSettings(settingsFile, profile)
 	if err != nil {
 		log.Fatalf("unable to read setting: %s", err)
 	}
-	log.Printf("checking photos from %s\n", settings.OriginalPhotoPath)
-	photos := checkPhotos(settings.OriginalPhotoPath)
-	for date, photos := range photos {
-		log.Printf("updating diary for %s with %d photos\n", date, len(photos))
-		updateDiaryDocument(date, photos, settings)
-		moveImages(photos, settings)
+	logLevelFlag = logLevel
+	if logLevel == "" {
+		logLevel = settings.LogLevel
+	}
+	if err := configureLogging(logLevel, settings.LogFormat); err != nil {
+		log.Fatalf("unable to configure logging: %s", err)
+	}
+
+	command := flag.Arg(0)
+	if once && command == "watch" {
+		command = "run"
+	}
+	if dryRun {
+		if command != "" && command != "run" {
+			log.Fatal("--dry-run only works with the import")
+		}
+		runDryRun(settings, outputFormat)
+		return
+	}
+	if !unlockedCommands[command] {
+		holdInstanceLock(settings)
+	}
+
+	switch command {
+	case "preview":
+		runPreview(settings, flag.Args()[1:])
+	case "upload":
+		runUpload(settings, flag.Args()[1:])
+	case "audit":
+		runAudit(settings)
+	case "preview-names":
+		runPreviewNames(settings, flag.Args()[1:])
+	case "simulate":
+		runSimulate(settings, flag.Args()[1:])
+	case "template":
+		runTemplate(settings, flag.Args()[1:])
+	case "approve":
+		runApprove(settings, flag.Args()[1:])
+	case "offload":
+		runOffload(settings, flag.Args()[1:])
+	case "restore":
+		runRestore(settings, flag.Args()[1:])
+	case "state":
+		runState(settings, flag.Args()[1:])
+	case "watch":
+		guardFirstRun(settings, adoptExisting)
+		handleShutdownSignals()
+		subscribeToEvents(settings)
+		useDumpSettings(settings)
+		handleDumpSignals()
+		runWatch(settings, outputFormat, flag.Args()[1:])
+	default:
+		guardFirstRun(settings, adoptExisting)
+		handleShutdownSignals()
+		subscribeToEvents(settings)
+		useDumpSettings(settings)
+		handleDumpSignals()
+		// Exit codes: 0 when the import succeeded, 1 when it stopped on an
+		// error, 2 when it finished but a note, a photo or an optional step
+		// failed and 3 when another instance was running.
+		failures := runImport(settings, outputFormat)
+		waitForSlideshow()
+		if failures > 0 {
+			os.Exit(2)
+		}
+	}
+}
+
+// runImport runs a single import of every source. It returns the number
+// of notes, photos and optional steps that failed, which are logged but
+// don't stop the run.
+func runImport(settings *appSettings, outputFormat string) int {
+	resetFileTimings()
+	defer reportFileTimings(settings)
+	resetVaultIndex()
+	var imported map[string][]string
+	started := time.Now()
+	beginImportStatus(settings)
+	defer func() {
+		finishImportStatus(settings, imported)
+		observeImport(started, imported)
+		notifyImport(settings, imported)
+	}()
+	failures := ingestSources(settings)
+	checkResources(settings)
+
+	folders := sourceFolders(settings)
+	if settings.Travel != nil {
+		if away, reason := travelling(settings); away {
+			collected, err := collectTravelPhotos(settings)
+			if err != nil {
+				logErrorf("unable to collect photos: %s", err)
+				failures++
+			}
+			logInfof("deferring the vault writes, %s: collected %d files", reason, collected)
+			if outputFormat == "json" {
+				printJSON([]plannedFile{})
+			}
+			return failures
+		}
+		if deferringHeavyWork() {
+			logInfof("leaving the travel photos for a later import")
+		} else {
+			folders = append(folders, collectedFolders(settings)...)
+		}
+	}
+
+	var planned []plannedFile
+	var failed int
+	imported, planned, failed = importFolders(folders, outputFormat == "json")
+	failures += failed
+	if shuttingDown() {
+		if outputFormat == "json" {
+			printJSON(planned)
+		}
+		return failures
+	}
+
+	if settings.PlaceholderNotes != nil {
+		createPlaceholderNotes(settings)
+	}
+
+	if len(imported) > 0 && settings.Backup != nil {
+		logInfof("backing up the vault with %s", settings.Backup.Tool)
+		if err := runBackup(settings); err != nil {
+			logErrorf("unable to back up the vault: %s", err)
+			failures++
+		}
+	}
+
+	pruned := make([]string, 0)
+	if len(imported) > 0 && settings.Quota != nil {
+		var err error
+		if pruned, err = enforceQuota(settings); err != nil {
+			logErrorf("unable to enforce the attachment quota: %s", err)
+			failures++
+		}
+	}
+
+	if len(imported) > 0 && settings.LinkReport {
+		dates := make([]string, 0, len(imported))
+		for date := range imported {
+			dates = append(dates, date)
+		}
+		if err := reportAttachmentLinks("the import", pruned, notesForDates(dates, settings), settings); err != nil {
+			logErrorf("unable to check the links: %s", err)
+			failures++
+		}
+	}
+
+	if len(imported) > 0 && settings.PhotoStats {
+		if err := updatePhotoStats(settings); err != nil {
+			logErrorf("unable to update photo stats: %s", err)
+			failures++
+		}
+	}
+
+	if settings.LatestPhotoPath != "" {
+		if err := updateLatestFolder(settings, imported); err != nil {
+			logErrorf("unable to update latest photos: %s", err)
+			failures++
+		}
+	}
+
+	if len(imported) > 0 && settings.ObsidianURI != nil {
+		announceImportedNote(imported, settings)
+	}
+
+	castImportedPhotos(settings)
+
+	if outputFormat == "json" {
+		printJSON(planned)
+	}
+	return failures
+}
+
+// folderScan holds the photos found in a source folder before they are
+// imported.
+type folderScan struct {
+	settings *appSettings
+	photos   map[string][]string
+	sidecars map[string]*xmpSidecar
+	captions map[string]string
+	sections map[string]string
+	hashes   map[string]string
+	renamed  map[string]string
+	planned  []plannedFile
+	failed   int
+}
+
+// importFolders scans every source folder first and then writes each note
+// once with the photos of the date from all folders. It returns the
+// imported photos by date, when asked the planned action of every file in
+// the folders, and the number of folders, notes and photos that failed.
+func importFolders(folders []*appSettings, plan bool) (map[string][]string, []plannedFile, int) {
+	scans := make([]*folderScan, 0, len(folders))
+	dates := make([]string, 0)
+	imported := make(map[string][]string)
+	for _, folder := range folders {
+		if shuttingDown() {
+			break
+		}
+		scan := scanFolder(folder, plan)
+		scans = append(scans, scan)
+		for date, photos := range scan.photos {
+			if _, ok := imported[date]; !ok {
+				dates = append(dates, date)
+			}
+			imported[date] = append(imported[date], photos...)
+		}
+	}
+	sort.Strings(dates)
+
+	// The first folder is the original photo path, whose settings apply to
+	// the notes.
+	settings := folders[0]
+	targets := make(map[*folderScan][]string)
+	failures := 0
+	for i, date := range dates {
+		// A shutdown stops the import between notes, so every note is
+		// written together with the photos it links.
+		if shuttingDown() {
+			logInfof("stopping, %d dates are left for the next import", len(dates)-i)
+			for _, left := range dates[i:] {
+				delete(imported, left)
+			}
+			break
+		}
+		photos := make([]notePhoto, 0)
+		for _, scan := range scans {
+			for _, photo := range scan.photos[date] {
+				photos = append(photos, notePhoto{photo, photoCaption(photo, scan), originalName(photo, scan.renamed), photoSectionName(photo, scan), messageText(photo), scan.settings})
+			}
+		}
+
+		logFields{"note": notePath(date, settings), "date": date}.infof("updating diary for %s with %d photos", date, len(photos))
+		if err := updateDiaryDocument(date, photos, settings); err != nil {
+			logFields{"note": notePath(date, settings), "date": date}.errorf("unable to update the note of %s, leaving its photos for the next import: %s", date, err)
+			delete(imported, date)
+			failures++
+			for _, scan := range scans {
+				for _, photo := range scan.photos[date] {
+					markPlannedAction(scan.planned, originalName(photo, scan.renamed), "failed")
+				}
+			}
+			continue
+		}
+		dateTargets := make([]string, 0)
+		for _, scan := range scans {
+			if len(scan.photos[date]) > 0 {
+				moved, movedTargets := moveScannedPhotos(scan, scan.photos[date])
+				failures += len(scan.photos[date]) - len(moved)
+				markFailedMoves(scan, scan.photos[date], moved)
+				targets[scan] = append(targets[scan], movedTargets...)
+				dateTargets = append(dateTargets, movedTargets...)
+				if settings.StatePath != "" {
+					recordProcessedPhotos(scan, date, moved, movedTargets, notePath(date, settings))
+				}
+			}
+		}
+		if settings.SortPhotoBlocks {
+			sortPhotoBlocks(notePath(date, settings), settings)
+		}
+		if settings.Montage != nil {
+			updateMontage(date, folders)
+		}
+		if settings.ContactSheet != nil {
+			updateContactSheet(date, folders)
+		}
+		if settings.Storage != nil && settings.Storage.Target != nil && len(dateTargets) > 0 {
+			storeAttachments(dateTargets, notePath(date, settings), settings)
+		}
+	}
+
+	planned := make([]plannedFile, 0)
+	for _, scan := range scans {
+		finishFolder(scan, targets[scan])
+		planned = append(planned, scan.planned...)
+		failures += scan.failed
+	}
+	return imported, planned, failures
+}
+
+// scanFolder renames, checks and filters the photos of a single source
+// folder without importing them.
+func scanFolder(settings *appSettings, plan bool) *folderScan {
+	scan := &folderScan{settings: settings, renamed: make(map[string]string)}
+	converted := make(map[string]string)
+	if settings.SourceReadOnly {
+		logDebugf("not converting or renaming the photos of the read-only %s", settings.OriginalPhotoPath)
+	}
+	if settings.HEIC != nil && !settings.SourceReadOnly && !deferringHeavyWork() {
+		converted = convertHEICPhotos(settings)
+	}
+	if settings.ImageTool != nil && len(settings.ImageTool.Convert) > 0 && !settings.SourceReadOnly && !deferringHeavyWork() {
+		for name, original := range convertImageToolPhotos(settings) {
+			converted[name] = original
+		}
+	}
+	if (len(settings.DateLayouts) > 0 || settings.PartialDates != nil || settings.ExifDates) && !settings.SourceReadOnly {
+		var err error
+		if scan.renamed, err = normalizePhotoNames(settings); err != nil {
+			logErrorf("unable to rename photos: %s", err)
+		}
+	}
+	for name, original := range scan.renamed {
+		if heic, ok := converted[original]; ok {
+			scan.renamed[name] = heic
+			delete(converted, original)
+		}
+	}
+	for name, heic := range converted {
+		scan.renamed[name] = heic
+	}
+
+	if plan {
+		var err error
+		if scan.planned, err = planSourceFolder(settings); err != nil {
+			logErrorf("unable to plan the import: %s", err)
+		}
+		// The photos were renamed already, so the plan lists them by the
+		// names they had in the source folder.
+		for i, file := range scan.planned {
+			if original, ok := scan.renamed[file.Name]; ok {
+				scan.planned[i].Name = original
+				scan.planned[i].Action = "rename"
+			}
+		}
+		sort.Slice(scan.planned, func(i, j int) bool {
+			return scan.planned[i].Name < scan.planned[j].Name
+		})
+	}
+
+	logDebugf("checking photos from %s", settings.OriginalPhotoPath)
+	photos, err := checkPhotos(settings.OriginalPhotoPath, settings)
+	if err != nil {
+		logErrorf("unable to check photos: %s", err)
+		scan.failed++
+		return scan
+	}
+	if settings.SourcePattern != "" {
+		photos = matchSourcePattern(photos, scan)
+	}
+	if settings.CloudFiles != nil {
+		photos = skipCloudPlaceholders(photos, settings)
+	}
+	photos = readablePhotos(photos, scan)
+	if settings.DateGuard != nil {
+		photos = guardPhotoDates(photos, settings)
+	}
+	if settings.RetroEdits != nil && !settings.SourceReadOnly {
+		photos = stageRetroPhotos(photos, settings)
+		if settings.StatePath != "" {
+			photos = stageDeletedNotes(photos, settings)
+		}
+	}
+	if settings.XMP != nil || settings.MinRating > 0 || settings.Keywords != nil {
+		scan.sidecars = readXMPSidecars(photos, scan.renamed)
+	}
+	if settings.MinRating > 0 || settings.Keywords != nil {
+		photos = filterBySidecars(photos, scan.sidecars, settings)
+	}
+	if hasPlugin(settings, "transform") {
+		photos = transformPhotos(photos, scan)
+	}
+	if len(settings.Scripts) > 0 {
+		photos = runScripts(photos, scan)
+	}
+	if settings.StatePath != "" {
+		photos = skipProcessedPhotos(photos, scan)
+	}
+	if len(photos) > 0 {
+		if err := os.MkdirAll(settings.TargetPhotoPath, 0755); err != nil {
+			logErrorf("unable to create %s, leaving the photos for the next import: %s", settings.TargetPhotoPath, err)
+			return scan
+		}
+	}
+	dates := make([]string, 0, len(photos))
+	for date := range photos {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	for _, date := range dates {
+		for _, photo := range photos[date] {
+			publish(importEvent{Type: eventPhotoDiscovered, Date: date, Source: photo})
+		}
+	}
+	scan.photos = photos
+	return scan
+}
+
+// moveScannedPhotos moves the photos of a date and their sidecars to the
+// target path of the folder and returns the moved photos with their
+// targets.
+func moveScannedPhotos(scan *folderScan, photos []string) ([]string, []string) {
+	settings := scan.settings
+	photos = moveImages(photos, settings)
+	targets := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		targets = append(targets, targetPath(photo, settings))
+	}
+	if settings.XMP != nil {
+		moveXMPSidecars(photos, targets, scan.sidecars, scan.renamed, settings)
+	}
+	removeMessageTexts(photos, settings)
+
+	if settings.WriteSidecars {
+		for i, photo := range photos {
+			original := originalName(photo, scan.renamed)
+			pipeline := sidecarPipeline(photo, original, targets[i], settings)
+			if err := writeSidecar(targets[i], original, pipeline, settings); err != nil {
+				logFields{"source": photo, "target": targets[i]}.errorf("unable to write sidecar for %s: %s", targets[i], err)
+			}
+		}
+	}
+	return photos, targets
+}
+
+// markFailedMoves sets the planned action of the photos that were not
+// moved, to quarantine when they left the source folder and to failed when
+// they were left for the next import.
+func markFailedMoves(scan *folderScan, photos []string, moved []string) {
+	if len(moved) == len(photos) {
+		return
+	}
+	done := make(map[string]bool, len(moved))
+	for _, photo := range moved {
+		done[photo] = true
+	}
+	for _, photo := range photos {
+		if done[photo] {
+			continue
+		}
+		action := "failed"
+		if !fileExists(photo) {
+			action = "quarantine"
+		}
+		markPlannedAction(scan.planned, originalName(photo, scan.renamed), action)
+	}
+}
+
+// finishFolder updates the checksum manifest of the folder and cleans up
+// the files that were not imported.
+func finishFolder(scan *folderScan, targets []string) {
+	settings := scan.settings
+	if len(targets) > 0 && settings.ChecksumManifest {
+		if err := updateManifest(targets, settings); err != nil {
+			logErrorf("unable to update checksum manifest: %s", err)
+		}
+	}
+
+	if settings.UnsortedPhotoPath != "" && !settings.SourceReadOnly && !shuttingDown() {
+		moved, err := moveUnsortedFiles(settings)
+		if err != nil {
+			logErrorf("unable to clean up %s: %s", settings.OriginalPhotoPath, err)
+		}
+		for _, name := range moved {
+			logFields{"source": path.Join(settings.OriginalPhotoPath, name), "target": path.Join(settings.UnsortedPhotoPath, name)}.infof("moved unrecognized file %s to %s", name, settings.UnsortedPhotoPath)
+			markPlannedAction(scan.planned, name, "unsorted")
+		}
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestGetDateFromFile(t *testing.T) {
+	tests := []struct {
+		name string
+		date string
+		ok   bool
+	}{
+		{"2024-05-01.jpg", "2024-05-01", true},
+		{"2024-05-01-02.png", "2024-05-01", true},
+		{"/vault/photos/2024-05-01.jpg", "2024-05-01", true},
+		{"2024-05-01xjpg", "", false},
+		{"2024-05-01.jpeg", "", false},
+		{"2024-05.jpg", "2024-05", true},
+		{"2024-05_03.jpg", "2024-05", true},
+		{"2024-05xjpg", "", false},
+		{"2024-05-01.mp4", "2024-05-01", true},
+		{"2024-05-01-01.mov", "2024-05-01", true},
+		{"2024.jpg", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		date, ok := getDateFromFile(test.name)
+		if date != test.date || ok != test.ok {
+			t.Errorf("getDateFromFile(%q) = %q, %v, want %q, %v", test.name, date, ok, test.date, test.ok)
+		}
+	}
+}
+
+func FuzzGetDateFromFile(f *testing.F) {
+	for _, name := range []string{"2024-05-01.jpg", "2024-05-01-02.png", "2024-05_03.jpg", "2024-05-01.mp4", "a/2024-05-01.jpg", "", "2024-05-01xjpg"} {
+		f.Add(name)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		date, ok := getDateFromFile(name)
+		if !ok {
+			if date != "" {
+				t.Errorf("getDateFromFile(%q) returned %q without a match", name, date)
+			}
+			return
+		}
+		if date == "" || !strings.HasPrefix(path.Base(name), date) {
+			t.Errorf("getDateFromFile(%q) = %q, not a prefix of the name", name, date)
+		}
+	})
+}
+
+func TestCountEmbedsOfEverySource(t *testing.T) {
+	settings := testSettings(t, "sources:\n  - name: camera\n    path: "+t.TempDir()+"\n    image_prefix: camera-\n")
+	note := path.Join(settings.ObsidianFilePath, "2024-05-01.md")
+	content := "# 2024-05-01\n\n![[diary-image-2024-05-01.jpg]]\n![[camera-2024-05-01.jpg]]\n![[camera-2024-05-01-01.jpg]]\n![[other.png]]\n"
+	if err := os.WriteFile(note, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if count := countEmbeds(note, settings); count != 3 {
+		t.Errorf("countEmbeds() = %d, want 3", count)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const manifestName = "manifest.sha256"
+
+// updateManifest adds the given attachments to the checksum manifest in the
+// target photo path, or removes them when they no longer exist. The manifest uses the sha256sum format, so it can be
+// checked with `sha256sum -c manifest.sha256`.
+func updateManifest(targets []string, settings *appSettings) error {
+	manifestPath := path.Join(settings.TargetPhotoPath, manifestName)
+	checksums, err := readManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if path.Clean(path.Dir(target)) != path.Clean(settings.TargetPhotoPath) {
+			continue
+		}
+		if !fileExists(target) {
+			delete(checksums, path.Base(target))
+			continue
+		}
+		hash, err := fileSHA256(target)
+		if err != nil {
+			return err
+		}
+		checksums[path.Base(target)] = hash
+	}
+
+	names := make([]string, 0, len(checksums))
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&content, "%s  %s\n", checksums[name], name)
+	}
+
+	tempPath := manifestPath + ".tmp"
+	if err := os.WriteFile(tempPath, []byte(content.String()), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %v", tempPath, err)
+	}
+	if err := os.Rename(tempPath, manifestPath); err != nil {
+		return fmt.Errorf("unable to replace %s: %v", manifestPath, err)
+	}
+	return nil
+}
+
+func readManifest(manifestPath string) (map[string]string, error) {
+	checksums := make(map[string]string)
+	f, err := os.Open(manifestPath)
+	if os.IsNotExist(err) {
+		return checksums, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", manifestPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, name, found := strings.Cut(scanner.Text(), "  ")
+		if found {
+			checksums[name] = hash
+		}
+	}
+	return checksums, scanner.Err()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const processedSuffix = ".processed"
+
+// processedMarkerSettings keep the imported photos in source folders the
+// import can't delete from, like read-only shares. The photos are copied
+// and marked as processed instead, in a local ledger or, when the share
+// takes new files, with a .processed sidecar next to the photo. Both hold
+// the size and the modification time of the photo. Later scans skip the
+// marked photos, and a photo that changed after it was marked is imported
+// again.
+type processedMarkerSettings struct {
+	Mode       string `yaml:"mode"`
+	LedgerPath string `yaml:"ledger_path"`
+}
+
+// ledgerEntry marks a photo as processed in the ledger, which is a JSON
+// lines file of entries.
+type ledgerEntry struct {
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	Target      string    `json:"target"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// processedLedgers are the ledgers read so far by their paths. The import
+// is the only writer, so they are read once and kept up to date in memory.
+var processedLedgers = struct {
+	sync.Mutex
+	entries map[string]map[string]ledgerEntry
+}{entries: make(map[string]map[string]ledgerEntry)}
+
+func validateProcessedMarkers(settings *processedMarkerSettings) error {
+	switch settings.Mode {
+	case "", "ledger":
+		if settings.LedgerPath == "" {
+			return fmt.Errorf("processed_markers.ledger_path is not set")
+		}
+	case "sidecar":
+	default:
+		return fmt.Errorf("unknown processed_markers.mode %s", settings.Mode)
+	}
+	return nil
+}
+
+// disposeSource removes the imported photo from the source folder, or
+// marks it as processed when the source keeps its photos. Read-only sources
+// without markers are left as they are, the state skips their imported
+// photos.
+func disposeSource(photo string, target string, settings *appSettings) error {
+	if settings.ProcessedMarkers != nil {
+		return markProcessed(photo, target, settings.ProcessedMarkers)
+	}
+	if settings.SourceReadOnly {
+		return nil
+	}
+	return os.Remove(photo)
+}
+
+func markProcessed(photo string, target string, settings *processedMarkerSettings) error {
+	key, err := filepath.Abs(photo)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(photo)
+	if err != nil {
+		return err
+	}
+	entry := ledgerEntry{Path: key, Size: info.Size(), ModTime: info.ModTime(), Target: target, ProcessedAt: time.Now()}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if settings.Mode == "sidecar" {
+		if err := os.WriteFile(photo+processedSuffix, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("unable to mark %s as processed: %v", photo, err)
+		}
+		return nil
+	}
+
+	processedLedgers.Lock()
+	defer processedLedgers.Unlock()
+	entries, err := loadLedger(settings.LedgerPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(settings.LedgerPath), 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", path.Dir(settings.LedgerPath), err)
+	}
+	f, err := os.OpenFile(settings.LedgerPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to mark %s as processed: %v", photo, err)
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to mark %s as processed: %v", photo, err)
+	}
+	entries[key] = entry
+	return nil
+}
+
+// loadLedger reads the ledger unless it was read already. The caller holds
+// the lock. A missing ledger is empty.
+func loadLedger(ledgerPath string) (map[string]ledgerEntry, error) {
+	if entries, ok := processedLedgers.entries[ledgerPath]; ok {
+		return entries, nil
+	}
+	entries := make(map[string]ledgerEntry)
+	f, err := os.Open(ledgerPath)
+	if os.IsNotExist(err) {
+		processedLedgers.entries[ledgerPath] = entries
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the ledger %s: %v", ledgerPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry ledgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries[entry.Path] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read the ledger %s: %v", ledgerPath, err)
+	}
+	processedLedgers.entries[ledgerPath] = entries
+	return entries, nil
+}
+
+// isMarkedProcessed tells whether the file of the source folder is a
+// processed photo that didn't change since it was marked, or the marker
+// of one.
+func isMarkedProcessed(file fs.DirEntry, settings *appSettings) bool {
+	markers := settings.ProcessedMarkers
+	if markers == nil {
+		return false
+	}
+	photo := path.Join(settings.OriginalPhotoPath, file.Name())
+	var entry ledgerEntry
+	if markers.Mode == "sidecar" {
+		if strings.HasSuffix(file.Name(), processedSuffix) {
+			return true
+		}
+		data, err := os.ReadFile(photo + processedSuffix)
+		if err != nil {
+			return false
+		}
+		// The markers written before they held the size and the
+		// modification time only had the target and the time.
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return true
+		}
+	} else {
+		key, err := filepath.Abs(photo)
+		if err != nil {
+			return false
+		}
+		processedLedgers.Lock()
+		entries, err := loadLedger(markers.LedgerPath)
+		processedLedgers.Unlock()
+		if err != nil {
+			logErrorf("unable to check for processed photos: %s", err)
+			return false
+		}
+		var ok bool
+		if entry, ok = entries[key]; !ok {
+			return false
+		}
+	}
+	info, err := file.Info()
+	return err == nil && info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// matrixSettings configure importing images posted to a private Matrix
+// room. The sync token is stored between runs so every image is imported
+// only once.
+type matrixSettings struct {
+	Homeserver    string `yaml:"homeserver"`
+	AccessToken   string `yaml:"access_token"`
+	Room          string `yaml:"room"`
+	Reaction      string `yaml:"reaction"`
+	SyncTokenPath string `yaml:"sync_token_path"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+type matrixEvent struct {
+	Type           string `json:"type"`
+	EventID        string `json:"event_id"`
+	OriginServerTS int64  `json:"origin_server_ts"`
+	Content        struct {
+		MsgType string `json:"msgtype"`
+		URL     string `json:"url"`
+		Info    struct {
+			MimeType string `json:"mimetype"`
+		} `json:"info"`
+	} `json:"content"`
+}
+
+type matrixSyncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []matrixEvent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+var matrixClient = &http.Client{Timeout: 60 * time.Second}
+
+// importMatrixImages joins the configured room, syncs new messages and
+// copies posted images into the original photo path, named by the message
+// date. Each imported image is acknowledged with a reaction. The images
+// imported before the sync token is stored are kept in a list next to it,
+// so an import that stops halfway doesn't download them again.
+func importMatrixImages(settings *appSettings) (int, error) {
+	matrix := settings.Matrix
+	if matrix.SyncTokenPath == "" {
+		return 0, fmt.Errorf("matrix sync_token_path is not set")
+	}
+
+	var joined struct {
+		RoomID string `json:"room_id"`
+	}
+	joinPath := "/_matrix/client/v3/join/" + url.PathEscape(matrix.Room)
+	if err := matrixRequest(matrix, "POST", joinPath, struct{}{}, &joined); err != nil {
+		return 0, err
+	}
+
+	since := ""
+	if data, err := os.ReadFile(matrix.SyncTokenPath); err == nil {
+		since = strings.TrimSpace(string(data))
+	}
+
+	filter := fmt.Sprintf(`{"room":{"rooms":[%q],"timeline":{"types":["m.room.message"]}}}`, joined.RoomID)
+	query := url.Values{"filter": {filter}, "timeout": {"0"}}
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	var sync matrixSyncResponse
+	if err := matrixRequest(matrix, "GET", "/_matrix/client/v3/sync?"+query.Encode(), nil, &sync); err != nil {
+		return 0, err
+	}
+	importedPath := matrix.SyncTokenPath + ".imported"
+	imported, err := readHashIndex(importedPath)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, event := range sync.Rooms.Join[joined.RoomID].Timeline.Events {
+		if event.Type != "m.room.message" || event.Content.MsgType != "m.image" {
+			continue
+		}
+		ext := extensionForContentType(event.Content.Info.MimeType)
+		if ext == "" || imported[event.EventID] {
+			continue
+		}
+
+		name, err := freePhotoName(time.UnixMilli(event.OriginServerTS).Local(), ext, settings)
+		if err != nil {
+			return count, err
+		}
+		target := path.Join(settings.OriginalPhotoPath, name)
+		if err := downloadMatrixMedia(matrix, event.Content.URL, target); err != nil {
+			os.Remove(target)
+			return count, err
+		}
+		if err := appendHashIndex(importedPath, event.EventID); err != nil {
+			return count, err
+		}
+		imported[event.EventID] = true
+		count++
+
+		if err := reactToMatrixEvent(matrix, joined.RoomID, event.EventID); err != nil {
+			logWarnf("unable to react to the Matrix event %s: %s", event.EventID, err)
+		}
+	}
+
+	if err := os.WriteFile(matrix.SyncTokenPath, []byte(sync.NextBatch), 0600); err != nil {
+		return count, fmt.Errorf("unable to store Matrix sync token: %v", err)
+	}
+	// The next sync starts after these events.
+	if err := os.Remove(importedPath); err != nil && !os.IsNotExist(err) {
+		logWarnf("unable to remove %s: %s", importedPath, err)
+	}
+	return count, nil
+}
+
+func downloadMatrixMedia(settings *matrixSettings, mxc string, target string) error {
+	if !strings.HasPrefix(mxc, "mxc://") {
+		return fmt.Errorf("unsupported media url %s", mxc)
+	}
+	media := strings.TrimPrefix(mxc, "mxc://")
+
+	resp, err := matrixGet(settings, "/_matrix/client/v1/media/download/"+media)
+	if err == nil && resp.StatusCode == http.StatusNotFound {
+		// Homeservers without authenticated media only serve the legacy endpoint.
+		resp.Body.Close()
+		resp, err = matrixGet(settings, "/_matrix/media/v3/download/"+media)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %v", mxc, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download %s: %s", mxc, resp.Status)
+	}
+
+	outputFile, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", target, err)
+	}
+	if _, err := io.Copy(outputFile, resp.Body); err != nil {
+		outputFile.Close()
+		return fmt.Errorf("unable to write %s: %v", target, err)
+	}
+	return outputFile.Close()
+}
+
+func reactToMatrixEvent(settings *matrixSettings, roomID string, eventID string) error {
+	reaction := settings.Reaction
+	if reaction == "" {
+		reaction = "✅"
+	}
+
+	content := map[string]interface{}{
+		"m.relates_to": map[string]string{
+			"rel_type": "m.annotation",
+			"event_id": eventID,
+			"key":      reaction,
+		},
+	}
+	txnID := fmt.Sprintf("diary-%d", time.Now().UnixNano())
+	reactionPath := fmt.Sprintf("/_matrix/client/v3/rooms/%s/send/m.reaction/%s", url.PathEscape(roomID), txnID)
+	return matrixRequest(settings, "PUT", reactionPath, content, nil)
+}
+
+func matrixGet(settings *matrixSettings, apiPath string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(settings.Homeserver, "/")+apiPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+settings.AccessToken)
+	return matrixClient.Do(req)
+}
+
+func matrixRequest(settings *matrixSettings, method string, apiPath string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal Matrix request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(settings.Homeserver, "/")+apiPath, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create Matrix request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+settings.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := matrixClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v", method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s returned %s", method, req.URL.Path, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to unmarshal Matrix response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// messageTextExtension is added to the name of a photo for the file with
+// the text of the message the photo was sent with.
+const messageTextExtension = ".txt"
+
+// writeMessageText keeps the text of the message a photo was sent with next
+// to the photo, for the messaging sources with message_text. The import
+// writes it into the note as the body of the entry.
+func writeMessageText(photo string, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if err := os.WriteFile(photo+messageTextExtension, []byte(text+"\n"), 0644); err != nil {
+		return fmt.Errorf("unable to keep the message text of %s: %v", photo, err)
+	}
+	return nil
+}
+
+// messageText returns the text of the message the photo was sent with, or
+// an empty string when it came without one.
+func messageText(photo string) string {
+	data, err := os.ReadFile(photo + messageTextExtension)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n"))
+}
+
+// removeMessageTexts removes the message texts of the moved photos, which
+// are in their notes now. Read-only sources keep them.
+func removeMessageTexts(photos []string, settings *appSettings) {
+	if settings.SourceReadOnly {
+		return
+	}
+	for _, photo := range photos {
+		err := os.Remove(photo + messageTextExtension)
+		if err != nil && !os.IsNotExist(err) {
+			logFields{"source": photo}.warnf("unable to remove the message text of %s: %s", photo, err)
+		}
+	}
+}
+
+// messageBody returns the message texts of the photos as paragraphs, the
+// body of the entry written after the links.
+func messageBody(photos []notePhoto) string {
+	paragraphs := make([]string, 0)
+	for _, photo := range photos {
+		if photo.text != "" {
+			paragraphs = append(paragraphs, photo.text)
+		}
+	}
+	if len(paragraphs) == 0 {
+		return ""
+	}
+	return "\n" + strings.Join(paragraphs, "\n\n") + "\n"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// photoMetadata is what the import reads from the metadata of a photo.
+// The capture time is zero and located false when they are unknown.
+type photoMetadata struct {
+	captured  time.Time
+	latitude  float64
+	longitude float64
+	located   bool
+}
+
+// metadataReader reads the metadata of a photo. The readers are asked in
+// order, and a later reader only fills in what the earlier ones missed.
+type metadataReader interface {
+	readMetadata(filePath string) (photoMetadata, error)
+}
+
+// exiftoolSettings enable the exiftool fallback for the formats the native
+// parser doesn't read, like HEIC, RAW files and videos.
+type exiftoolSettings struct {
+	Command        []string `yaml:"command"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+}
+
+// nativeMetadata reads the EXIF data of JPEG and PNG photos.
+type nativeMetadata struct{}
+
+func (nativeMetadata) readMetadata(filePath string) (photoMetadata, error) {
+	var metadata photoMetadata
+	tiff, err := readEXIF(filePath)
+	if err != nil || tiff == nil {
+		return metadata, err
+	}
+	if captured, ok := exifDate(tiff); ok {
+		metadata.captured = captured
+	}
+	metadata.latitude, metadata.longitude, metadata.located = exifGPS(tiff)
+	return metadata, nil
+}
+
+// exiftoolMetadata runs exiftool, when it is installed, for the metadata.
+type exiftoolMetadata struct {
+	command []string
+	timeout time.Duration
+}
+
+// exiftoolMissing is logged once per run instead of once per photo.
+var exiftoolMissing sync.Once
+
+func (reader exiftoolMetadata) readMetadata(filePath string) (photoMetadata, error) {
+	var metadata photoMetadata
+	if _, err := exec.LookPath(reader.command[0]); err != nil {
+		exiftoolMissing.Do(func() {
+			logWarnf("exiftool is not available, reading metadata natively only: %s", err)
+		})
+		return metadata, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), reader.timeout)
+	defer cancel()
+	args := append(append([]string{}, reader.command[1:]...), "-json", "-n", "-api", "QuickTimeUTC",
+		"-DateTimeOriginal", "-CreateDate", "-GPSLatitude", "-GPSLongitude", filePath)
+	output, err := exec.CommandContext(ctx, reader.command[0], args...).Output()
+	if err != nil {
+		return metadata, fmt.Errorf("exiftool failed for %s: %v", filePath, err)
+	}
+
+	var tags []struct {
+		DateTimeOriginal interface{}
+		CreateDate       interface{}
+		GPSLatitude      *float64
+		GPSLongitude     *float64
+	}
+	if err := json.Unmarshal(output, &tags); err != nil || len(tags) == 0 {
+		return metadata, fmt.Errorf("invalid exiftool output for %s: %v", filePath, err)
+	}
+	for _, value := range []interface{}{tags[0].DateTimeOriginal, tags[0].CreateDate} {
+		text, ok := value.(string)
+		if !ok || len(text) < 19 {
+			continue
+		}
+		captured, err := time.ParseInLocation("2006:01:02 15:04:05", text[:19], time.Local)
+		if err == nil && captured.Year() > 1900 {
+			metadata.captured = captured
+			break
+		}
+	}
+	if tags[0].GPSLatitude != nil && tags[0].GPSLongitude != nil {
+		metadata.latitude = *tags[0].GPSLatitude
+		metadata.longitude = *tags[0].GPSLongitude
+		metadata.located = true
+	}
+	return metadata, nil
+}
+
+func metadataReaders(settings *appSettings) []metadataReader {
+	readers := []metadataReader{nativeMetadata{}}
+	if settings.ExifTool != nil {
+		command := settings.ExifTool.Command
+		if len(command) == 0 {
+			command = []string{"exiftool"}
+		}
+		timeout := time.Duration(settings.ExifTool.TimeoutSeconds) * time.Second
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		readers = append(readers, exiftoolMetadata{command, timeout})
+	}
+	return readers
+}
+
+// readPhotoMetadata asks the readers in order until the capture time and
+// the location are known.
+func readPhotoMetadata(filePath string, settings *appSettings) photoMetadata {
+	defer timeFileStage(filePath, "metadata", time.Now())
+	var result photoMetadata
+	for _, reader := range metadataReaders(settings) {
+		metadata, err := reader.readMetadata(filePath)
+		if err != nil {
+			logFields{"source": filePath}.debugf("unable to read metadata of %s: %s", filePath, err)
+			continue
+		}
+		if result.captured.IsZero() {
+			result.captured = metadata.captured
+		}
+		if !result.located && metadata.located {
+			result.latitude, result.longitude, result.located = metadata.latitude, metadata.longitude, true
+		}
+		if !result.captured.IsZero() && result.located {
+			break
+		}
+	}
+	return result
+}
+
+// captureTime returns the time the photo was taken according to its
+// metadata.
+func captureTime(filePath string, settings *appSettings) (time.Time, bool) {
+	defer timeFileStage(filePath, "metadata", time.Now())
+	for _, reader := range metadataReaders(settings) {
+		metadata, err := reader.readMetadata(filePath)
+		if err == nil && !metadata.captured.IsZero() {
+			return metadata.captured, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// histogram counts observations in cumulative buckets, like a Prometheus
+// histogram.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// importMetrics follows the imports of the process through the event bus
+// for the metrics endpoint of the watch API.
+type importMetrics struct {
+	sync.Mutex
+	photos         uint64
+	bytesMoved     int64
+	notes          map[string]uint64
+	errors         map[string]uint64
+	photosPerScan  *histogram
+	scanDuration   *histogram
+	lastScan       time.Time
+	lastPhoto      time.Time
+	scansCompleted uint64
+}
+
+var currentMetrics = &importMetrics{
+	notes:         map[string]uint64{"created": 0, "appended": 0},
+	errors:        make(map[string]uint64),
+	photosPerScan: newHistogram(0, 1, 2, 5, 10, 20, 50, 100),
+	scanDuration:  newHistogram(0.1, 0.5, 1, 5, 10, 30, 60, 300, 900),
+}
+
+func (m *importMetrics) handleEvent(event importEvent) error {
+	m.Lock()
+	defer m.Unlock()
+	switch event.Type {
+	case eventPhotoImported:
+		m.photos++
+		m.lastPhoto = time.Now()
+		if info, err := os.Stat(event.Target); err == nil {
+			m.bytesMoved += info.Size()
+		}
+	case eventNoteCreated:
+		m.notes["created"]++
+	case eventNoteUpdated:
+		m.notes["appended"]++
+	case eventError:
+		m.errors[errorKind(event)]++
+	}
+	return nil
+}
+
+// errorKind sorts the errors by what failed: a photo, a note or the
+// import itself.
+func errorKind(event importEvent) string {
+	switch {
+	case event.Source != "" || event.Target != "":
+		return "photo"
+	case event.Note != "" || event.Date != "":
+		return "note"
+	}
+	return "import"
+}
+
+// observeImport adds a finished import to the histograms.
+func observeImport(started time.Time, imported map[string][]string) {
+	photos := 0
+	for _, datePhotos := range imported {
+		photos += len(datePhotos)
+	}
+	currentMetrics.Lock()
+	defer currentMetrics.Unlock()
+	currentMetrics.photosPerScan.observe(float64(photos))
+	currentMetrics.scanDuration.observe(time.Since(started).Seconds())
+	currentMetrics.lastScan = time.Now()
+	currentMetrics.scansCompleted++
+}
+
+// serveMetrics writes the metrics in the Prometheus text format.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	currentMetrics.Lock()
+	defer currentMetrics.Unlock()
+	m := currentMetrics
+
+	fmt.Fprintln(w, "# HELP diary_photos_processed_total Photos imported into the vault.")
+	fmt.Fprintln(w, "# TYPE diary_photos_processed_total counter")
+	fmt.Fprintf(w, "diary_photos_processed_total %d\n", m.photos)
+	fmt.Fprintln(w, "# HELP diary_bytes_moved_total Bytes of the imported attachments.")
+	fmt.Fprintln(w, "# TYPE diary_bytes_moved_total counter")
+	fmt.Fprintf(w, "diary_bytes_moved_total %d\n", m.bytesMoved)
+	fmt.Fprintln(w, "# HELP diary_notes_total Notes created or appended to.")
+	fmt.Fprintln(w, "# TYPE diary_notes_total counter")
+	writeLabeled(w, "diary_notes_total", "action", m.notes)
+	fmt.Fprintln(w, "# HELP diary_errors_total Errors logged, by what failed.")
+	fmt.Fprintln(w, "# TYPE diary_errors_total counter")
+	writeLabeled(w, "diary_errors_total", "kind", m.errors)
+	fmt.Fprintln(w, "# HELP diary_scans_total Imports that finished.")
+	fmt.Fprintln(w, "# TYPE diary_scans_total counter")
+	fmt.Fprintf(w, "diary_scans_total %d\n", m.scansCompleted)
+	fmt.Fprintln(w, "# HELP diary_photos_per_scan Photos imported by an import.")
+	fmt.Fprintln(w, "# TYPE diary_photos_per_scan histogram")
+	m.photosPerScan.write(w, "diary_photos_per_scan")
+	fmt.Fprintln(w, "# HELP diary_scan_duration_seconds Duration of an import.")
+	fmt.Fprintln(w, "# TYPE diary_scan_duration_seconds histogram")
+	m.scanDuration.write(w, "diary_scan_duration_seconds")
+	fmt.Fprintln(w, "# HELP diary_last_scan_timestamp_seconds Time the last import finished.")
+	fmt.Fprintln(w, "# TYPE diary_last_scan_timestamp_seconds gauge")
+	fmt.Fprintf(w, "diary_last_scan_timestamp_seconds %d\n", unixOrZero(m.lastScan))
+	fmt.Fprintln(w, "# HELP diary_last_photo_timestamp_seconds Time the last photo was imported.")
+	fmt.Fprintln(w, "# TYPE diary_last_photo_timestamp_seconds gauge")
+	fmt.Fprintf(w, "diary_last_photo_timestamp_seconds %d\n", unixOrZero(m.lastPhoto))
+}
+
+func writeLabeled(w io.Writer, name string, label string, values map[string]uint64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, strings.ReplaceAll(key, "\n", " "), values[key])
+	}
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
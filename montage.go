@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+)
+
+// montageSettings configure an animated montage of the photos of days
+// with at least min_photos photos. It is written as a GIF, or as an MP4
+// with ffmpeg, next to the photos and embedded at the top of the note.
+// Montages are not made of encrypted attachments.
+type montageSettings struct {
+	MinPhotos    int    `yaml:"min_photos"`
+	Format       string `yaml:"format"`
+	MaxDimension int    `yaml:"max_dimension"`
+	FrameMillis  int    `yaml:"frame_ms"`
+	FFmpeg       string `yaml:"ffmpeg"`
+}
+
+const montageSuffix = ".montage"
+
+// updateMontage makes the montage of the date from the photos of every
+// folder and embeds it in the note if it isn't already.
+func updateMontage(date string, folders []*appSettings) {
+	settings := folders[0]
+	montage := settings.Montage
+	if settings.Encryption != nil {
+		return
+	}
+	minPhotos := montage.MinPhotos
+	if minPhotos <= 0 {
+		minPhotos = 6
+	}
+
+	photos := dayAttachments(date, folders)
+	if len(photos) < minPhotos {
+		return
+	}
+
+	ext := ".gif"
+	if montage.Format == "mp4" {
+		ext = ".mp4"
+	}
+	name := settings.ImagePrefix + date + montageSuffix + ext
+	target := path.Join(settings.TargetPhotoPath, name)
+	logInfof("making a montage of %d photos for %s", len(photos), date)
+	if err := writeMontage(photos, target, montage); err != nil {
+		logErrorf("unable to make a montage for %s: %s", date, err)
+		return
+	}
+
+	embed := fmt.Sprintf("![[%s]]", name)
+	if err := insertAtTop(notePath(date, settings), embed, settings); err != nil {
+		logErrorf("unable to add the montage to the note of %s: %s", date, err)
+	}
+}
+
+// isGeneratedAttachment tells whether the attachment was made from the
+// photos of a day, like a montage or a contact sheet.
+func isGeneratedAttachment(name string) bool {
+	base := strings.TrimSuffix(name, path.Ext(name))
+	for _, suffix := range []string{montageSuffix, contactSheetSuffix} {
+		if !strings.HasSuffix(base, suffix) {
+			continue
+		}
+		if _, ok := getDateFromFile(strings.TrimSuffix(base, suffix) + ".jpg"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dayAttachments returns the photo attachments of the date in the target
+// paths of the folders, sorted by name.
+func dayAttachments(date string, folders []*appSettings) []string {
+	photos := make([]string, 0)
+	for _, folder := range folders {
+		files, err := os.ReadDir(folder.TargetPhotoPath)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name := strings.TrimPrefix(file.Name(), folder.ImagePrefix)
+			if file.IsDir() || !strings.HasPrefix(file.Name(), folder.ImagePrefix) || photoExtension(name) == "" {
+				continue
+			}
+			if day, ok := getDateFromFile(name); ok && day == date {
+				photos = append(photos, path.Join(folder.TargetPhotoPath, file.Name()))
+			}
+		}
+	}
+	sort.Slice(photos, func(i, j int) bool {
+		return path.Base(photos[i]) < path.Base(photos[j])
+	})
+	return photos
+}
+
+func writeMontage(photos []string, target string, settings *montageSettings) error {
+	maxDimension := settings.MaxDimension
+	if maxDimension <= 0 {
+		maxDimension = 480
+	}
+	frameMillis := settings.FrameMillis
+	if frameMillis <= 0 {
+		frameMillis = 800
+	}
+
+	frames := make([]image.Image, 0, len(photos))
+	width, height := 0, 0
+	for _, photo := range photos {
+		frame, err := decodeScaled(photo, maxDimension)
+		if err != nil {
+			logWarnf("left %s out of the montage: %s", photo, err)
+			continue
+		}
+		frames = append(frames, frame)
+		if frame.Bounds().Dx() > width {
+			width = frame.Bounds().Dx()
+		}
+		if frame.Bounds().Dy() > height {
+			height = frame.Bounds().Dy()
+		}
+	}
+	if len(frames) == 0 {
+		return fmt.Errorf("none of the photos could be read")
+	}
+
+	canvases := make([]*image.RGBA, 0, len(frames))
+	for _, frame := range frames {
+		canvases = append(canvases, centerOnCanvas(frame, width, height))
+	}
+	if settings.Format == "mp4" {
+		return encodeMP4(canvases, target, frameMillis, settings)
+	}
+	return encodeGIF(canvases, target, frameMillis)
+}
+
+func decodeScaled(photo string, maxDimension int) (image.Image, error) {
+	f, err := os.Open(photo)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode: %v", err)
+	}
+	if img.Bounds().Dx() <= maxDimension && img.Bounds().Dy() <= maxDimension {
+		return img, nil
+	}
+	return scaleImage(img, maxDimension), nil
+}
+
+// centerOnCanvas draws the frame in the middle of a black canvas, as the
+// frames of a montage all have the same size.
+func centerOnCanvas(frame image.Image, width int, height int) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{color.Black}, image.Point{}, draw.Src)
+	bounds := frame.Bounds()
+	offset := image.Pt((width-bounds.Dx())/2, (height-bounds.Dy())/2)
+	draw.Draw(canvas, image.Rectangle{offset, offset.Add(bounds.Size())}, frame, bounds.Min, draw.Src)
+	return canvas
+}
+
+func encodeGIF(frames []*image.RGBA, target string, frameMillis int) error {
+	animation := &gif.GIF{}
+	for _, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(paletted, frame.Bounds(), frame, image.Point{})
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, frameMillis/10)
+	}
+
+	temp := target + ".tmp"
+	f, err := os.Create(temp)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", temp, err)
+	}
+	if err := gif.EncodeAll(f, animation); err != nil {
+		f.Close()
+		os.Remove(temp)
+		return fmt.Errorf("unable to encode the montage: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, target)
+}
+
+// encodeMP4 writes the frames to a temporary folder and lets ffmpeg encode
+// them.
+func encodeMP4(frames []*image.RGBA, target string, frameMillis int, settings *montageSettings) error {
+	dir, err := os.MkdirTemp("", "diary-montage-")
+	if err != nil {
+		return fmt.Errorf("unable to create a temporary folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, frame := range frames {
+		f, err := os.Create(path.Join(dir, fmt.Sprintf("%04d.jpg", i)))
+		if err != nil {
+			return err
+		}
+		err = jpeg.Encode(f, frame, &jpeg.Options{Quality: 90})
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("unable to write a frame: %v", err)
+		}
+	}
+
+	command := settings.FFmpeg
+	if command == "" {
+		command = "ffmpeg"
+	}
+	temp := path.Join(dir, "montage.mp4")
+	framerate := fmt.Sprintf("%d/%d", 1000, frameMillis)
+	output, err := exec.Command(command, "-y", "-loglevel", "error", "-framerate", framerate, "-i", path.Join(dir, "%04d.jpg"),
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", "-pix_fmt", "yuv420p", "-r", "25", temp).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", command, err, strings.TrimSpace(string(output)))
+	}
+	return moveFile(temp, target)
+}
+
+// insertAtTop adds the line to the note after its front matter and title,
+// unless the note already has it.
+func insertAtTop(diaryFilePath string, line string, settings *appSettings) error {
+	data, err := os.ReadFile(diaryFilePath)
+	if err != nil || strings.Contains(string(data), line) {
+		return nil
+	}
+	return rewriteNote(diaryFilePath, settings, func(original []byte) []byte {
+		note := string(original)
+		if strings.Contains(note, line) {
+			return original
+		}
+		newline := "\n"
+		if strings.Contains(note, "\r\n") {
+			newline = "\r\n"
+		}
+
+		lines := strings.SplitAfter(note, newline)
+		i := 0
+		if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+			for i = 1; i < len(lines) && strings.TrimSpace(lines[i]) != "---"; i++ {
+			}
+			i++
+		}
+		if i < len(lines) && strings.HasPrefix(lines[i], "# ") {
+			i++
+			if i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+				i++
+			}
+		}
+		if i > len(lines) {
+			i = len(lines)
+		}
+		head := strings.Join(lines[:i], "")
+		return []byte(head + line + newline + newline + strings.Join(lines[i:], ""))
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeName returns the NFC form of a file name. macOS sync clients
+// may write names in NFD, so the same name can arrive in two byte forms
+// that look identical in the vault.
+func normalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
+// sameContent tells whether both files have the same content.
+func sameContent(first string, second string) bool {
+	firstHash, err := fileSHA256(first)
+	if err != nil {
+		return false
+	}
+	secondHash, err := fileSHA256(second)
+	return err == nil && firstHash == secondHash
+}
+
+// findFile returns the path of the file with the given name in dir,
+// comparing the names by their normalized forms when there is no exact
+// match.
+func findFile(dir string, name string) (string, bool) {
+	filePath := path.Join(dir, name)
+	if fileExists(filePath) {
+		return filePath, true
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false
+	}
+	normalized := normalizeName(name)
+	for _, file := range files {
+		if !file.IsDir() && normalizeName(file.Name()) == normalized {
+			return path.Join(dir, file.Name()), true
+		}
+	}
+	return "", false
+}
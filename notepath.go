@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notePath returns the path of the note of the date. The names and folders
+// of daily notes follow note_name_format and note_folder_format, strftime
+// formats such as "%Y-%m-%d %A" and "%Y/%m", so the notes match the layout
+// of the Periodic Notes plugin. Monthly notes are named by the month.
+func notePath(date string, settings *appSettings) string {
+	day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil || isMonthKey(date) {
+		return path.Join(settings.ObsidianFilePath, fmt.Sprintf("%s.md", date))
+	}
+
+	name := date
+	if settings.NoteNameFormat != "" {
+		name = strftime(day, settings.NoteNameFormat)
+	}
+	folder := strftime(day, settings.NoteFolderFormat)
+	return path.Join(settings.ObsidianFilePath, folder, fmt.Sprintf("%s.md", name))
+}
+
+// createNoteFolder creates the dated folder of a new note.
+func createNoteFolder(diaryFilePath string, settings *appSettings) error {
+	if settings.NoteFolderFormat == "" {
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(diaryFilePath), 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", path.Dir(diaryFilePath), err)
+	}
+	return nil
+}
+
+// noteFiles returns the paths of the notes relative to the note folder,
+// including the notes in subfolders when notes are kept in dated folders.
+func noteFiles(settings *appSettings) ([]string, error) {
+	root := settings.ObsidianFilePath
+	if settings.NoteFolderFormat == "" {
+		entries, err := os.ReadDir(root)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read path %s: %v", root, err)
+		}
+		notes := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !entry.IsDir() && path.Ext(entry.Name()) == ".md" {
+				notes = append(notes, entry.Name())
+			}
+		}
+		return notes, nil
+	}
+
+	notes := make([]string, 0)
+	err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if filePath != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if path.Ext(entry.Name()) == ".md" {
+			relative, err := filepath.Rel(root, filePath)
+			if err != nil {
+				return err
+			}
+			notes = append(notes, filepath.ToSlash(relative))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read path %s: %v", root, err)
+	}
+	return notes, nil
+}
+
+// strftime formats the time with the strftime conversions that make sense
+// for dates. Unknown conversions are kept as they are.
+func strftime(t time.Time, format string) string {
+	var builder strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i == len(format)-1 {
+			builder.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'Y':
+			builder.WriteString(t.Format("2006"))
+		case 'y':
+			builder.WriteString(t.Format("06"))
+		case 'm':
+			builder.WriteString(t.Format("01"))
+		case 'd':
+			builder.WriteString(t.Format("02"))
+		case 'e':
+			builder.WriteString(t.Format("_2"))
+		case 'j':
+			builder.WriteString(fmt.Sprintf("%03d", t.YearDay()))
+		case 'B':
+			builder.WriteString(t.Format("January"))
+		case 'b':
+			builder.WriteString(t.Format("Jan"))
+		case 'A':
+			builder.WriteString(t.Format("Monday"))
+		case 'a':
+			builder.WriteString(t.Format("Mon"))
+		case 'u':
+			weekday := int(t.Weekday())
+			if weekday == 0 {
+				weekday = 7
+			}
+			builder.WriteString(strconv.Itoa(weekday))
+		case 'V':
+			_, week := t.ISOWeek()
+			builder.WriteString(fmt.Sprintf("%02d", week))
+		case 'G':
+			year, _ := t.ISOWeek()
+			builder.WriteString(strconv.Itoa(year))
+		case 'F':
+			builder.WriteString(t.Format("2006-01-02"))
+		case '%':
+			builder.WriteByte('%')
+		default:
+			builder.WriteByte('%')
+			builder.WriteByte(format[i])
+		}
+	}
+	return builder.String()
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notificationSettings push alerts to the notifiers when an import fails
+// or quarantines files, and a daily summary of the imported photos. The
+// summary is sent by the first import after summary_hour, 21 by default,
+// and the photos imported until then are counted in summary_path, next to
+// the lock file by default. Action URL is the address the phone reaches
+// the watch API on, for the approve and skip buttons of the staged photos.
+type notificationSettings struct {
+	Notifiers   []notifierSettings `yaml:"notifiers"`
+	SummaryHour *int               `yaml:"summary_hour"`
+	SummaryPath string             `yaml:"summary_path"`
+	ActionURL   string             `yaml:"action_url"`
+}
+
+// notifierSettings send the notifications to an ntfy topic, a webhook or
+// by email. Notify lists failure, quarantine, staged and summary, failure
+// and quarantine when it is empty.
+type notifierSettings struct {
+	Name           string         `yaml:"name"`
+	Notify         []string       `yaml:"notify"`
+	Ntfy           string         `yaml:"ntfy"`
+	Token          string         `yaml:"token"`
+	Webhook        string         `yaml:"webhook"`
+	Email          *emailSettings `yaml:"email"`
+	TimeoutSeconds int            `yaml:"timeout_seconds"`
+}
+
+type emailSettings struct {
+	SMTP     string   `yaml:"smtp"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+}
+
+const (
+	notifyFailure    = "failure"
+	notifyQuarantine = "quarantine"
+	notifyStaged     = "staged"
+	notifySummary    = "summary"
+)
+
+// notification is what the notifiers send.
+type notification struct {
+	Kind     string               `json:"kind"`
+	Title    string               `json:"title"`
+	Message  string               `json:"message"`
+	Priority string               `json:"priority"`
+	Actions  []notificationAction `json:"actions,omitempty"`
+}
+
+// notificationAction is a button of the notification that posts to the
+// watch API. ntfy sends the API token with it, the webhooks get only the
+// URL.
+type notificationAction struct {
+	Label string `json:"label"`
+	URL   string `json:"url"`
+	token string
+}
+
+// pendingSummary counts the photos imported per date since the last
+// summary.
+type pendingSummary struct {
+	LastSent string         `json:"last_sent"`
+	Photos   map[string]int `json:"photos"`
+}
+
+// importNotifications collects the errors, the quarantined and rejected
+// files and the photos staged by date of an import through the event bus.
+type importNotifications struct {
+	sync.Mutex
+	errors      []string
+	quarantined []string
+	rejected    []string
+	reasons     map[string]string
+	staged      map[string]*stagedNotice
+}
+
+// stagedNotice is the photos staged for a note and why, when it is not the
+// age of the note.
+type stagedNotice struct {
+	photos int
+	reason string
+}
+
+var currentNotifications = &importNotifications{reasons: make(map[string]string), staged: make(map[string]*stagedNotice)}
+
+func (n *importNotifications) handleEvent(event importEvent) error {
+	n.Lock()
+	defer n.Unlock()
+	switch event.Type {
+	case eventPhotoQuarantined:
+		n.quarantined = append(n.quarantined, event.Source)
+		n.reasons[event.Source] = event.Message
+	case eventPhotoRejected:
+		n.rejected = append(n.rejected, event.Source)
+		n.reasons[event.Source] = event.Message
+	case eventPhotoStaged:
+		notice, ok := n.staged[event.Date]
+		if !ok {
+			notice = &stagedNotice{}
+			n.staged[event.Date] = notice
+		}
+		notice.photos++
+		if event.Message != "" {
+			notice.reason = event.Message
+		}
+	case eventError:
+		// The quarantined files are reported on their own.
+		if _, ok := n.reasons[event.Source]; ok && event.Source != "" {
+			return nil
+		}
+		n.errors = append(n.errors, event.Message)
+	}
+	return nil
+}
+
+// take returns the collected errors, quarantined files, rejected files and
+// staged photos and starts over.
+func (n *importNotifications) take() ([]string, []string, []string, map[string]string, map[string]*stagedNotice) {
+	n.Lock()
+	defer n.Unlock()
+	errors, quarantined, rejected, reasons, staged := n.errors, n.quarantined, n.rejected, n.reasons, n.staged
+	n.errors, n.quarantined, n.rejected, n.reasons, n.staged = nil, nil, nil, make(map[string]string), make(map[string]*stagedNotice)
+	return errors, quarantined, rejected, reasons, staged
+}
+
+func validateNotifications(settings *notificationSettings) error {
+	for i, notifier := range settings.Notifiers {
+		name := notifierName(notifier, i)
+		for _, kind := range notifier.Notify {
+			if kind != notifyFailure && kind != notifyQuarantine && kind != notifyStaged && kind != notifySummary {
+				return fmt.Errorf("%s: unknown notification %s", name, kind)
+			}
+		}
+		if notifier.Ntfy == "" && notifier.Webhook == "" && notifier.Email == nil {
+			return fmt.Errorf("%s: set ntfy, a webhook or email", name)
+		}
+		if email := notifier.Email; email != nil && (email.SMTP == "" || email.From == "" || len(email.To) == 0) {
+			return fmt.Errorf("%s: email needs smtp, from and to", name)
+		}
+	}
+	if hour := settings.SummaryHour; hour != nil && (*hour < 0 || *hour > 23) {
+		return fmt.Errorf("notifications.summary_hour must be between 0 and 23")
+	}
+	return nil
+}
+
+func notifierName(notifier notifierSettings, i int) string {
+	if notifier.Name != "" {
+		return notifier.Name
+	}
+	return fmt.Sprintf("notifier %d", i+1)
+}
+
+func (n notifierSettings) wants(kind string) bool {
+	if len(n.Notify) == 0 {
+		return kind == notifyFailure || kind == notifyQuarantine
+	}
+	for _, wanted := range n.Notify {
+		if wanted == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyImport sends the notifications of a finished import: the failure,
+// the quarantined and rejected files, the staged photos and, once a day,
+// the summary.
+func notifyImport(settings *appSettings, imported map[string][]string) {
+	errors, quarantined, rejected, reasons, staged := currentNotifications.take()
+	config := settings.Notifications
+	if config == nil {
+		return
+	}
+
+	if len(errors) > 0 {
+		message := errors[0]
+		if len(errors) > 1 {
+			message = fmt.Sprintf("%d errors, the last one: %s", len(errors), errors[len(errors)-1])
+		}
+		sendNotification(config, notification{notifyFailure, "Diary import failed", message, "high", nil})
+	}
+	if len(quarantined) > 0 {
+		title := "Diary import quarantined a file"
+		if len(quarantined) > 1 {
+			title = fmt.Sprintf("Diary import quarantined %d files", len(quarantined))
+		}
+		sendNotification(config, notification{notifyQuarantine, title, fileReasons(quarantined, reasons), "default", nil})
+	}
+	if len(rejected) > 0 {
+		title := "Diary import rejected a file"
+		if len(rejected) > 1 {
+			title = fmt.Sprintf("Diary import rejected %d files", len(rejected))
+		}
+		sendNotification(config, notification{notifyQuarantine, title, fileReasons(rejected, reasons), "default", nil})
+	}
+	stagedDates := make([]string, 0, len(staged))
+	for date := range staged {
+		stagedDates = append(stagedDates, date)
+	}
+	sort.Strings(stagedDates)
+	for _, date := range stagedDates {
+		notice := staged[date]
+		noun := "photos are"
+		if notice.photos == 1 {
+			noun = "photo is"
+		}
+		message := fmt.Sprintf("%d %s staged for the settled note %s.", notice.photos, noun, date)
+		if notice.reason != "" {
+			message = fmt.Sprintf("%d %s staged for %s, %s.", notice.photos, noun, date, notice.reason)
+		}
+		sendNotification(config, notification{notifyStaged, "Diary import staged photos", message, "default", stagedActions(date, settings)})
+	}
+	if wantsSummary(config) {
+		if err := updateSummary(settings, imported); err != nil {
+			logWarnf("unable to update the daily summary: %s", err)
+		}
+	}
+}
+
+// stagedActions returns the approve and skip buttons of the staged photos
+// of the date, which need action_url and watch.api_token. Without them the
+// photos are approved with the approve command.
+func stagedActions(date string, settings *appSettings) []notificationAction {
+	token := watchConfig(settings).APIToken
+	if settings.Notifications.ActionURL == "" || token == "" {
+		return nil
+	}
+	base := strings.TrimRight(settings.Notifications.ActionURL, "/")
+	query := "?date=" + url.QueryEscape(date)
+	return []notificationAction{
+		{"Approve", base + "/staged/approve" + query, token},
+		{"Skip", base + "/staged/skip" + query, token},
+	}
+}
+
+func fileReasons(files []string, reasons map[string]string) string {
+	lines := make([]string, 0, len(files))
+	for _, file := range files {
+		lines = append(lines, fmt.Sprintf("%s: %s", path.Base(file), reasons[file]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func wantsSummary(config *notificationSettings) bool {
+	for _, notifier := range config.Notifiers {
+		if notifier.wants(notifySummary) {
+			return true
+		}
+	}
+	return false
+}
+
+func summaryPath(settings *appSettings) string {
+	if settings.Notifications.SummaryPath != "" {
+		return settings.Notifications.SummaryPath
+	}
+	return path.Join(path.Dir(instanceLockPath(settings)), ".diary-automation-summary.json")
+}
+
+// updateSummary counts the imported photos and sends the summary when it
+// is due. A day without photos sends no summary.
+func updateSummary(settings *appSettings, imported map[string][]string) error {
+	filePath := summaryPath(settings)
+	summary := pendingSummary{Photos: make(map[string]int)}
+	if data, err := os.ReadFile(filePath); err == nil {
+		if err := json.Unmarshal(data, &summary); err != nil {
+			return fmt.Errorf("unable to read %s: %v", filePath, err)
+		}
+		if summary.Photos == nil {
+			summary.Photos = make(map[string]int)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for date, photos := range imported {
+		summary.Photos[date] += len(photos)
+	}
+
+	hour := 21
+	if settings.Notifications.SummaryHour != nil {
+		hour = *settings.Notifications.SummaryHour
+	}
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	if now.Hour() >= hour && summary.LastSent != today {
+		if len(summary.Photos) > 0 {
+			sendNotification(settings.Notifications, notification{notifySummary, "Diary summary", summaryMessage(summary.Photos), "low", nil})
+		}
+		summary.LastSent = today
+		summary.Photos = make(map[string]int)
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
+
+// summaryMessage lists the photos per date, like "3 photos attached to
+// 2024-05-01".
+func summaryMessage(photos map[string]int) string {
+	dates := make([]string, 0, len(photos))
+	for date := range photos {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	lines := make([]string, 0, len(dates))
+	for _, date := range dates {
+		noun := "photos"
+		if photos[date] == 1 {
+			noun = "photo"
+		}
+		lines = append(lines, fmt.Sprintf("%d %s attached to %s", photos[date], noun, date))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sendNotification sends the notification with every notifier that wants
+// it. A notifier that fails is logged as a warning, so it doesn't notify
+// about itself.
+func sendNotification(config *notificationSettings, n notification) {
+	for i, notifier := range config.Notifiers {
+		if !notifier.wants(n.Kind) {
+			continue
+		}
+		if err := notifier.send(n); err != nil {
+			logWarnf("%s failed to send the %s notification: %s", notifierName(notifier, i), n.Kind, err)
+		}
+	}
+}
+
+func (n notifierSettings) send(message notification) error {
+	timeout := time.Duration(n.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	if n.Ntfy != "" {
+		request, err := http.NewRequest(http.MethodPost, n.Ntfy, strings.NewReader(message.Message))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Title", message.Title)
+		request.Header.Set("Priority", message.Priority)
+		request.Header.Set("Tags", message.Kind)
+		if len(message.Actions) > 0 {
+			request.Header.Set("Actions", ntfyActions(message.Actions))
+		}
+		if n.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+n.Token)
+		}
+		if err := sendRequest(client, request); err != nil {
+			return err
+		}
+	}
+	if n.Webhook != "" {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		request, err := http.NewRequest(http.MethodPost, n.Webhook, bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		if err := sendRequest(client, request); err != nil {
+			return err
+		}
+	}
+	if n.Email != nil {
+		return sendEmail(n.Email, message)
+	}
+	return nil
+}
+
+// ntfyActions returns the actions header of ntfy, with HTTP actions that
+// post to the URLs with the API token and clear the notification.
+func ntfyActions(actions []notificationAction) string {
+	headers := make([]string, 0, len(actions))
+	for _, action := range actions {
+		headers = append(headers, fmt.Sprintf("http, %s, %s, method=POST, headers.Authorization=Bearer %s, clear=true", action.Label, action.URL, action.token))
+	}
+	return strings.Join(headers, "; ")
+}
+
+func sendRequest(client *http.Client, request *http.Request) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", request.URL, response.Status)
+	}
+	return nil
+}
+
+func sendEmail(email *emailSettings, message notification) error {
+	var auth smtp.Auth
+	if email.Username != "" {
+		host := email.SMTP
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", email.Username, email.Password, host)
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		email.From, strings.Join(email.To, ", "), message.Title, strings.ReplaceAll(message.Message, "\n", "\r\n"))
+	return smtp.SendMail(email.SMTP, auth, email.From, email.To, []byte(body))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// obsidianURISettings configure announcing the note of the latest imported
+// date with an obsidian://open URI. The notify command gets a message and
+// the URI as its arguments, e.g. for a notification with an open action,
+// and the open command, like xdg-open, gets the URI alone.
+type obsidianURISettings struct {
+	Vault         string `yaml:"vault"`
+	VaultPath     string `yaml:"vault_path"`
+	NotifyCommand string `yaml:"notify_command"`
+	OpenCommand   string `yaml:"open_command"`
+}
+
+// announceImportedNote runs the configured commands with the URI of the
+// note of the latest imported date.
+func announceImportedNote(imported map[string][]string, settings *appSettings) {
+	dates := make([]string, 0, len(imported))
+	for date := range imported {
+		dates = append(dates, date)
+	}
+	if len(dates) == 0 {
+		return
+	}
+	sort.Strings(dates)
+	date := dates[len(dates)-1]
+
+	uri, err := obsidianURI(date, settings)
+	if err != nil {
+		logErrorf("unable to create Obsidian URI: %s", err)
+		return
+	}
+
+	config := settings.ObsidianURI
+	if config.NotifyCommand != "" {
+		message := fmt.Sprintf("Imported %d photos for %s", len(imported[date]), date)
+		if err := exec.Command(config.NotifyCommand, message, uri).Run(); err != nil {
+			logErrorf("unable to run notify command %s: %s", config.NotifyCommand, err)
+		}
+	}
+	if config.OpenCommand != "" {
+		if err := exec.Command(config.OpenCommand, uri).Run(); err != nil {
+			logErrorf("unable to run open command %s: %s", config.OpenCommand, err)
+		}
+	}
+}
+
+// obsidianURI returns the URI that opens the note of the date. The vault
+// path defaults to the note folder and the vault name to its base name.
+func obsidianURI(date string, settings *appSettings) (string, error) {
+	vaultPath := settings.ObsidianURI.VaultPath
+	if vaultPath == "" {
+		vaultPath = settings.ObsidianFilePath
+	}
+	vault := settings.ObsidianURI.Vault
+	if vault == "" {
+		vault = filepath.Base(vaultPath)
+	}
+
+	file, err := filepath.Rel(vaultPath, filepath.FromSlash(notePath(date, settings)))
+	if err != nil || strings.HasPrefix(file, "..") {
+		return "", fmt.Errorf("%s is not inside the vault %s", settings.ObsidianFilePath, vaultPath)
+	}
+	return fmt.Sprintf("obsidian://open?vault=%s&file=%s", obsidianQueryEscape(vault), obsidianQueryEscape(filepath.ToSlash(file))), nil
+}
+
+// obsidianQueryEscape escapes a query value with %20 for spaces, which
+// Obsidian doesn't read as +.
+func obsidianQueryEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
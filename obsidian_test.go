@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestObsidianURIEscapesQuery(t *testing.T) {
+	settings := testSettings(t, "obsidian_uri:\n  vault: Work & Life\n")
+	uri, err := obsidianURI("2024-05-01", settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "obsidian://open?vault=Work%20%26%20Life&file=2024-05-01.md"; uri != want {
+		t.Errorf("obsidianURI() = %s, want %s", uri, want)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// offloadSettings configure the archive that old attachments are moved to,
+// a local path or an S3 bucket. Their embeds are turned into links to the
+// archive, optionally next to a low resolution placeholder that stays in
+// the vault. The offloaded files are listed in offloaded.json in the target
+// photo path so they can be restored.
+type offloadSettings struct {
+	Path                 string      `yaml:"path"`
+	S3                   *s3Settings `yaml:"s3"`
+	MinAgeDays           int         `yaml:"min_age_days"`
+	Placeholders         bool        `yaml:"placeholders"`
+	PlaceholderDimension int         `yaml:"placeholder_dimension"`
+}
+
+// offloadedFile is an entry of the offload index.
+type offloadedFile struct {
+	Name        string   `json:"name"`
+	Original    string   `json:"original"`
+	Location    string   `json:"location"`
+	Placeholder string   `json:"placeholder,omitempty"`
+	Sidecars    []string `json:"sidecars,omitempty"`
+}
+
+const offloadIndexName = "offloaded.json"
+
+var attachmentLinkPattern = regexp.MustCompile(`!?\[\[([^\]|#]+)(\|[^\]]*)?\]\]`)
+
+// runOffload implements the offload command.
+func runOffload(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("offload", flag.ExitOnError)
+	days := flags.Int("days", 0, "Offload attachments older than this many days, min_age_days by default")
+	list := flags.Bool("list", false, "List the attachments that would be offloaded")
+	flags.Parse(args)
+
+	if settings.Offload == nil || (settings.Offload.Path == "" && settings.Offload.S3 == nil) {
+		log.Fatal("offload.path or offload.s3 is not set")
+	}
+	if *days <= 0 {
+		*days = settings.Offload.MinAgeDays
+	}
+	if *days <= 0 {
+		*days = 365
+	}
+
+	candidates, err := attachmentsOlderThan(*days, settings)
+	if err != nil {
+		log.Fatalf("unable to find attachments to offload: %s", err)
+	}
+	attachments := make([]string, 0)
+	for _, candidate := range candidates {
+		if *list {
+			fmt.Printf("%s\t%s\n", candidate.date, candidate.filePath)
+			continue
+		}
+		attachments = append(attachments, candidate.filePath)
+	}
+	if len(attachments) == 0 {
+		return
+	}
+
+	logInfof("offloading %d attachments older than %d days", len(attachments), *days)
+	if err := offloadAttachments(attachments, settings); err != nil {
+		log.Fatalf("unable to offload attachments: %s", err)
+	}
+	refreshManifests(attachments, settings)
+	if settings.LinkReport {
+		if err := reportAttachmentLinks("offloading", attachments, nil, settings); err != nil {
+			logErrorf("unable to check the links: %s", err)
+		}
+	}
+}
+
+// runRestore implements the restore command. Without arguments every
+// offloaded attachment is restored, otherwise the ones whose name or date
+// is given.
+func runRestore(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	list := flags.Bool("list", false, "List the offloaded attachments")
+	flags.Parse(args)
+
+	if settings.Offload == nil {
+		log.Fatal("offload is not configured")
+	}
+	index, err := readOffloadIndex(settings)
+	if err != nil {
+		log.Fatalf("unable to read the offload index: %s", err)
+	}
+
+	selected := make(map[string]bool)
+	for _, arg := range flags.Args() {
+		selected[arg] = true
+	}
+	prefixes := make([]string, 0)
+	for _, folder := range sourceFolders(settings) {
+		prefixes = append(prefixes, folder.ImagePrefix)
+	}
+
+	restored := make([]string, 0)
+	for _, name := range sortedOffloadNames(index) {
+		entry := index[name]
+		original := strings.TrimSuffix(strings.TrimPrefix(name, longestPrefix(name, prefixes)), encryptedExtension)
+		date, _ := getDateFromFile(original)
+		if len(selected) > 0 && !selected[name] && !selected[date] {
+			continue
+		}
+		if *list {
+			fmt.Printf("%s\t%s\n", name, entry.Location)
+			continue
+		}
+		if err := restoreAttachment(entry, settings); err != nil {
+			logErrorf("unable to restore %s: %s", name, err)
+			continue
+		}
+		delete(index, name)
+		restored = append(restored, entry.Original)
+	}
+	if len(restored) == 0 {
+		return
+	}
+
+	logInfof("restored %d attachments", len(restored))
+	if err := writeOffloadIndex(index, settings); err != nil {
+		log.Fatalf("unable to write the offload index: %s", err)
+	}
+	refreshManifests(restored, settings)
+	if settings.LinkReport {
+		if err := reportAttachmentLinks("restoring", restored, nil, settings); err != nil {
+			logErrorf("unable to check the links: %s", err)
+		}
+	}
+}
+
+// offloadAttachments moves the attachments, with their sidecars, to the
+// archive and rewrites the links to them.
+func offloadAttachments(attachments []string, settings *appSettings) error {
+	index, err := readOffloadIndex(settings)
+	if err != nil {
+		return err
+	}
+
+	links := make(map[string]string)
+	defer func() {
+		if len(links) > 0 {
+			if err := writeOffloadIndex(index, settings); err != nil {
+				logErrorf("unable to write the offload index: %s", err)
+			}
+		}
+	}()
+
+	// The links of the attachments that were archived are replaced even
+	// when a later one fails, as their files are gone from the vault.
+	var failed error
+	for _, attachment := range attachments {
+		name := normalizeName(path.Base(attachment))
+		if _, ok := index[name]; ok {
+			failed = fmt.Errorf("%s is already offloaded", name)
+			break
+		}
+
+		entry := &offloadedFile{Name: name, Original: attachment}
+		if settings.Offload.Placeholders && !strings.HasSuffix(name, encryptedExtension) {
+			placeholder, err := writePlaceholder(attachment, settings)
+			if err != nil {
+				logErrorf("unable to create a placeholder for %s: %s", name, err)
+			}
+			entry.Placeholder = placeholder
+		}
+
+		location, link, err := archiveFile(attachment, name, settings)
+		if err != nil {
+			if entry.Placeholder != "" {
+				os.Remove(entry.Placeholder)
+			}
+			failed = err
+			break
+		}
+		entry.Location = location
+		links[name] = fmt.Sprintf("[%s](%s)", name, link)
+		if entry.Placeholder != "" {
+			links[name] = fmt.Sprintf("![[%s]] %s", path.Base(entry.Placeholder), links[name])
+		}
+		index[name] = entry
+
+		for _, ext := range []string{".json", ".xmp"} {
+			if !fileExists(attachment + ext) {
+				continue
+			}
+			if _, _, err := archiveFile(attachment+ext, name+ext, settings); err != nil {
+				failed = err
+				break
+			}
+			entry.Sidecars = append(entry.Sidecars, ext)
+		}
+		if failed != nil {
+			break
+		}
+	}
+
+	if len(links) > 0 {
+		if err := replaceAttachmentLinks(links, settings); err != nil {
+			return err
+		}
+	}
+	return failed
+}
+
+// archiveFile moves the file to the archive and returns its location there
+// and the link to it.
+func archiveFile(filePath string, name string, settings *appSettings) (string, string, error) {
+	if s3 := settings.Offload.S3; s3 != nil {
+		key := s3.Prefix + name
+		if err := s3Upload(s3, filePath, key); err != nil {
+			return "", "", err
+		}
+		if err := os.Remove(filePath); err != nil {
+			return "", "", fmt.Errorf("unable to delete %s: %v", filePath, err)
+		}
+		return key, "<" + s3ObjectURL(s3, key) + ">", nil
+	}
+
+	if err := os.MkdirAll(settings.Offload.Path, 0755); err != nil {
+		return "", "", fmt.Errorf("unable to create %s: %v", settings.Offload.Path, err)
+	}
+	target := path.Join(settings.Offload.Path, name)
+	if fileExists(target) {
+		return "", "", fmt.Errorf("%s is already in the archive", name)
+	}
+	if err := moveFile(filePath, target); err != nil {
+		return "", "", err
+	}
+	return target, fileURL(target), nil
+}
+
+// retrieveFile moves the file at the location in the archive back to the
+// target.
+func retrieveFile(location string, target string, settings *appSettings) error {
+	if s3 := settings.Offload.S3; s3 != nil {
+		if err := s3Download(s3, location, target); err != nil {
+			return err
+		}
+		return s3Delete(s3, location)
+	}
+	return moveFile(location, target)
+}
+
+// writePlaceholder stores a low resolution copy of the attachment next to
+// it and returns its path.
+func writePlaceholder(attachment string, settings *appSettings) (string, error) {
+	ext := path.Ext(attachment)
+	placeholder := strings.TrimSuffix(attachment, ext) + ".lowres" + ext
+	if err := copyFile(attachment, placeholder); err != nil {
+		return "", err
+	}
+	dimension := settings.Offload.PlaceholderDimension
+	if dimension <= 0 {
+		dimension = 480
+	}
+	if _, err := downscaleAttachment(placeholder, dimension, settings); err != nil {
+		os.Remove(placeholder)
+		return "", err
+	}
+	return placeholder, nil
+}
+
+// restoreAttachment moves an offloaded attachment and its sidecars back,
+// removes its placeholder and embeds it again.
+func restoreAttachment(entry *offloadedFile, settings *appSettings) error {
+	if fileExists(entry.Original) {
+		return fmt.Errorf("%s already exists", entry.Original)
+	}
+	if err := retrieveFile(entry.Location, entry.Original, settings); err != nil {
+		return err
+	}
+	for _, ext := range entry.Sidecars {
+		if err := retrieveFile(entry.Location+ext, entry.Original+ext, settings); err != nil {
+			return err
+		}
+	}
+	if entry.Placeholder != "" {
+		if err := os.Remove(entry.Placeholder); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to delete %s: %v", entry.Placeholder, err)
+		}
+	}
+
+	placeholder := ""
+	if entry.Placeholder != "" {
+		placeholder = `(!\[\[` + regexp.QuoteMeta(path.Base(entry.Placeholder)) + `\]\] )?`
+	}
+	link := regexp.MustCompile(placeholder + `\[` + regexp.QuoteMeta(entry.Name) + `\]\(<[^>]*>\)`)
+	return editNotes(settings, func(note []byte) []byte {
+		return link.ReplaceAll(note, []byte("![["+entry.Name+"]]"))
+	})
+}
+
+// replaceAttachmentLinks replaces the wiki links and embeds of the given
+// attachments in every note.
+func replaceAttachmentLinks(links map[string]string, settings *appSettings) error {
+	return editNotes(settings, func(note []byte) []byte {
+		return attachmentLinkPattern.ReplaceAllFunc(note, func(link []byte) []byte {
+			match := attachmentLinkPattern.FindSubmatch(link)
+			if replacement, ok := links[normalizeName(string(match[1]))]; ok {
+				return []byte(replacement)
+			}
+			return link
+		})
+	})
+}
+
+// editNotes applies the edit to every note and rewrites the notes it
+// changes.
+func editNotes(settings *appSettings, edit func(note []byte) []byte) error {
+	notes, err := noteFiles(settings)
+	if err != nil {
+		return err
+	}
+
+	for _, note := range notes {
+		notePath := path.Join(settings.ObsidianFilePath, note)
+		data, err := os.ReadFile(notePath)
+		if err != nil {
+			return fmt.Errorf("unable to read note %s: %v", note, err)
+		}
+		if string(edit(data)) == string(data) {
+			continue
+		}
+		if err := rewriteNote(notePath, settings, edit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readOffloadIndex(settings *appSettings) (map[string]*offloadedFile, error) {
+	index := make(map[string]*offloadedFile)
+	data, err := os.ReadFile(path.Join(settings.TargetPhotoPath, offloadIndexName))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", offloadIndexName, err)
+	}
+
+	var entries []*offloadedFile
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", offloadIndexName, err)
+	}
+	for _, entry := range entries {
+		index[entry.Name] = entry
+	}
+	return index, nil
+}
+
+func writeOffloadIndex(index map[string]*offloadedFile, settings *appSettings) error {
+	entries := make([]*offloadedFile, 0, len(index))
+	for _, name := range sortedOffloadNames(index) {
+		entries = append(entries, index[name])
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", offloadIndexName, err)
+	}
+	return os.WriteFile(path.Join(settings.TargetPhotoPath, offloadIndexName), append(data, '\n'), 0644)
+}
+
+func sortedOffloadNames(index map[string]*offloadedFile) []string {
+	names := make([]string, 0, len(index))
+	for name := range index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// refreshManifests updates the checksum manifests of the source folders
+// the given attachments belong to.
+func refreshManifests(attachments []string, settings *appSettings) {
+	for _, folder := range sourceFolders(settings) {
+		if !folder.ChecksumManifest {
+			continue
+		}
+		files := make([]string, 0)
+		for _, attachment := range attachments {
+			if path.Dir(attachment) == path.Clean(folder.TargetPhotoPath) {
+				files = append(files, attachment)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+		if err := updateManifest(files, folder); err != nil {
+			logErrorf("unable to update the checksum manifest: %s", err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+var lastNoteWrite time.Time
+
+// paceNoteWrite waits until the configured interval has passed since the
+// previous note write of this run.
+func paceNoteWrite(settings *appSettings) {
+	interval := time.Duration(settings.NoteWriteIntervalSeconds) * time.Second
+	if interval <= 0 {
+		return
+	}
+	if wait := time.Until(lastNoteWrite.Add(interval)); wait > 0 {
+		logDebugf("waiting %s before the next note write", wait.Round(time.Second))
+		time.Sleep(wait)
+	}
+	lastNoteWrite = time.Now()
+}
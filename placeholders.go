@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+// placeholderNoteSettings configure creating the notes of days without
+// photos once the day is over, so the diary has no gaps. The notes are
+// marked with `photo: none` in their front matter until photos arrive.
+// Days sets how many days back, including today, are checked.
+type placeholderNoteSettings struct {
+	AfterHour int `yaml:"after_hour"`
+	Days      int `yaml:"days"`
+}
+
+const placeholderMarker = "photo: none"
+
+// createPlaceholderNotes creates a note for each checked day that has none.
+func createPlaceholderNotes(settings *appSettings) {
+	now := time.Now()
+	days := settings.PlaceholderNotes.Days
+	if days <= 0 {
+		days = 1
+	}
+	afterHour := settings.PlaceholderNotes.AfterHour
+	if afterHour <= 0 {
+		afterHour = 23
+	}
+
+	for i := 0; i < days; i++ {
+		if i == 0 && now.Hour() < afterHour {
+			continue
+		}
+		date := now.AddDate(0, 0, -i).Format("2006-01-02")
+		diaryFilePath := notePath(date, settings)
+		if fileExists(diaryFilePath) {
+			continue
+		}
+
+		logFields{"note": diaryFilePath, "date": date}.infof("creating a placeholder note for %s", date)
+		// The empty photo section marks the spot for the photos that
+		// arrive later.
+		body := dateRuleSection(date, settings) + eventSection(date, settings) + labelsForNote(settings).section + "\n" + habitSection(settings)
+		if err := createNoteFolder(diaryFilePath, settings); err != nil {
+			logErrorf("unable to create a placeholder note for %s: %s", date, err)
+			continue
+		}
+		content := addFrontMatter(strings.TrimRight(newNote(date, body, settings), "\n")+"\n", placeholderMarker)
+		if err := appendToNote(diaryFilePath, formatForNote(diaryFilePath, content, settings), settings); err != nil {
+			logErrorf("unable to create a placeholder note for %s: %s", date, err)
+		}
+	}
+}
+
+// removePlaceholderMarker marks the note of a day as having photos again.
+func removePlaceholderMarker(diaryFilePath string, settings *appSettings) {
+	data, err := os.ReadFile(diaryFilePath)
+	if err != nil || string(clearPlaceholderMarker(data)) == string(data) {
+		return
+	}
+	if err := rewriteNote(diaryFilePath, settings, clearPlaceholderMarker); err != nil {
+		logFields{"note": diaryFilePath}.errorf("unable to remove the placeholder marker: %s", err)
+	}
+}
+
+// clearPlaceholderMarker removes the placeholder marker from the front
+// matter of the note, and the front matter itself if nothing else is left
+// in it.
+func clearPlaceholderMarker(note []byte) []byte {
+	text := string(note)
+	newline := "\n"
+	if strings.HasPrefix(text, "---\r\n") {
+		newline = "\r\n"
+	}
+	start := "---" + newline
+	if !strings.HasPrefix(text, start) {
+		return note
+	}
+	end := strings.Index(text[len(start):], newline+"---"+newline)
+	if end < 0 {
+		return note
+	}
+	frontMatter := strings.Split(text[len(start):len(start)+end], newline)
+	rest := text[len(start)+end+len(newline+"---"+newline):]
+
+	kept := make([]string, 0, len(frontMatter))
+	for _, line := range frontMatter {
+		if strings.TrimSpace(line) != placeholderMarker {
+			kept = append(kept, line)
+		}
+	}
+	if len(kept) == len(frontMatter) {
+		return note
+	}
+	if len(kept) == 0 {
+		return []byte(rest)
+	}
+	return []byte(start + strings.Join(kept, newline) + newline + "---" + newline + rest)
+}
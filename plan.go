@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"text/tabwriter"
+)
+
+// plannedFile describes what an import would do with a file in the source
+// folder.
+type plannedFile struct {
+	Name    string `json:"name"`
+	Matched bool   `json:"matched"`
+	Date    string `json:"date,omitempty"`
+	Target  string `json:"target,omitempty"`
+	Action  string `json:"action"`
+}
+
+// planSourceFolder works out how every file in the original photo path
+// would be dated and renamed without touching anything.
+func planSourceFolder(settings *appSettings) ([]plannedFile, error) {
+	files, err := os.ReadDir(settings.OriginalPhotoPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read path %s: %v", settings.OriginalPhotoPath, err)
+	}
+
+	reserved := make(map[string]bool)
+	result := make([]plannedFile, 0)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		planned := plannedFile{Name: file.Name(), Action: "skip"}
+		if isSkippedEntry(file, settings) {
+			result = append(result, planned)
+			continue
+		}
+		name := file.Name()
+		if !isDiaryPhoto(name, settings) {
+			name, err = normalizedName(file.Name(), settings, reserved)
+			if err != nil {
+				return nil, err
+			}
+			if name == "" {
+				result = append(result, planned)
+				continue
+			}
+			planned.Action = "rename"
+		} else {
+			planned.Action = "import"
+		}
+		reserved[name] = true
+
+		planned.Matched = true
+		planned.Date, _ = getDateFromFile(name)
+		planned.Target = targetPath(path.Join(settings.OriginalPhotoPath, file.Name()), settings)
+		planned.Target = path.Join(path.Dir(planned.Target), targetName(name, settings))
+		if settings.DateGuard != nil && !isPlausibleDate(planned.Date, settings.DateGuard) {
+			planned.Action = "reject"
+			planned.Target = ""
+		}
+		result = append(result, planned)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func markPlannedAction(planned []plannedFile, name string, action string) {
+	for i := range planned {
+		if planned[i].Name == name {
+			planned[i].Action = action
+		}
+	}
+}
+
+func printJSON(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		log.Fatalf("unable to write JSON output: %s", err)
+	}
+}
+
+// runPreviewNames implements the preview-names command, which prints the
+// planned date and target of every pending file.
+func runPreviewNames(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("preview-names", flag.ExitOnError)
+	source := flags.String("source", settings.OriginalPhotoPath, "Source folder")
+	outputFormat := flags.String("output", "text", "Output format, text or json")
+	flags.Parse(args)
+
+	planned, err := planSourceFolder(folderForInbox(*source, settings))
+	if err != nil {
+		log.Fatalf("unable to preview names: %s", err)
+	}
+
+	if *outputFormat == "json" {
+		printJSON(planned)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tDATE\tACTION\tTARGET")
+	for _, file := range planned {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", file.Name, file.Date, file.Action, file.Target)
+	}
+	w.Flush()
+}
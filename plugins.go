@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// pluginSettings configure an external program that extends the import.
+// The program gets one JSON request on stdin and answers with one JSON
+// response on stdout. Source plugins return photos to import, transform
+// plugins get each scanned photo and may change it in place, change its
+// caption or skip it, and enricher plugins return Markdown added after the
+// photo section. Transform and enricher plugins can also be WASI modules,
+// which run sandboxed and only see the request.
+type pluginSettings struct {
+	Name           string   `yaml:"name"`
+	Kind           string   `yaml:"kind"`
+	Command        []string `yaml:"command"`
+	WASM           string   `yaml:"wasm"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+type pluginRequest struct {
+	Kind    string   `json:"kind"`
+	Date    string   `json:"date,omitempty"`
+	Photo   string   `json:"photo,omitempty"`
+	Caption string   `json:"caption,omitempty"`
+	Photos  []string `json:"photos,omitempty"`
+	Source  string   `json:"source,omitempty"`
+}
+
+type pluginPhoto struct {
+	Path string    `json:"path"`
+	Time time.Time `json:"time"`
+}
+
+type pluginResponse struct {
+	Error    string        `json:"error"`
+	Photos   []pluginPhoto `json:"photos"`
+	Skip     bool          `json:"skip"`
+	Caption  string        `json:"caption"`
+	Markdown string        `json:"markdown"`
+}
+
+// runPlugin sends the request to the plugin and returns its response.
+func runPlugin(plugin pluginSettings, request pluginRequest) (*pluginResponse, error) {
+	if len(plugin.Command) == 0 && plugin.WASM == "" {
+		return nil, fmt.Errorf("plugin %s has no command", plugin.Name)
+	}
+	timeout := time.Duration(plugin.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	input, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal plugin request: %v", err)
+	}
+	var output []byte
+	if plugin.WASM != "" {
+		if output, err = runWASMPlugin(ctx, plugin, input); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd := exec.CommandContext(ctx, plugin.Command[0], plugin.Command[1:]...)
+		cmd.Stdin = bytes.NewReader(input)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if output, err = cmd.Output(); err != nil {
+			return nil, fmt.Errorf("plugin %s failed: %v: %s", plugin.Name, err, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	var response pluginResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the response of plugin %s: %v", plugin.Name, err)
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("plugin %s: %s", plugin.Name, response.Error)
+	}
+	return &response, nil
+}
+
+// pluginSources returns the source plugins as photo sources.
+func pluginSources(settings *appSettings) []photoSource {
+	sources := make([]photoSource, 0)
+	for _, plugin := range settings.Plugins {
+		if plugin.Kind != "source" {
+			continue
+		}
+		plugin := plugin
+		sources = append(sources, photoSource{"plugin " + plugin.Name, plugin.Inbox, func(settings *appSettings) (int, error) {
+			return importPluginPhotos(plugin, settings)
+		}})
+	}
+	return sources
+}
+
+// importPluginPhotos copies the photos a source plugin returns into the
+// original photo path, named by their time.
+func importPluginPhotos(plugin pluginSettings, settings *appSettings) (int, error) {
+	response, err := runPlugin(plugin, pluginRequest{Kind: "source", Source: sourceName(settings)})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, photo := range response.Photos {
+		ext := photoExtension(photo.Path)
+		if ext == "" {
+			logInfof("skipped %s from plugin %s, it is not a supported photo", photo.Path, plugin.Name)
+			continue
+		}
+		name, err := freePhotoName(photo.Time.Local(), ext, settings)
+		if err != nil {
+			return count, err
+		}
+		if err := copyFile(photo.Path, path.Join(settings.OriginalPhotoPath, name)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// transformPhotos passes every scanned photo to the transform plugins,
+// keeps the captions they return and leaves out the photos a plugin skips.
+func transformPhotos(photos map[string][]string, scan *folderScan) map[string][]string {
+	settings := scan.settings
+	scan.captions = make(map[string]string)
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+	photo:
+		for _, photo := range datePhotos {
+			for _, plugin := range settings.Plugins {
+				if plugin.Kind != "transform" {
+					continue
+				}
+				request := pluginRequest{Kind: "transform", Date: date, Photo: photo, Caption: photoCaption(photo, scan), Source: sourceName(settings)}
+				start := time.Now()
+				response, err := runPlugin(plugin, request)
+				timeFileStage(photo, "transform", start)
+				if err != nil {
+					logFields{"source": photo}.errorf("unable to transform %s: %s", photo, err)
+					continue
+				}
+				if response.Skip {
+					logFields{"source": photo}.infof("skipped %s, plugin %s left it out", photo, plugin.Name)
+					continue photo
+				}
+				if response.Caption != "" {
+					scan.captions[photo] = cleanCaption(response.Caption)
+				}
+			}
+			result[date] = append(result[date], photo)
+		}
+	}
+	return result
+}
+
+// enricherSection returns the Markdown the enricher plugins add for the
+// photos of the date.
+func enricherSection(date string, photos []notePhoto, settings *appSettings) string {
+	names := make([]string, 0, len(photos))
+	for _, photo := range photos {
+		names = append(names, targetName(path.Base(photo.path), photo.settings))
+	}
+
+	section := ""
+	for _, plugin := range settings.Plugins {
+		if plugin.Kind != "enricher" {
+			continue
+		}
+		response, err := runPlugin(plugin, pluginRequest{Kind: "enricher", Date: date, Photos: names})
+		if err != nil {
+			logErrorf("unable to enrich %s: %s", date, err)
+			continue
+		}
+		if markdown := strings.TrimSpace(response.Markdown); markdown != "" {
+			section = section + "\n" + markdown + "\n"
+		}
+	}
+	return section
+}
+
+// photoCaption returns the caption a transform plugin gave the photo or
+// the caption from its sidecar.
+func photoCaption(photo string, scan *folderScan) string {
+	if caption, ok := scan.captions[photo]; ok {
+		return caption
+	}
+	return xmpCaption(photo, scan.sidecars, scan.settings)
+}
+
+func hasPlugin(settings *appSettings, kind string) bool {
+	for _, plugin := range settings.Plugins {
+		if plugin.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
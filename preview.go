@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	previewDatePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	embedPattern       = regexp.MustCompile(`!\[\[([^\]|]+)(\|[^\]]*)?\]\]`)
+	wikiLinkPattern    = regexp.MustCompile(`\[\[([^\]|]+)(\|([^\]]*))?\]\]`)
+	boldPattern        = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	italicPattern      = regexp.MustCompile(`\*([^*]+)\*`)
+)
+
+const previewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%s</title>
+<style>body{font-family:sans-serif;max-width:40em;margin:auto;padding:1em}img{max-width:100%%}</style>
+</head>
+<body>
+%s</body>
+</html>
+`
+
+// runPreview implements the preview command, a read-only web server that
+// renders a single daily note at /preview/YYYY-MM-DD.
+func runPreview(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("preview", flag.ExitOnError)
+	listen := flags.String("listen", "localhost:8080", "Listen address")
+	flags.Parse(args)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/preview/", func(w http.ResponseWriter, r *http.Request) {
+		servePreview(w, r, settings)
+	})
+	mux.HandleFunc("/attachments/", func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		for _, folder := range sourceFolders(settings) {
+			if filePath, ok := findFile(folder.TargetPhotoPath, name); ok {
+				http.ServeFile(w, r, filePath)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+
+	logInfof("serving note previews on http://%s/preview/", *listen)
+	log.Fatal(http.ListenAndServe(*listen, mux))
+}
+
+func servePreview(w http.ResponseWriter, r *http.Request, settings *appSettings) {
+	date := strings.TrimPrefix(r.URL.Path, "/preview/")
+	if !previewDatePattern.MatchString(date) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := os.ReadFile(notePath(date, settings))
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "unable to read note", http.StatusInternalServerError)
+		logErrorf("unable to read note for %s: %s", date, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, previewPage, date, renderMarkdown(string(data)))
+}
+
+// renderMarkdown renders the subset of Markdown that daily notes use:
+// headings, lists, tasks, paragraphs, emphasis and Obsidian embeds. The
+// frontmatter is left out.
+func renderMarkdown(markdown string) string {
+	var out strings.Builder
+	inList := false
+	inFrontmatter := false
+	paragraph := make([]string, 0)
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + strings.Join(paragraph, "<br>\n") + "</p>\n")
+			paragraph = paragraph[:0]
+		}
+	}
+	closeList := func() {
+		if inList {
+			out.WriteString("</ul>\n")
+			inList = false
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(markdown))
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if first && line == "---" {
+			inFrontmatter = true
+			first = false
+			continue
+		}
+		first = false
+		if inFrontmatter {
+			inFrontmatter = line != "---"
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flushParagraph()
+			closeList()
+		case strings.HasPrefix(trimmed, "#"):
+			flushParagraph()
+			closeList()
+			level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+			if level > 6 {
+				level = 6
+			}
+			fmt.Fprintf(&out, "<h%d>%s</h%d>\n", level, renderInline(strings.TrimSpace(trimmed[level:])), level)
+		case strings.HasPrefix(trimmed, "- ") || strings.HasPrefix(trimmed, "* "):
+			flushParagraph()
+			if !inList {
+				out.WriteString("<ul>\n")
+				inList = true
+			}
+			out.WriteString("<li>" + renderListItem(trimmed[2:]) + "</li>\n")
+		default:
+			closeList()
+			paragraph = append(paragraph, renderInline(trimmed))
+		}
+	}
+	flushParagraph()
+	closeList()
+
+	return out.String()
+}
+
+func renderListItem(item string) string {
+	switch {
+	case strings.HasPrefix(item, "[ ] "):
+		return `<input type="checkbox" disabled> ` + renderInline(item[4:])
+	case strings.HasPrefix(item, "[x] "), strings.HasPrefix(item, "[X] "):
+		return `<input type="checkbox" checked disabled> ` + renderInline(item[4:])
+	}
+	return renderInline(item)
+}
+
+func renderInline(text string) string {
+	text = html.EscapeString(text)
+	text = embedPattern.ReplaceAllStringFunc(text, func(embed string) string {
+		name := embedPattern.FindStringSubmatch(embed)[1]
+		src := url.PathEscape(path.Base(html.UnescapeString(name)))
+		return fmt.Sprintf(`<img src="/attachments/%s" alt="%s">`, html.EscapeString(src), name)
+	})
+	text = wikiLinkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		match := wikiLinkPattern.FindStringSubmatch(link)
+		if match[3] != "" {
+			return match[3]
+		}
+		return match[1]
+	})
+	text = boldPattern.ReplaceAllString(text, "<strong>$1</strong>")
+	text = italicPattern.ReplaceAllString(text, "<em>$1</em>")
+	return text
+}
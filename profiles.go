@@ -0,0 +1,181 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// applyProfile overlays the settings of the named profile, or of the
+// profile the settings file selects, on the top-level settings. Settings
+// the profile leaves out keep their top-level values.
+func applyProfile(settings *appSettings, name string) error {
+	if name == "" {
+		name = settings.Profile
+	}
+	if name == "" {
+		return nil
+	}
+	node, ok := settings.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %s", name)
+	}
+	profiles := settings.Profiles
+	if err := node.Decode(settings); err != nil {
+		return fmt.Errorf("invalid profile %s: %v", name, err)
+	}
+	settings.Profile = name
+	settings.Profiles = profiles
+	return nil
+}
+
+func profileNames(settings *appSettings) []string {
+	names := make([]string, 0, len(settings.Profiles))
+	for name := range settings.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profileAPI lets the watch command switch profiles while it runs. The
+// switched settings are read from the settings file and handed to the
+// watch loop, which uses them from the next import on.
+type profileAPI struct {
+	mu       sync.Mutex
+	settings *appSettings
+	switched chan *appSettings
+	uploaded chan struct{}
+}
+
+type profileState struct {
+	Profile  string   `json:"profile"`
+	Profiles []string `json:"profiles"`
+}
+
+// serveProfileAPI serves GET /profile, which returns the active profile,
+// and POST /profile with {"profile": "travel"} and the API token, which
+// switches to another.
+// GET /healthz, GET /status and GET /metrics are for liveness checks and
+// monitoring, GET /debug/state with the API token for debugging, POST
+// /upload takes photos for the import and POST /staged/approve and
+// /staged/skip with the API token resolve the staged photos.
+func serveProfileAPI(listen string, settings *appSettings) *profileAPI {
+	api := &profileAPI{settings: settings, switched: make(chan *appSettings, 1), uploaded: make(chan struct{}, 1)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/profile", api.handle)
+	mux.HandleFunc("/upload", api.handleUpload)
+	mux.HandleFunc("/staged/", api.handleStaged)
+	mux.HandleFunc("/healthz", serveHealth)
+	mux.HandleFunc("/status", serveStatus)
+	mux.HandleFunc("/metrics", serveMetrics)
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		if api.authorized(w, r, func(config watchSettings) string { return config.APIToken }) {
+			serveStateDump(w, r)
+		}
+	})
+	go func() {
+		logInfof("serving the watch API on %s", listen)
+		if err := http.ListenAndServe(listen, mux); err != nil {
+			logErrorf("unable to serve the watch API: %s", err)
+		}
+	}()
+	return api
+}
+
+func (api *profileAPI) handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !api.authorized(w, r, func(config watchSettings) string { return config.APIToken }) {
+			return
+		}
+		var request profileState
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+			return
+		}
+		api.mu.Lock()
+		settingsFile := api.settings.settingsFile
+		api.mu.Unlock()
+		switched, err := readSettings(settingsFile, request.Profile)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("unable to switch the profile: %s", err), http.StatusBadRequest)
+			return
+		}
+		// A switch waiting for the watch loop, which picks it up after the
+		// running import, is replaced by the newer one.
+		api.mu.Lock()
+		api.settings = switched
+		select {
+		case <-api.switched:
+		default:
+		}
+		api.switched <- switched
+		api.mu.Unlock()
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.mu.Lock()
+	state := profileState{Profile: api.settings.Profile, Profiles: profileNames(api.settings)}
+	api.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+// authorized checks the token of the request, a bearer token or ?token=,
+// against the one of the settings. Without a token in the settings the
+// endpoint is not served.
+func (api *profileAPI) authorized(w http.ResponseWriter, r *http.Request, token func(watchSettings) string) bool {
+	api.mu.Lock()
+	expected := token(watchConfig(api.settings))
+	api.mu.Unlock()
+	if expected == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	given := r.URL.Query().Get("token")
+	if bearer := r.Header.Get("Authorization"); strings.HasPrefix(bearer, "Bearer ") {
+		given = strings.TrimPrefix(bearer, "Bearer ")
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// profileSwitches returns the channel of switched settings, which never
+// delivers when the API is not served.
+func (api *profileAPI) profileSwitches() <-chan *appSettings {
+	if api == nil {
+		return nil
+	}
+	return api.switched
+}
+
+// uploads returns the channel that delivers when photos were uploaded,
+// which never delivers when the API is not served.
+func (api *profileAPI) uploads() <-chan struct{} {
+	if api == nil {
+		return nil
+	}
+	return api.uploaded
+}
+
+// use makes the API report and switch from the given settings.
+func (api *profileAPI) use(settings *appSettings) {
+	if api == nil {
+		return
+	}
+	api.mu.Lock()
+	api.settings = settings
+	api.mu.Unlock()
+}
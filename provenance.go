@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// provenanceSettings tag the edits of the import so they can be told apart
+// from what was written by hand: Line is a template for a line added after
+// the photos of each import and Frontmatter a property set to the time of
+// the last import.
+type provenanceSettings struct {
+	Line        string `yaml:"line"`
+	Frontmatter string `yaml:"frontmatter"`
+}
+
+// provenanceData is the data the provenance line is executed with.
+type provenanceData struct {
+	Time      string
+	Date      string
+	Timestamp string
+	Photos    int
+}
+
+const (
+	provenanceSentinel       = "\x00"
+	provenancePhotosSentinel = 740191
+)
+
+func parseProvenanceLine(text string) (*template.Template, error) {
+	return template.New("provenance_line").Funcs(sectionTemplateFuncs).Parse(text)
+}
+
+func validateProvenance(provenance *provenanceSettings) error {
+	if provenance.Line != "" {
+		line, err := renderProvenanceLine(provenance.Line, provenanceData{Time: "00:00", Date: "2006-01-02", Timestamp: "2006-01-02T00:00:00Z", Photos: 1})
+		if err != nil {
+			return fmt.Errorf("invalid line: %v", err)
+		}
+		if strings.Contains(line, "\n") {
+			return fmt.Errorf("line must be a single line")
+		}
+	}
+	if strings.ContainsAny(provenance.Frontmatter, ":\n") {
+		return fmt.Errorf("invalid frontmatter property %q", provenance.Frontmatter)
+	}
+	return nil
+}
+
+func renderProvenanceLine(text string, data provenanceData) (string, error) {
+	tmpl, err := parseProvenanceLine(text)
+	if err != nil {
+		return "", err
+	}
+	var builder strings.Builder
+	if err := tmpl.Execute(&builder, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(builder.String()), nil
+}
+
+// provenanceLine returns the line added after the photos of an import, or
+// nothing without provenance.line.
+func provenanceLine(photos int, settings *appSettings) string {
+	if settings.Provenance == nil || settings.Provenance.Line == "" {
+		return ""
+	}
+	now := time.Now()
+	line, err := renderProvenanceLine(settings.Provenance.Line, provenanceData{
+		Time:      now.Format("15:04"),
+		Date:      now.Format("2006-01-02"),
+		Timestamp: now.Format(time.RFC3339),
+		Photos:    photos,
+	})
+	if err != nil {
+		logWarnf("unable to render the provenance line: %s", err)
+		return ""
+	}
+	return line + "\n"
+}
+
+// provenancePattern returns a pattern matching the provenance lines of
+// earlier imports, or nil without provenance.line.
+func provenancePattern(settings *appSettings) *regexp.Regexp {
+	if settings.Provenance == nil || settings.Provenance.Line == "" {
+		return nil
+	}
+	line, err := renderProvenanceLine(settings.Provenance.Line, provenanceData{
+		Time:      provenanceSentinel,
+		Date:      provenanceSentinel,
+		Timestamp: provenanceSentinel,
+		Photos:    provenancePhotosSentinel,
+	})
+	if err != nil || line == "" {
+		return nil
+	}
+	pattern := regexp.QuoteMeta(line)
+	pattern = strings.ReplaceAll(pattern, provenanceSentinel, `.+?`)
+	pattern = strings.ReplaceAll(pattern, strconv.Itoa(provenancePhotosSentinel), `\d+`)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+// stampLastImport sets the frontmatter property of provenance.frontmatter
+// to the current time, adding the frontmatter if the note has none. Empty
+// notes are left as they are.
+func stampLastImport(note string, settings *appSettings) string {
+	if settings.Provenance == nil || settings.Provenance.Frontmatter == "" || note == "" {
+		return note
+	}
+	newline := "\n"
+	if strings.HasPrefix(note, "---\r\n") || !strings.HasPrefix(note, "---\n") && strings.Contains(note, "\r\n") {
+		newline = "\r\n"
+	}
+	key := settings.Provenance.Frontmatter
+	line := key + ": " + time.Now().Format("2006-01-02T15:04")
+
+	start := "---" + newline
+	if !strings.HasPrefix(note, start) {
+		return start + line + newline + "---" + newline + note
+	}
+	end := strings.Index(note[len(start)-len(newline):], newline+"---"+newline)
+	if end < 0 {
+		return note
+	}
+	end += len(start) - len(newline)
+	var lines []string
+	if end >= len(start) {
+		lines = strings.Split(note[len(start):end], newline)
+	}
+	for i, existing := range lines {
+		if strings.HasPrefix(existing, key+":") {
+			lines[i] = line
+			return start + strings.Join(lines, newline) + note[end:]
+		}
+	}
+	lines = append(lines, line)
+	return start + strings.Join(lines, newline) + note[end:]
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// quotaSettings configure keeping the target photo path under max_mb. When
+// it grows over the quota, attachments older than min_age_days are pruned,
+// oldest first, by offloading them to the offload path or by downscaling
+// them to max_dimension pixels.
+type quotaSettings struct {
+	MaxMB        int64  `yaml:"max_mb"`
+	MinAgeDays   int    `yaml:"min_age_days"`
+	Policy       string `yaml:"policy"`
+	MaxDimension int    `yaml:"max_dimension"`
+}
+
+type agedAttachment struct {
+	filePath string
+	date     string
+	size     int64
+}
+
+// enforceQuota prunes attachments until the target photo path fits the
+// quota or there is nothing old enough left to prune. It returns the pruned
+// attachments.
+func enforceQuota(settings *appSettings) ([]string, error) {
+	quota := settings.Quota
+	limit := quota.MaxMB * 1024 * 1024
+	used, err := dirSize(settings.TargetPhotoPath)
+	if err != nil {
+		return nil, err
+	}
+	if used <= limit {
+		return nil, nil
+	}
+	if quota.Policy != "downscale" && settings.Offload == nil {
+		return nil, fmt.Errorf("the offload policy needs the offload settings")
+	}
+
+	candidates, err := attachmentsOlderThan(quota.MinAgeDays, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	offloaded := make([]string, 0)
+	changed := make([]string, 0)
+	for _, candidate := range candidates {
+		if used <= limit {
+			break
+		}
+		if quota.Policy == "downscale" {
+			if strings.HasSuffix(candidate.filePath, encryptedExtension) {
+				continue
+			}
+			saved, err := downscaleAttachment(candidate.filePath, quota.MaxDimension, settings)
+			if err != nil {
+				logErrorf("unable to downscale %s: %s", candidate.filePath, err)
+				continue
+			}
+			used -= saved
+		} else {
+			offloaded = append(offloaded, candidate.filePath)
+			used -= candidate.size
+		}
+		changed = append(changed, candidate.filePath)
+	}
+
+	if len(offloaded) > 0 {
+		logInfof("offloading %d attachments to stay within the quota", len(offloaded))
+		if err := offloadAttachments(offloaded, settings); err != nil {
+			return nil, err
+		}
+	}
+	refreshManifests(changed, settings)
+	if used > limit {
+		return changed, fmt.Errorf("%s is still over the quota, nothing older than %d days is left to prune", settings.TargetPhotoPath, quota.MinAgeDays)
+	}
+	return changed, nil
+}
+
+// attachmentsOlderThan returns the attachments of every source folder
+// dated more than the given number of days ago, oldest first.
+func attachmentsOlderThan(days int, settings *appSettings) ([]agedAttachment, error) {
+	threshold := time.Now().AddDate(0, 0, -days).Format("2006-01-02")
+	candidates := make([]agedAttachment, 0)
+	for _, folder := range sourceFolders(settings) {
+		files, err := os.ReadDir(folder.TargetPhotoPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read path %s: %v", folder.TargetPhotoPath, err)
+		}
+
+		for _, file := range files {
+			if file.IsDir() || !strings.HasPrefix(file.Name(), folder.ImagePrefix) {
+				continue
+			}
+			original := strings.TrimSuffix(strings.TrimPrefix(file.Name(), folder.ImagePrefix), encryptedExtension)
+			date, ok := getDateFromFile(original)
+			if !ok || date >= threshold {
+				continue
+			}
+			info, err := file.Info()
+			if err != nil {
+				return nil, fmt.Errorf("unable to stat %s: %v", file.Name(), err)
+			}
+			candidates = append(candidates, agedAttachment{path.Join(folder.TargetPhotoPath, file.Name()), date, info.Size()})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].date < candidates[j].date
+	})
+	return candidates, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to measure %s: %v", dir, err)
+	}
+	return size, nil
+}
+
+// downscaleAttachment scales the photo down so its longer side is at most
+// maxDimension pixels, keeping its name and modification time. It returns
+// the number of bytes saved. The resize command of the image tool does the
+// scaling when it is set.
+func downscaleAttachment(filePath string, maxDimension int, settings *appSettings) (int64, error) {
+	if maxDimension <= 0 {
+		maxDimension = 1600
+	}
+	if settings.ImageTool != nil && len(settings.ImageTool.Resize) > 0 {
+		return resizeWithImageTool(filePath, maxDimension, settings.ImageTool)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	img, format, err := image.Decode(f)
+	f.Close()
+	if err != nil {
+		return 0, fmt.Errorf("unable to decode: %v", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() <= maxDimension && bounds.Dy() <= maxDimension {
+		return 0, nil
+	}
+	scaled := scaleImage(img, maxDimension)
+
+	temp := filePath + ".tmp"
+	out, err := os.Create(temp)
+	if err != nil {
+		return 0, err
+	}
+	if format == "png" {
+		err = png.Encode(out, scaled)
+	} else {
+		err = jpeg.Encode(out, scaled, &jpeg.Options{Quality: 85})
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return 0, fmt.Errorf("unable to encode: %v", err)
+	}
+
+	if err := os.Chtimes(temp, time.Now(), info.ModTime()); err != nil {
+		os.Remove(temp)
+		return 0, err
+	}
+	scaledInfo, err := os.Stat(temp)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(temp, filePath); err != nil {
+		os.Remove(temp)
+		return 0, err
+	}
+	return info.Size() - scaledInfo.Size(), nil
+}
+
+// scaleImage averages the source pixels that fall on each target pixel.
+func scaleImage(src image.Image, maxDimension int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	scale := float64(maxDimension) / float64(width)
+	if height > width {
+		scale = float64(maxDimension) / float64(height)
+	}
+	dstWidth, dstHeight := int(float64(width)*scale), int(float64(height)*scale)
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := y*height/dstHeight, (y+1)*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := x*width/dstWidth, (x+1)*width/dstWidth
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / count), uint16(g / count), uint16(b / count), uint16(a / count)})
+		}
+	}
+	return dst
+}
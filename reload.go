@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadSettle is how long the settings file must stay unchanged before it
+// is read again, since editors often write it in several steps.
+const reloadSettle = time.Second
+
+// logLevelFlag is the --log-level of the command line, which a reload of
+// the settings keeps.
+var logLevelFlag string
+
+// settingsReloads delivers whenever the settings should be read again, on
+// SIGHUP and when the settings file changes. The folder of the file is
+// watched, so editors that replace the file are noticed too.
+func settingsReloads(settingsFile string) <-chan struct{} {
+	reloads := make(chan struct{}, 1)
+	request := func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		for range hangups {
+			logInfof("received SIGHUP, reloading the settings")
+			request()
+		}
+	}()
+
+	absolute, err := filepath.Abs(settingsFile)
+	if err != nil {
+		logWarnf("unable to watch %s, reloading on SIGHUP only: %s", settingsFile, err)
+		return reloads
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = watcher.Add(filepath.Dir(absolute))
+	}
+	if err != nil {
+		logWarnf("unable to watch %s, reloading on SIGHUP only: %s", settingsFile, err)
+		return reloads
+	}
+	go func() {
+		settle := time.NewTimer(time.Hour)
+		settle.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == absolute && event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0 {
+					settle.Reset(reloadSettle)
+				}
+			case <-watcher.Errors:
+			case <-settle.C:
+				logInfof("%s changed, reloading the settings", settingsFile)
+				request()
+			}
+		}
+	}()
+	return reloads
+}
+
+// reloadSettings reads the settings file again with the active profile.
+// Invalid settings are logged and the current ones stay in use.
+func reloadSettings(current *appSettings) (*appSettings, bool) {
+	reloaded, err := readSettings(current.settingsFile, current.Profile)
+	if err != nil {
+		logErrorf("keeping the current settings, unable to reload %s: %s", current.settingsFile, err)
+		return nil, false
+	}
+	level := logLevelFlag
+	if level == "" {
+		level = reloaded.LogLevel
+	}
+	if err := configureLogging(level, reloaded.LogFormat); err != nil {
+		logErrorf("keeping the current settings, unable to reload %s: %s", current.settingsFile, err)
+		return nil, false
+	}
+	return reloaded, true
+}
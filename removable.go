@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// removableSettings configure importing photos from camera cards. Volumes
+// are matched by label or UUID and their DCIM folder is scanned for photos
+// that have not been imported before.
+type removableSettings struct {
+	Volumes       []string `yaml:"volumes"`
+	HashIndexPath string   `yaml:"hash_index_path"`
+	NotifyCommand string   `yaml:"notify_command"`
+	TimeOffset    string   `yaml:"time_offset"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+// notifiedVolumes are the mount points of the volumes whose import was
+// notified, by volume.
+var notifiedVolumes = make(map[string]string)
+
+// importRemovableVolumes copies new photos from every mounted volume that
+// matches the configured labels or UUIDs. Photos are dated by their
+// modification time, which cameras set to the capture time.
+func importRemovableVolumes(settings *appSettings) (int, error) {
+	removable := settings.Removable
+	if removable.HashIndexPath == "" {
+		return 0, fmt.Errorf("removable hash_index_path is not set")
+	}
+
+	index, err := readHashIndex(removable.HashIndexPath)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := parseTimeOffset(removable.TimeOffset)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, volume := range removable.Volumes {
+		mountPoint, err := findMountPoint(volume)
+		if err != nil {
+			return count, err
+		}
+		if mountPoint == "" {
+			delete(notifiedVolumes, volume)
+			continue
+		}
+
+		logInfof("scanning removable volume %s mounted at %s", volume, mountPoint)
+		imported, err := importDCIM(filepath.Join(mountPoint, "DCIM"), index, offset, settings)
+		count += imported
+		if err != nil {
+			return count, err
+		}
+
+		// The watch scans a mounted card on every import, which only
+		// notifies again when it found new photos.
+		if imported > 0 || notifiedVolumes[volume] != mountPoint {
+			notifySafeToUnplug(volume, imported, removable)
+			notifiedVolumes[volume] = mountPoint
+		}
+	}
+
+	return count, nil
+}
+
+func importDCIM(dcimPath string, index map[string]bool, offset time.Duration, settings *appSettings) (int, error) {
+	count := 0
+	err := filepath.WalkDir(dcimPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		ext := photoExtension(entry.Name())
+		if ext == "" {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("unable to stat %s: %v", filePath, err)
+		}
+		imported, err := importHashedPhoto(filePath, info.ModTime().Add(offset), ext, index, settings.Removable.HashIndexPath, settings)
+		if err != nil || !imported {
+			return err
+		}
+		count++
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return count, nil
+	}
+	return count, err
+}
+
+func notifySafeToUnplug(volume string, imported int, settings *removableSettings) {
+	message := fmt.Sprintf("Imported %d photos from %s, it is safe to unplug", imported, volume)
+	logInfof("%s", message)
+	if settings.NotifyCommand == "" {
+		return
+	}
+	if err := exec.Command(settings.NotifyCommand, message).Run(); err != nil {
+		logErrorf("unable to run notify command %s: %s", settings.NotifyCommand, err)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "path/filepath"
+
+// findMountPoint resolves a volume label to its mount point under /Volumes.
+// An empty string means the volume is not mounted.
+func findMountPoint(volume string) (string, error) {
+	mountPoint := filepath.Join("/Volumes", volume)
+	if !dirExists(mountPoint) {
+		return "", nil
+	}
+	return mountPoint, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findMountPoint resolves a volume label or UUID to its mount point using
+// the udev symlinks and /proc/mounts. An empty string means the volume is
+// not mounted.
+func findMountPoint(volume string) (string, error) {
+	device := ""
+	for _, dir := range []string{"/dev/disk/by-label", "/dev/disk/by-uuid"} {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(dir, volume))
+		if err == nil {
+			device = resolved
+			break
+		}
+	}
+	if device == "" {
+		return "", nil
+	}
+
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", fmt.Errorf("unable to read mounts: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(fields[0]); err == nil && resolved == device {
+			// Mount points escape spaces as \040.
+			return strings.ReplaceAll(fields[1], `\040`, " "), nil
+		}
+	}
+	return "", scanner.Err()
+}
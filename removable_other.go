@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package main
+
+import "fmt"
+
+func findMountPoint(volume string) (string, error) {
+	return "", fmt.Errorf("removable volumes are not supported on this platform")
+}
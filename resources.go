@@ -0,0 +1,55 @@
+package main
+
+import "sync"
+
+// resourceSettings defer the heavy work of an import, converting HEIC and
+// other photos with the image tool and importing the photos collected while
+// travelling, when the machine runs on battery or its load average is over
+// max_load. The photos wait in their folders for an import that has the
+// resources, and everything else is imported as usual.
+type resourceSettings struct {
+	SkipOnBattery bool    `yaml:"skip_on_battery"`
+	MaxLoad       float64 `yaml:"max_load"`
+}
+
+var heavyWork = struct {
+	sync.Mutex
+	deferred bool
+}{}
+
+// checkResources decides at the start of an import whether its heavy work
+// waits for a later import. A check that fails doesn't defer anything.
+func checkResources(settings *appSettings) {
+	deferred := false
+	if limits := settings.Resources; limits != nil {
+		if limits.SkipOnBattery {
+			battery, err := onBattery()
+			if err != nil {
+				logWarnf("unable to check the power source: %s", err)
+			} else if battery {
+				logInfof("running on battery, deferring the conversions and the travel photos")
+				deferred = true
+			}
+		}
+		if limits.MaxLoad > 0 && !deferred {
+			load, err := loadAverage()
+			if err != nil {
+				logWarnf("unable to check the load average: %s", err)
+			} else if load > limits.MaxLoad {
+				logInfof("the load average %.2f is over %g, deferring the conversions and the travel photos", load, limits.MaxLoad)
+				deferred = true
+			}
+		}
+	}
+	heavyWork.Lock()
+	heavyWork.deferred = deferred
+	heavyWork.Unlock()
+}
+
+// deferringHeavyWork tells whether the import leaves its heavy work for a
+// later import.
+func deferringHeavyWork() bool {
+	heavyWork.Lock()
+	defer heavyWork.Unlock()
+	return heavyWork.deferred
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// onBattery asks pmset which power source the Mac draws from.
+func onBattery() (bool, error) {
+	output, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return false, fmt.Errorf("pmset failed: %v", err)
+	}
+	return strings.Contains(string(output), "'Battery Power'"), nil
+}
+
+// loadAverage returns the load average of the last minute, which sysctl
+// prints like "{ 1.52 1.38 1.27 }".
+func loadAverage() (float64, error) {
+	output, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+	if err != nil {
+		return 0, fmt.Errorf("sysctl failed: %v", err)
+	}
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(string(output)), "{}"))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unable to parse the load average %q", output)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// onBattery reads the power supplies of /sys/class/power_supply. A machine
+// without a battery, or with an online mains supply, is not on battery.
+func onBattery() (bool, error) {
+	supplies, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil {
+		return false, err
+	}
+	discharging := false
+	for _, supply := range supplies {
+		kind := readSupplyValue(supply, "type")
+		switch kind {
+		case "Mains", "USB":
+			if readSupplyValue(supply, "online") == "1" {
+				return false, nil
+			}
+		case "Battery":
+			if readSupplyValue(supply, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	return discharging, nil
+}
+
+func readSupplyValue(supply string, name string) string {
+	data, err := os.ReadFile(filepath.Join(supply, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// loadAverage returns the load average of the last minute.
+func loadAverage() (float64, error) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unable to parse /proc/loadavg")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package main
+
+import "fmt"
+
+func onBattery() (bool, error) {
+	return false, fmt.Errorf("the power source can't be checked on this platform")
+}
+
+func loadAverage() (float64, error) {
+	return 0, fmt.Errorf("the load average can't be checked on this platform")
+}
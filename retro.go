@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// retroEditSettings configure protecting settled notes. Photos for dates
+// older than the given number of days whose note already exists are moved
+// to the staging path instead of being appended, and imported only when
+// approved with the approve command.
+type retroEditSettings struct {
+	MaxAgeDays  int    `yaml:"max_age_days"`
+	StagingPath string `yaml:"staging_path"`
+}
+
+// stageRetroPhotos removes the photos of settled notes from the scanned
+// photos and moves them to the staging folder of the source folder.
+func stageRetroPhotos(photos map[string][]string, settings *appSettings) map[string][]string {
+	threshold := time.Now().AddDate(0, 0, -settings.RetroEdits.MaxAgeDays)
+	stagingPath := retroStagingPath(settings)
+
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+		day, err := parseDateKey(date)
+		if err != nil || !day.Before(threshold) || !fileExists(notePath(date, settings)) {
+			result[date] = datePhotos
+			continue
+		}
+
+		logInfof("staged %d photos for the settled note %s", len(datePhotos), date)
+		for _, photo := range datePhotos {
+			if err := stagePhoto(photo, date, stagingPath, settings, ""); err != nil {
+				logFields{"source": photo}.errorf("unable to stage %s: %s", photo, err)
+			}
+		}
+	}
+	return result
+}
+
+// retroStagingPath returns the staging folder of the source folder, so
+// photos from different folders don't collide.
+func retroStagingPath(settings *appSettings) string {
+	return path.Join(settings.RetroEdits.StagingPath, sourceName(settings))
+}
+
+// stageDeletedNotes stages the photos of the dates whose note the state
+// says was written but is gone, deleted in Obsidian, instead of recreating
+// the note without its earlier content. The staged notification asks to
+// approve them, which recreates the note, to restore the note from the
+// backup first and then approve them, or to skip them.
+func stageDeletedNotes(photos map[string][]string, settings *appSettings) map[string][]string {
+	missing := make(map[string]string)
+	for date := range photos {
+		if note := notePath(date, settings); !fileExists(note) {
+			missing[note] = date
+		}
+	}
+	if len(missing) == 0 {
+		return photos
+	}
+	deleted, err := recordedNotes(missing, settings)
+	if err != nil {
+		logErrorf("unable to check for deleted notes: %s", err)
+		return photos
+	}
+
+	stagingPath := retroStagingPath(settings)
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+		note := notePath(date, settings)
+		if !deleted[note] {
+			result[date] = datePhotos
+			continue
+		}
+
+		logFields{"note": note, "date": date}.warnf("the note %s was deleted, staged its %d photos", note, len(datePhotos))
+		for _, photo := range datePhotos {
+			if err := stagePhoto(photo, date, stagingPath, settings, "its note was deleted: approve to recreate the note, restore it from the backup before approving to keep its content, or skip"); err != nil {
+				logFields{"source": photo}.errorf("unable to stage %s: %s", photo, err)
+			}
+		}
+	}
+	return result
+}
+
+func stagePhoto(photo string, date string, stagingPath string, settings *appSettings, reason string) error {
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", stagingPath, err)
+	}
+
+	staging := *settings
+	staging.OriginalPhotoPath = stagingPath
+	separator := "-"
+	if isMonthKey(date) {
+		separator = "_"
+	}
+	name, err := freeName(date, separator, strings.TrimPrefix(path.Ext(photo), "."), &staging, nil)
+	if err != nil {
+		return err
+	}
+	target := path.Join(stagingPath, name)
+	if err := moveFile(photo, target); err != nil {
+		return err
+	}
+	publish(importEvent{Type: eventPhotoStaged, Date: date, Source: photo, Target: target, Message: reason})
+	return nil
+}
+
+// runApprove imports the staged photos of every source folder, or only the
+// photos of the given dates. With -skip they are set aside in the .skipped
+// folder of the staging path instead.
+func runApprove(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("approve", flag.ExitOnError)
+	list := flags.Bool("list", false, "List the staged photos without importing them")
+	skip := flags.Bool("skip", false, "Set the staged photos aside without importing them")
+	flags.Parse(args)
+
+	if settings.RetroEdits == nil || settings.RetroEdits.StagingPath == "" {
+		log.Fatal("retro_edits.staging_path is not set")
+	}
+	dates := make(map[string]bool)
+	for _, date := range flags.Args() {
+		dates[date] = true
+	}
+
+	if *list {
+		staged, err := stagedPhotos(settings, dates)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, photo := range staged {
+			fmt.Printf("%s\t%s\n", photo.date, photo.path)
+		}
+		return
+	}
+	if *skip {
+		skipped, err := skipStagedPhotos(settings, dates)
+		if err != nil {
+			log.Fatal(err)
+		}
+		logInfof("skipped %d staged photos", skipped)
+		return
+	}
+	if _, err := approveStagedPhotos(settings, dates); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// stagedPhoto is a photo in the staging folder of a source folder.
+type stagedPhoto struct {
+	folder *appSettings
+	path   string
+	date   string
+}
+
+// stagedPhotos lists the staged photos of every source folder, or only the
+// photos of the given dates.
+func stagedPhotos(settings *appSettings, dates map[string]bool) ([]stagedPhoto, error) {
+	staged := make([]stagedPhoto, 0)
+	for _, folder := range sourceFolders(settings) {
+		stagingPath := retroStagingPath(folder)
+		files, err := os.ReadDir(stagingPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read path %s: %v", stagingPath, err)
+		}
+		for _, file := range files {
+			date, ok := getDateFromFile(file.Name())
+			if file.IsDir() || !ok || (len(dates) > 0 && !dates[date]) {
+				continue
+			}
+			staged = append(staged, stagedPhoto{folder, path.Join(stagingPath, file.Name()), date})
+		}
+	}
+	return staged, nil
+}
+
+// approveStagedPhotos imports the staged photos of the dates, or all of
+// them without dates, and returns the number of notes and photos that
+// failed.
+func approveStagedPhotos(settings *appSettings, dates map[string]bool) (int, error) {
+	staged, err := stagedPhotos(settings, dates)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, photo := range staged {
+		approvePath := path.Join(retroStagingPath(photo.folder), ".approved")
+		if err := os.MkdirAll(approvePath, 0755); err != nil {
+			return 0, fmt.Errorf("unable to create %s: %v", approvePath, err)
+		}
+		if err := moveFile(photo.path, path.Join(approvePath, path.Base(photo.path))); err != nil {
+			return 0, fmt.Errorf("unable to approve %s: %v", path.Base(photo.path), err)
+		}
+	}
+
+	// The photos an interrupted approval left in .approved are imported
+	// too.
+	folders := make([]*appSettings, 0)
+	for _, folder := range sourceFolders(settings) {
+		approvePath := path.Join(retroStagingPath(folder), ".approved")
+		if !dirExists(approvePath) {
+			continue
+		}
+		approved := *folder
+		approved.OriginalPhotoPath = approvePath
+		approved.RetroEdits = nil
+		approved.UnsortedPhotoPath = ""
+		folders = append(folders, &approved)
+	}
+
+	if len(folders) == 0 {
+		return 0, nil
+	}
+	_, _, failures := importFolders(folders, false)
+	for _, folder := range folders {
+		if err := os.Remove(folder.OriginalPhotoPath); err != nil {
+			logErrorf("unable to remove %s: %s", folder.OriginalPhotoPath, err)
+		}
+	}
+	return failures, nil
+}
+
+// skipStagedPhotos moves the staged photos of the dates to the .skipped
+// folder of the staging path, where they are kept but not imported.
+func skipStagedPhotos(settings *appSettings, dates map[string]bool) (int, error) {
+	staged, err := stagedPhotos(settings, dates)
+	if err != nil {
+		return 0, err
+	}
+	for i, photo := range staged {
+		skipPath := path.Join(retroStagingPath(photo.folder), ".skipped")
+		if err := os.MkdirAll(skipPath, 0755); err != nil {
+			return i, fmt.Errorf("unable to create %s: %v", skipPath, err)
+		}
+		skipped := *photo.folder
+		skipped.OriginalPhotoPath = skipPath
+		separator := "-"
+		if isMonthKey(photo.date) {
+			separator = "_"
+		}
+		name, err := freeName(photo.date, separator, strings.TrimPrefix(path.Ext(photo.path), "."), &skipped, nil)
+		if err != nil {
+			return i, err
+		}
+		if err := moveFile(photo.path, path.Join(skipPath, name)); err != nil {
+			return i, fmt.Errorf("unable to skip %s: %v", path.Base(photo.path), err)
+		}
+	}
+	return len(staged), nil
+}
+
+// handleStaged approves or skips the staged photos of the date parameters,
+// or all of them without one, for the buttons of the staged notification.
+// It takes the instance lock like an import and asks to try again while
+// an import runs.
+func (api *profileAPI) handleStaged(w http.ResponseWriter, r *http.Request) {
+	if !api.authorized(w, r, func(config watchSettings) string { return config.APIToken }) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	api.mu.Lock()
+	settings := api.settings
+	api.mu.Unlock()
+	if settings.RetroEdits == nil || settings.RetroEdits.StagingPath == "" {
+		http.NotFound(w, r)
+		return
+	}
+	dates := make(map[string]bool)
+	for _, date := range r.URL.Query()["date"] {
+		if _, err := parseDateKey(date); err != nil {
+			http.Error(w, fmt.Sprintf("invalid date %s", date), http.StatusBadRequest)
+			return
+		}
+		dates[date] = true
+	}
+
+	lock, err := lockInstance(settings)
+	if errors.Is(err, errInstanceRunning) {
+		http.Error(w, "an import is running, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer lock.release()
+
+	result := make(map[string]int)
+	switch path.Base(r.URL.Path) {
+	case "approve":
+		var failures int
+		failures, err = approveStagedPhotos(settings, dates)
+		result["failed"] = failures
+	case "skip":
+		var skipped int
+		skipped, err = skipStagedPhotos(settings, dates)
+		result["skipped"] = skipped
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logErrorf("unable to resolve the staged photos: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	retryBackoff         = time.Second
+)
+
+// withRetry runs the operation until it succeeds, fails with an error that
+// won't go away by waiting, or runs out of attempts. The wait doubles after
+// every failed attempt.
+func withRetry(settings *appSettings, operation func() error) error {
+	attempts := settings.RetryAttempts
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || !isTransient(err) || attempt >= attempts || shuttingDown() {
+			return err
+		}
+		logWarnf("attempt %d/%d failed, retrying in %s: %s", attempt, attempts, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// transientErrors may go away on their own, like the I/O errors of a
+// network file system or a file that is busy for a moment.
+var transientErrors = []error{syscall.EIO, syscall.EAGAIN, syscall.EBUSY, syscall.ETIMEDOUT, syscall.ESTALE, os.ErrDeadlineExceeded}
+
+// isTransient tells whether the error is one that may go away by waiting.
+// Everything else, like a missing file or a note path that is a folder,
+// fails the same way on every attempt.
+func isTransient(err error) bool {
+	for _, transient := range transientErrors {
+		if errors.Is(err, transient) {
+			return true
+		}
+	}
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
+
+// checkReadable reads the start of the file, which fails for files the
+// import won't be able to copy either.
+func checkReadable(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 4096)); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// readablePhotos leaves out the photos that can't be read, so their links
+// are not written. They are moved to the quarantine folder.
+func readablePhotos(photos map[string][]string, scan *folderScan) map[string][]string {
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+		for _, photo := range datePhotos {
+			photo := photo
+			if err := withRetry(scan.settings, func() error { return checkReadable(photo) }); err != nil {
+				quarantineFailedFile(photo, err, scan.settings)
+				markPlannedAction(scan.planned, originalName(photo, scan.renamed), "quarantine")
+				continue
+			}
+			result[date] = append(result[date], photo)
+		}
+	}
+	return result
+}
+
+// quarantineFailedFile moves a file that keeps failing out of the source
+// folder, so it doesn't fail every import again. Without a quarantine path
+// it stays where it is.
+func quarantineFailedFile(filePath string, cause error, settings *appSettings) {
+	fields := logFields{"source": filePath}
+	if settings.QuarantinePath == "" || settings.SourceReadOnly {
+		fields.errorf("unable to import %s, leaving it in the source folder: %s", filePath, cause)
+		return
+	}
+
+	target := path.Join(settings.QuarantinePath, path.Base(filePath))
+	fields["target"] = target
+	if err := os.MkdirAll(settings.QuarantinePath, 0755); err != nil {
+		fields.errorf("unable to import %s, and unable to create %s: %s", filePath, settings.QuarantinePath, err)
+		return
+	}
+	if fileExists(target) {
+		fields.errorf("unable to import %s, and the quarantine already has a file by that name: %s", filePath, cause)
+		return
+	}
+	if err := moveFile(filePath, target); err != nil {
+		fields.errorf("unable to import %s, and unable to quarantine it: %s", filePath, err)
+		return
+	}
+	publish(importEvent{Type: eventPhotoQuarantined, Source: filePath, Target: target, Message: cause.Error()})
+	fields.errorf("unable to import %s, moved it to %s: %s", filePath, settings.QuarantinePath, cause)
+}
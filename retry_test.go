@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{&os.PathError{Op: "read", Path: "note.md", Err: syscall.EIO}, true},
+		{fmt.Errorf("unable to write: %w", syscall.EAGAIN), true},
+		{os.ErrDeadlineExceeded, true},
+		{&os.PathError{Op: "open", Path: "note.md", Err: syscall.EISDIR}, false},
+		{&os.PathError{Op: "open", Path: "note.md", Err: syscall.ENOENT}, false},
+		{&os.PathError{Op: "open", Path: "note.md", Err: syscall.EACCES}, false},
+		{fmt.Errorf("it kept changing"), false},
+	}
+	for _, test := range tests {
+		if transient := isTransient(test.err); transient != test.transient {
+			t.Errorf("isTransient(%v) = %v, want %v", test.err, transient, test.transient)
+		}
+	}
+}
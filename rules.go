@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dateRule adds tags and a callout to the notes created for a date. The
+// date is either a fixed YYYY-MM-DD or a recurring MM-DD.
+type dateRule struct {
+	Date    string   `yaml:"date"`
+	Tags    []string `yaml:"tags"`
+	Callout string   `yaml:"callout"`
+	Title   string   `yaml:"title"`
+	Text    string   `yaml:"text"`
+}
+
+func readDateRules(filePath string) ([]dateRule, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", filePath, err)
+	}
+
+	var rules []dateRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %v", filePath, err)
+	}
+	return rules, nil
+}
+
+// dateRuleSection returns the tags and callouts of the rules matching the
+// date. The rules file is read for every new note so edits apply without
+// a restart.
+func dateRuleSection(date string, settings *appSettings) string {
+	if settings.DateRulesFile == "" || isMonthKey(date) {
+		return ""
+	}
+
+	rules, err := readDateRules(settings.DateRulesFile)
+	if err != nil {
+		logErrorf("unable to read date rules: %s", err)
+		return ""
+	}
+
+	tags := make([]string, 0)
+	callouts := ""
+	for _, rule := range rules {
+		if rule.Date != date && rule.Date != date[5:] {
+			continue
+		}
+		for _, tag := range rule.Tags {
+			tags = append(tags, "#"+strings.TrimPrefix(tag, "#"))
+		}
+		if rule.Title == "" && rule.Text == "" {
+			continue
+		}
+
+		callout := rule.Callout
+		if callout == "" {
+			callout = "note"
+		}
+		callouts = callouts + fmt.Sprintf("> [!%s] %s\n", callout, rule.Title)
+		if text := strings.TrimRight(rule.Text, "\n"); text != "" {
+			for _, line := range strings.Split(text, "\n") {
+				callouts = callouts + strings.TrimRight("> "+line, " ") + "\n"
+			}
+		}
+		callouts = callouts + "\n"
+	}
+
+	section := ""
+	if len(tags) > 0 {
+		section = strings.Join(tags, " ") + "\n\n"
+	}
+	return section + callouts
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Settings configure an S3 compatible bucket. Without an endpoint the
+// AWS endpoint of the region is used; with one, such as a MinIO server,
+// the bucket is addressed in the path.
+type s3Settings struct {
+	Endpoint  string `yaml:"endpoint"`
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"`
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+var s3Client = &http.Client{Timeout: 10 * time.Minute}
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3ObjectURL returns the URL of the object with the given key.
+func s3ObjectURL(settings *s3Settings, key string) string {
+	if settings.Endpoint == "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", settings.Bucket, s3Region(settings), s3Escape(key))
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(settings.Endpoint, "/"), settings.Bucket, s3Escape(key))
+}
+
+func s3Region(settings *s3Settings) string {
+	if settings.Region == "" {
+		return "us-east-1"
+	}
+	return settings.Region
+}
+
+// s3Upload stores the file in the bucket under the given key.
+func s3Upload(settings *s3Settings, filePath string, key string) error {
+	hash, err := fileSHA256(filePath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", filePath, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %v", filePath, err)
+	}
+
+	resp, err := s3Request(settings, "PUT", key, f, info.Size(), hash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3Download writes the object with the given key to the target file.
+func s3Download(settings *s3Settings, key string, target string) error {
+	resp, err := s3Request(settings, "GET", key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	outputFile, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", target, err)
+	}
+	if _, err := io.Copy(outputFile, resp.Body); err != nil {
+		outputFile.Close()
+		return fmt.Errorf("unable to write %s: %v", target, err)
+	}
+	return outputFile.Close()
+}
+
+// s3Delete removes the object with the given key from the bucket.
+func s3Delete(settings *s3Settings, key string) error {
+	resp, err := s3Request(settings, "DELETE", key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// s3List returns the keys of the objects under the prefix.
+func s3List(settings *s3Settings) ([]string, error) {
+	keys := make([]string, 0)
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {settings.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s3QueryRequest(settings, "GET", "", query, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return keys, err
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return keys, fmt.Errorf("unable to read the objects of %s: %v", settings.Bucket, err)
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// s3Request sends a request signed with AWS Signature Version 4.
+func s3Request(settings *s3Settings, method string, key string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	return s3QueryRequest(settings, method, key, nil, body, size, payloadHash)
+}
+
+func s3QueryRequest(settings *s3Settings, method string, key string, query url.Values, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s3ObjectURL(settings, key), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 request: %v", err)
+	}
+	req.ContentLength = size
+	req.URL.RawQuery = s3CanonicalQuery(query)
+
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s3Region(settings))
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate),
+		"host;x-amz-content-sha256;x-amz-date",
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(canonicalHash[:])}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+settings.SecretKey), now.Format("20060102"))
+	signingKey = hmacSHA256(signingKey, s3Region(settings))
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=%s",
+		settings.AccessKey, scope, signature))
+
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s %s failed: %v", method, key, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("S3 %s %s returned %s: %s", method, key, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery encodes the query sorted by name, as the signature
+// requires.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3QueryEscape(name)+"="+s3QueryEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3QueryEscape(value string) string {
+	return strings.ReplaceAll(s3Escape(value), "/", "%2F")
+}
+
+// s3Escape escapes every character of the key except the unreserved ones
+// and slashes, as the signature requires.
+func s3Escape(key string) string {
+	var builder strings.Builder
+	for _, b := range []byte(key) {
+		if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
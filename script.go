@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// scriptRule routes and captions the photos its condition matches. The
+// condition and the caption are expressions of the expr language that see
+// the photo, e.g. `photo.hour < 12` or `photo.weekday + " morning"`. The
+// rules are applied in order, so a later rule sees the caption an earlier
+// one set and may route the photo elsewhere.
+type scriptRule struct {
+	If      string `yaml:"if"`
+	Caption string `yaml:"caption"`
+	Section string `yaml:"section"`
+	Skip    bool   `yaml:"skip"`
+}
+
+// scriptEnv is what the expressions of the rules see.
+type scriptEnv struct {
+	Photo scriptPhoto `expr:"photo"`
+}
+
+type scriptPhoto struct {
+	Name     string   `expr:"name"`
+	Original string   `expr:"original"`
+	Date     string   `expr:"date"`
+	Time     string   `expr:"time"`
+	Hour     int      `expr:"hour"`
+	Minute   int      `expr:"minute"`
+	Weekday  string   `expr:"weekday"`
+	Source   string   `expr:"source"`
+	Caption  string   `expr:"caption"`
+	Rating   int      `expr:"rating"`
+	Keywords []string `expr:"keywords"`
+	Video    bool     `expr:"video"`
+
+	Latitude  float64 `expr:"latitude"`
+	Longitude float64 `expr:"longitude"`
+	Located   bool    `expr:"located"`
+}
+
+type compiledRule struct {
+	rule    scriptRule
+	when    *vm.Program
+	caption *vm.Program
+}
+
+// compileScripts compiles the expressions of the rules so mistakes show up
+// when the settings are read.
+func compileScripts(rules []scriptRule) ([]compiledRule, error) {
+	result := make([]compiledRule, 0, len(rules))
+	for i, rule := range rules {
+		compiled := compiledRule{rule: rule}
+		var err error
+		if rule.If != "" {
+			if compiled.when, err = expr.Compile(rule.If, expr.Env(scriptEnv{}), expr.AsBool()); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid if: %v", i+1, err)
+			}
+		}
+		if rule.Caption != "" {
+			if compiled.caption, err = expr.Compile(rule.Caption, expr.Env(scriptEnv{}), expr.AsKind(reflect.String)); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid caption: %v", i+1, err)
+			}
+		}
+		result = append(result, compiled)
+	}
+	return result, nil
+}
+
+// runScripts applies the rules to every scanned photo, keeping the captions
+// and sections they set, and leaves out the photos a rule skips.
+func runScripts(photos map[string][]string, scan *folderScan) map[string][]string {
+	settings := scan.settings
+	rules, err := compileScripts(settings.Scripts)
+	if err != nil {
+		logErrorf("unable to run the scripts: %s", err)
+		return photos
+	}
+	if scan.captions == nil {
+		scan.captions = make(map[string]string)
+	}
+	scan.sections = make(map[string]string)
+
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+	photo:
+		for _, photo := range datePhotos {
+			env := scriptEnv{Photo: scriptPhotoFor(photo, date, scan)}
+			start := time.Now()
+			for i, rule := range rules {
+				if rule.when != nil {
+					matched, err := expr.Run(rule.when, env)
+					if err != nil {
+						logFields{"source": photo}.errorf("unable to run rule %d for %s: %s", i+1, photo, err)
+						continue
+					}
+					if !matched.(bool) {
+						continue
+					}
+				}
+				if rule.rule.Skip {
+					timeFileStage(photo, "scripts", start)
+					logFields{"source": photo}.infof("skipped %s, rule %d left it out", photo, i+1)
+					continue photo
+				}
+				if rule.caption != nil {
+					caption, err := expr.Run(rule.caption, env)
+					if err != nil {
+						logFields{"source": photo}.errorf("unable to run rule %d for %s: %s", i+1, photo, err)
+						continue
+					}
+					env.Photo.Caption = cleanCaption(caption.(string))
+					scan.captions[photo] = env.Photo.Caption
+				}
+				if rule.rule.Section != "" {
+					scan.sections[photo] = rule.rule.Section
+				}
+			}
+			timeFileStage(photo, "scripts", start)
+			result[date] = append(result[date], photo)
+		}
+	}
+	return result
+}
+
+func scriptPhotoFor(photo string, date string, scan *folderScan) scriptPhoto {
+	metadata := readPhotoMetadata(photo, scan.settings)
+	captured := metadata.captured
+	if captured.IsZero() {
+		if info, err := os.Stat(photo); err == nil {
+			captured = info.ModTime()
+		}
+	}
+
+	result := scriptPhoto{
+		Name:     path.Base(photo),
+		Original: originalName(photo, scan.renamed),
+		Date:     date,
+		Time:     captured.Format("15:04"),
+		Hour:     captured.Hour(),
+		Minute:   captured.Minute(),
+		Weekday:  captured.Weekday().String(),
+		Source:   sourceName(scan.settings),
+		Caption:  photoCaption(photo, scan),
+		Keywords: []string{},
+		Video:    videoExtension(photo) != "",
+
+		Latitude:  metadata.latitude,
+		Longitude: metadata.longitude,
+		Located:   metadata.located,
+	}
+	if sidecar, ok := scan.sidecars[photo]; ok {
+		result.Rating = sidecar.Rating
+		result.Keywords = append(result.Keywords, sidecar.Keywords...)
+	}
+	return result
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// sectionData is the data the section template is executed with.
+type sectionData struct {
+	Date     string
+	Weekday  string
+	Heading  string
+	Existing bool
+	Links    string
+	Photos   []sectionPhoto
+	Body     string
+
+	Enrichments string
+}
+
+// sectionPhoto describes a photo of the section: its attachment, the link
+// the default section would use for it, and where it came from.
+type sectionPhoto struct {
+	Name     string
+	Link     string
+	Embed    bool
+	Caption  string
+	Text     string
+	Source   string
+	Original string
+	Section  string
+	Pipeline []string
+}
+
+// photoGroup is the photos that go under one heading of the note.
+type photoGroup struct {
+	heading string
+	photos  []notePhoto
+}
+
+var sectionTemplateFuncs = template.FuncMap{
+	"join": strings.Join,
+}
+
+func parseSectionTemplate(text string) (*template.Template, error) {
+	return template.New("section_template").Funcs(sectionTemplateFuncs).Parse(text)
+}
+
+// photoSection returns the photo section added to the note, rendered with
+// the section template if one is set.
+func photoSection(date string, photos []notePhoto, exists bool, embedded int, settings *appSettings) string {
+	links := photoLinkList(photos, embedded, settings)
+	enrichments := ""
+	if hasPlugin(settings, "enricher") {
+		enrichments = enricherSection(date, photos, settings)
+	}
+	heading := labelsForNote(settings).section
+	body := messageBody(photos)
+	if settings.SectionTemplate == "" {
+		return groupedSection(photos, embedded, settings) + body + enrichments + provenanceLine(len(photos), settings)
+	}
+
+	data := sectionData{Date: date, Heading: heading, Existing: exists, Links: links, Body: strings.TrimSpace(body), Enrichments: enrichments}
+	if day, err := time.ParseInLocation("2006-01-02", date, time.Local); err == nil {
+		data.Weekday = day.Weekday().String()
+	}
+	for _, photo := range photos {
+		link, embed := photoLink(photo)
+		original := photo.original
+		if original == "" {
+			original = path.Base(photo.path)
+		}
+		target := targetPath(photo.path, photo.settings)
+		data.Photos = append(data.Photos, sectionPhoto{
+			Name:     path.Base(target),
+			Link:     link,
+			Embed:    embed,
+			Caption:  photo.caption,
+			Text:     photo.text,
+			Source:   sourceName(photo.settings),
+			Original: original,
+			Section:  photo.section,
+			Pipeline: sidecarPipeline(photo.path, original, target, photo.settings),
+		})
+	}
+
+	tmpl, err := parseSectionTemplate(settings.SectionTemplate)
+	var builder strings.Builder
+	if err == nil {
+		err = tmpl.Execute(&builder, data)
+	}
+	if err != nil {
+		logWarnf("unable to render the section template, using the default section: %s", err)
+		return groupedSection(photos, embedded, settings) + body + enrichments + provenanceLine(len(photos), settings)
+	}
+
+	text := builder.String()
+	if !strings.HasSuffix(text, "\n") {
+		text = text + "\n"
+	}
+	return text + provenanceLine(len(photos), settings)
+}
+
+// groupedSection returns the photo section followed by the sections the
+// scripts routed photos to.
+func groupedSection(photos []notePhoto, embedded int, settings *appSettings) string {
+	section := ""
+	for _, group := range photoGroups(photos, settings) {
+		if section != "" {
+			section = section + "\n"
+		}
+		links := photoLinkList(group.photos, embedded, settings)
+		embedded += countLinkEmbeds(links)
+		section = section + group.heading + "\n" + links
+	}
+	return section
+}
+
+// photoGroups groups the photos by the section the scripts routed them to,
+// starting with the photo section.
+func photoGroups(photos []notePhoto, settings *appSettings) []photoGroup {
+	groups := []photoGroup{{heading: labelsForNote(settings).section}}
+	index := map[string]int{"": 0}
+	for _, photo := range photos {
+		i, ok := index[photo.section]
+		if !ok {
+			i = len(groups)
+			index[photo.section] = i
+			groups = append(groups, photoGroup{heading: "### " + photo.section})
+		}
+		groups[i].photos = append(groups[i].photos, photo)
+	}
+	if len(groups[0].photos) == 0 {
+		groups = groups[1:]
+	}
+	return groups
+}
+
+// isCaptionLine tells whether the line is a caption written by
+// captionLine.
+func isCaptionLine(line string) bool {
+	return len(line) > 2 && strings.HasPrefix(line, "*") && strings.HasSuffix(line, "*") && !strings.HasPrefix(line, "**")
+}
+
+// countLinkEmbeds returns the number of embeds in a list of photo links.
+func countLinkEmbeds(links string) int {
+	return strings.Count("\n"+links, "\n![[")
+}
+
+// addToSection adds the links to the end of the section with the heading,
+// or as a new section at the end of the note when the note has none.
+func addToSection(diaryFilePath string, heading string, links string, settings *appSettings) error {
+	appended := formatForNote(diaryFilePath, "\n\n"+heading+"\n"+links, settings)
+	links = formatForNote(diaryFilePath, links, settings)
+
+	var sizeBefore int64
+	if info, err := os.Stat(diaryFilePath); err == nil {
+		sizeBefore = info.Size()
+	}
+
+	content := appended
+	err := rewriteNote(diaryFilePath, settings, func(original []byte) []byte {
+		original = []byte(stampLastImport(string(original), settings))
+		if updated, inserted, ok := insertIntoSection(original, heading, links, settings); ok {
+			content = inserted
+			return updated
+		}
+		content = appended
+		return append(original, appended...)
+	})
+	if err != nil {
+		return err
+	}
+
+	if settings.VerifyWrites {
+		verifyNoteWrite(diaryFilePath, content, sizeBefore, settings)
+	}
+	return nil
+}
+
+// unlinkedPhotos leaves out the photos whose attachments the note already
+// links to.
+func unlinkedPhotos(diaryFilePath string, photos []notePhoto) []notePhoto {
+	data, err := os.ReadFile(diaryFilePath)
+	if err != nil {
+		return photos
+	}
+	note := normalizeName(string(data))
+
+	result := make([]notePhoto, 0, len(photos))
+	for _, photo := range photos {
+		name := normalizeName(targetName(path.Base(photo.path), photo.settings))
+		if strings.Contains(note, "[["+name+"]]") || strings.Contains(note, "[["+name+"|") || strings.Contains(note, "["+name+"](") {
+			continue
+		}
+		result = append(result, photo)
+	}
+	return result
+}
+
+// insertIntoSection adds the links after the last link of the last section
+// of the note with the heading and returns the note and the inserted text.
+// It returns false when the note has no such section.
+func insertIntoSection(note []byte, heading string, links string, settings *appSettings) ([]byte, string, bool) {
+	lines := strings.SplitAfter(string(note), "\n")
+	start := -1
+	for i, line := range lines {
+		if heading == labelsForNote(settings).section && isSectionHeading(line, settings) || strings.TrimSpace(line) == heading {
+			start = i
+		}
+	}
+	if start < 0 {
+		return note, "", false
+	}
+
+	// The section ends at the first line that is not a link, a line of the
+	// overflow callout, the caption under a link, the provenance line of an
+	// earlier import or blank, like the habits of a new note.
+	provenance := provenancePattern(settings)
+	last := start + 1
+	afterLink := false
+	for i := start + 1; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			afterLink = false
+			continue
+		}
+		link := strings.HasPrefix(line, "![") || strings.HasPrefix(line, "[")
+		caption := afterLink && settings.CaptionsBelow && isCaptionLine(line)
+		if !link && !caption && !strings.HasPrefix(line, ">") && (provenance == nil || !provenance.MatchString(line)) {
+			break
+		}
+		afterLink = link
+		last = i + 1
+	}
+
+	// Photos over the embed limit join the overflow callout the section
+	// already ends with.
+	if header := strings.Index(links, "> [!note]-"); header >= 0 && strings.TrimSpace(links[:header]) == "" &&
+		strings.HasPrefix(strings.TrimSpace(lines[last-1]), ">") {
+		if newline := strings.Index(links[header:], "\n"); newline >= 0 {
+			links = links[header+newline+1:]
+		}
+	}
+
+	head := strings.Join(lines[:last], "")
+	if !strings.HasSuffix(head, "\n") {
+		newline := "\n"
+		if strings.HasSuffix(links, "\r\n") {
+			newline = "\r\n"
+		}
+		head = head + newline
+	}
+	return []byte(head + links + strings.Join(lines[last:], "")), links, true
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// selftestRecorder collects the events of the self-test imports.
+type selftestRecorder struct {
+	sync.Mutex
+	events []importEvent
+}
+
+func (r *selftestRecorder) handleEvent(event importEvent) error {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+// take returns the events recorded so far and starts over.
+func (r *selftestRecorder) take() []importEvent {
+	r.Lock()
+	defer r.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+// selftestPhoto is a sample photo of the self-test.
+type selftestPhoto struct {
+	name     string
+	captured time.Time
+}
+
+// runSelftest imports sample photos into a temporary vault and checks the
+// results, to confirm the build and the environment work before the import
+// meets a real vault. It uses the settings file when there is one, with the
+// paths moved to the temporary folder like in a dry run, and otherwise the
+// settings init writes. It exits with 1 when a check fails.
+func runSelftest(settingsFile string, profile string, logLevel string, args []string) {
+	flags := flag.NewFlagSet("selftest", flag.ExitOnError)
+	keep := flags.Bool("keep", false, "Keep the temporary vault")
+	flags.Parse(args)
+
+	if logLevel == "" {
+		logLevel = "warn"
+	}
+	if err := configureLogging(logLevel, ""); err != nil {
+		log.Fatalf("unable to configure logging: %s", err)
+	}
+
+	root, err := os.MkdirTemp("", "diary-selftest-")
+	if err != nil {
+		log.Fatalf("unable to create temporary folder: %s", err)
+	}
+	if *keep {
+		fmt.Printf("keeping the self-test vault in %s\n", root)
+	}
+
+	failures, err := selftest(settingsFile, profile, root)
+	if !*keep {
+		os.RemoveAll(root)
+	}
+	if err != nil {
+		log.Fatalf("unable to run the self-test: %s", err)
+	}
+	if failures > 0 {
+		fmt.Printf("%d checks failed\n", failures)
+		os.Exit(1)
+	}
+	fmt.Println("self-test passed")
+}
+
+// selftest runs the checks in the temporary root and returns the number of
+// checks that failed.
+func selftest(settingsFile string, profile string, root string) (int, error) {
+	settings, err := selftestSettings(settingsFile, profile, root)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the settings: %v", err)
+	}
+	sandbox, _ := dryRunSettings(settings, root)
+	sandbox.Sources = nil
+
+	failures := 0
+	check := func(name string, err error) {
+		if err != nil {
+			failures++
+			fmt.Printf("FAIL %s: %s\n", name, err)
+			return
+		}
+		fmt.Printf("ok   %s\n", name)
+	}
+
+	photos, err := writeSelftestPhotos(sandbox)
+	if err != nil {
+		return 0, err
+	}
+
+	recorder := &selftestRecorder{}
+	subscribe("selftest", nil, recorder)
+	importFolders([]*appSettings{sandbox}, false)
+	events := recorder.take()
+	check("import without errors", selftestErrors(events))
+	check("import every photo", selftestImported(photos, events))
+	check("link the photos in the notes", selftestLinks(events, sandbox))
+	check("dispose of the imported photos", selftestSources(photos, sandbox))
+
+	notes, err := selftestNotes(sandbox)
+	if err == nil {
+		importFolders([]*appSettings{sandbox}, false)
+		events = recorder.take()
+		err = selftestRepeat(notes, events, sandbox)
+	}
+	check("import again without changes", err)
+	return failures, nil
+}
+
+// selftestSettings reads the settings file, or the settings init would
+// write when there is none.
+func selftestSettings(settingsFile string, profile string, root string) (*appSettings, error) {
+	if fileExists(settingsFile) {
+		return readSettings(settingsFile, profile)
+	}
+	defaults := initSettings{
+		OriginalPhotoPath: path.Join(root, "source"),
+		TargetPhotoPath:   path.Join(root, "vault", "diary-attachments"),
+		ObsidianFilePath:  path.Join(root, "vault"),
+		ImagePrefix:       "diary-image-",
+	}
+	data, err := yaml.Marshal(defaults)
+	if err != nil {
+		return nil, err
+	}
+	defaultsFile := path.Join(root, "settings.yaml")
+	if err := os.WriteFile(defaultsFile, data, 0644); err != nil {
+		return nil, err
+	}
+	return readSettings(defaultsFile, "")
+}
+
+// writeSelftestPhotos writes two JPEG photos of yesterday and a PNG photo
+// of the day before.
+func writeSelftestPhotos(settings *appSettings) ([]selftestPhoto, error) {
+	if err := os.MkdirAll(settings.OriginalPhotoPath, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %v", settings.OriginalPhotoPath, err)
+	}
+	if err := os.MkdirAll(settings.ObsidianFilePath, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %v", settings.ObsidianFilePath, err)
+	}
+
+	yesterday := time.Now().AddDate(0, 0, -1).Truncate(24 * time.Hour).Add(9 * time.Hour)
+	before := yesterday.AddDate(0, 0, -1)
+	photos := []selftestPhoto{
+		{yesterday.Format("2006-01-02") + ".jpg", yesterday},
+		{yesterday.Format("2006-01-02") + "-02.jpg", yesterday.Add(3 * time.Hour)},
+		{before.Format("2006-01-02") + ".png", before},
+	}
+	for i, photo := range photos {
+		filePath := path.Join(settings.OriginalPhotoPath, photo.name)
+		if err := writeSelftestImage(filePath, i); err != nil {
+			return nil, err
+		}
+		if err := os.Chtimes(filePath, photo.captured, photo.captured); err != nil {
+			return nil, err
+		}
+	}
+	return photos, nil
+}
+
+// writeSelftestImage writes a small gradient, so the tools that read the
+// photos get a real image.
+func writeSelftestImage(filePath string, variant int) error {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / 640), uint8(y * 255 / 480), uint8(variant * 100), 255})
+		}
+	}
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", filePath, err)
+	}
+	if path.Ext(filePath) == ".png" {
+		err = png.Encode(f, img)
+	} else {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 85})
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %v", filePath, err)
+	}
+	return nil
+}
+
+func selftestErrors(events []importEvent) error {
+	messages := make([]string, 0)
+	for _, event := range events {
+		if event.Type == eventError {
+			messages = append(messages, event.Message)
+		}
+	}
+	if len(messages) > 0 {
+		return fmt.Errorf("%s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+func selftestImported(photos []selftestPhoto, events []importEvent) error {
+	imported := make(map[string]bool)
+	for _, event := range events {
+		if event.Type == eventPhotoImported {
+			imported[path.Base(event.Source)] = true
+		}
+	}
+	missing := make([]string, 0)
+	for _, photo := range photos {
+		if !imported[photo.name] {
+			missing = append(missing, photo.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s not imported", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// selftestLinks checks that the attachments exist and that the notes of
+// their dates mention them.
+func selftestLinks(events []importEvent, settings *appSettings) error {
+	for _, event := range events {
+		if event.Type != eventPhotoImported {
+			continue
+		}
+		if !fileExists(event.Target) {
+			return fmt.Errorf("attachment %s is missing", event.Target)
+		}
+		date, _ := getDateFromFile(event.Source)
+		note := notePath(date, settings)
+		content, err := os.ReadFile(note)
+		if err != nil {
+			return fmt.Errorf("unable to read the note of %s: %v", date, err)
+		}
+		if !strings.Contains(string(content), path.Base(event.Target)) {
+			return fmt.Errorf("%s does not link %s", note, path.Base(event.Target))
+		}
+	}
+	return nil
+}
+
+// selftestSources checks that the imported photos left the source folder,
+// or that they stayed where the source keeps its photos.
+func selftestSources(photos []selftestPhoto, settings *appSettings) error {
+	keeps := settings.SourceReadOnly || settings.ProcessedMarkers != nil
+	for _, photo := range photos {
+		left := fileExists(path.Join(settings.OriginalPhotoPath, photo.name))
+		if left && !keeps {
+			return fmt.Errorf("%s was left in the source folder", photo.name)
+		}
+		if !left && keeps {
+			return fmt.Errorf("%s was removed from a source folder that keeps its photos", photo.name)
+		}
+	}
+	return nil
+}
+
+func selftestNotes(settings *appSettings) (map[string]string, error) {
+	names, err := noteFiles(settings)
+	if err != nil {
+		return nil, err
+	}
+	notes := make(map[string]string)
+	for _, name := range names {
+		content, err := os.ReadFile(path.Join(settings.ObsidianFilePath, name))
+		if err != nil {
+			return nil, err
+		}
+		notes[name] = string(content)
+	}
+	return notes, nil
+}
+
+// selftestRepeat checks that a second import finds nothing to import and
+// leaves the notes as they were.
+func selftestRepeat(notes map[string]string, events []importEvent, settings *appSettings) error {
+	for _, event := range events {
+		if event.Type == eventPhotoImported {
+			return fmt.Errorf("%s was imported again", path.Base(event.Source))
+		}
+	}
+	if err := selftestErrors(events); err != nil {
+		return err
+	}
+	repeated, err := selftestNotes(settings)
+	if err != nil {
+		return err
+	}
+	for name, content := range repeated {
+		if notes[name] != content {
+			return fmt.Errorf("%s changed", name)
+		}
+	}
+	return nil
+}
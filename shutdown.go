@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+var shutdown = struct {
+	once sync.Once
+	done chan struct{}
+}{done: make(chan struct{})}
+
+// handleShutdownSignals lets SIGINT and SIGTERM stop the import between
+// notes, so a note and the photos it links are always written together.
+// A second signal exits right away.
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		logWarnf("received %s, stopping after the current note", received)
+		shutdown.once.Do(func() { close(shutdown.done) })
+
+		received = <-signals
+		logErrorf("received %s again, exiting right away", received)
+		os.Exit(130)
+	}()
+}
+
+// shuttingDown tells whether a shutdown was requested.
+func shuttingDown() bool {
+	select {
+	case <-shutdown.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdownRequested returns a channel that is closed when a shutdown is
+// requested.
+func shutdownRequested() <-chan struct{} {
+	return shutdown.done
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// photoSidecar is the metadata written next to an imported photo for
+// tools that consume the diary attachments.
+type photoSidecar struct {
+	CaptureTime  time.Time `json:"capture_time"`
+	OriginalName string    `json:"original_name"`
+	SHA256       string    `json:"sha256"`
+	Source       string    `json:"source"`
+	Pipeline     []string  `json:"pipeline"`
+	ImportedAt   time.Time `json:"imported_at"`
+}
+
+// writeSidecar writes <attachment>.json next to a moved photo. The
+// pipeline lists the import steps the photo went through.
+func writeSidecar(target string, original string, pipeline []string, settings *appSettings) error {
+	info, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %v", target, err)
+	}
+	hash, err := fileSHA256(target)
+	if err != nil {
+		return err
+	}
+
+	sidecar := photoSidecar{
+		CaptureTime:  info.ModTime(),
+		OriginalName: original,
+		SHA256:       hash,
+		Source:       sourceName(settings),
+		Pipeline:     pipeline,
+		ImportedAt:   time.Now(),
+	}
+
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sidecar: %v", err)
+	}
+	if err := os.WriteFile(target+".json", append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("unable to write %s.json: %v", target, err)
+	}
+	return nil
+}
+
+// sidecarPipeline returns the import steps applied to a photo.
+func sidecarPipeline(photo string, original string, target string, settings *appSettings) []string {
+	pipeline := make([]string, 0)
+	if settings.HEIC != nil && isHEICName(original) {
+		pipeline = append(pipeline, "converted")
+		original = strings.TrimSuffix(original, path.Ext(original)) + ".jpg"
+	}
+	if original != path.Base(photo) {
+		pipeline = append(pipeline, "renamed")
+	}
+	if settings.LargeFilePath != "" && path.Dir(target) == path.Clean(settings.LargeFilePath) {
+		pipeline = append(pipeline, "large_file")
+	}
+	if settings.Encryption != nil {
+		pipeline = append(pipeline, "encrypted")
+	}
+	return append(pipeline, "moved")
+}
+
+// originalName returns the name the photo had before it was renamed to an
+// ISO name.
+func originalName(photo string, renamed map[string]string) string {
+	name := path.Base(photo)
+	if original, ok := renamed[name]; ok {
+		return original
+	}
+	return name
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+)
+
+// signalSettings configure polling a signal-cli daemon started with
+// `daemon --http --receive-mode=manual`. Without a group ID, messages sent
+// to "Note to Self" are imported.
+type signalSettings struct {
+	URL             string `yaml:"url"`
+	Account         string `yaml:"account"`
+	GroupID         string `yaml:"group_id"`
+	AttachmentsPath string `yaml:"attachments_path"`
+	MessageText     bool   `yaml:"message_text"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+type signalAttachment struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	Filename    string `json:"filename"`
+}
+
+type signalGroupInfo struct {
+	GroupID string `json:"groupId"`
+}
+
+type signalMessage struct {
+	Timestamp         int64              `json:"timestamp"`
+	Message           string             `json:"message"`
+	DestinationNumber string             `json:"destinationNumber"`
+	GroupInfo         *signalGroupInfo   `json:"groupInfo"`
+	Attachments       []signalAttachment `json:"attachments"`
+}
+
+type signalEnvelope struct {
+	Envelope struct {
+		Timestamp   int64          `json:"timestamp"`
+		DataMessage *signalMessage `json:"dataMessage"`
+		SyncMessage *struct {
+			SentMessage *signalMessage `json:"sentMessage"`
+		} `json:"syncMessage"`
+	} `json:"envelope"`
+}
+
+type jsonRPCRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+	ID      int         `json:"id"`
+}
+
+type jsonRPCResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+var signalClient = &http.Client{Timeout: 60 * time.Second}
+
+// importSignalMessages receives pending messages from signal-cli and copies
+// their image attachments into the original photo path, named by the
+// message timestamp. With message_text the text of the message is kept
+// with its first photo, for the body of the entry.
+func importSignalMessages(settings *appSettings) (int, error) {
+	var envelopes []signalEnvelope
+	params := map[string]interface{}{"account": settings.Signal.Account, "timeout": 1}
+	if err := signalRPC(settings.Signal.URL, "receive", params, &envelopes); err != nil {
+		return 0, err
+	}
+
+	count, failed := 0, 0
+	for _, envelope := range envelopes {
+		message := selectSignalMessage(envelope, settings.Signal)
+		if message == nil {
+			continue
+		}
+
+		timestamp := message.Timestamp
+		if timestamp == 0 {
+			timestamp = envelope.Envelope.Timestamp
+		}
+		date := time.UnixMilli(timestamp).Local()
+		text := ""
+		if settings.Signal.MessageText {
+			text = message.Message
+		}
+
+		for _, attachment := range message.Attachments {
+			ext := extensionForContentType(attachment.ContentType)
+			if ext == "" {
+				continue
+			}
+			// signal-cli delivers every message only once, so a failed
+			// attachment doesn't stop the import of the others.
+			source := path.Join(settings.Signal.AttachmentsPath, attachment.ID)
+			target := ""
+			name, err := freePhotoName(date, ext, settings)
+			if err == nil {
+				target = path.Join(settings.OriginalPhotoPath, name)
+				err = copyFile(source, target)
+			}
+			if err != nil {
+				logFields{"source": source}.errorf("unable to import the Signal attachment %s, it is left in %s: %s", attachment.ID, settings.Signal.AttachmentsPath, err)
+				failed++
+				continue
+			}
+			count++
+			if err := writeMessageText(target, text); err != nil {
+				logFields{"source": target}.errorf("%s", err)
+			}
+			text = ""
+		}
+	}
+
+	if failed > 0 {
+		return count, fmt.Errorf("%d attachments failed", failed)
+	}
+	return count, nil
+}
+
+func selectSignalMessage(envelope signalEnvelope, settings *signalSettings) *signalMessage {
+	if settings.GroupID != "" {
+		for _, message := range []*signalMessage{envelope.Envelope.DataMessage, sentSignalMessage(envelope)} {
+			if message != nil && message.GroupInfo != nil && message.GroupInfo.GroupID == settings.GroupID {
+				return message
+			}
+		}
+		return nil
+	}
+
+	message := sentSignalMessage(envelope)
+	if message != nil && message.GroupInfo == nil && message.DestinationNumber == settings.Account {
+		return message
+	}
+	return nil
+}
+
+func sentSignalMessage(envelope signalEnvelope) *signalMessage {
+	if envelope.Envelope.SyncMessage == nil {
+		return nil
+	}
+	return envelope.Envelope.SyncMessage.SentMessage
+}
+
+func signalRPC(url string, method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(jsonRPCRequest{JSONRPC: "2.0", Method: method, Params: params, ID: 1})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %v", method, err)
+	}
+
+	resp, err := signalClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s request failed: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s request returned %s", method, resp.Status)
+	}
+
+	var response jsonRPCResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to unmarshal %s response: %v", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s failed: %s", method, response.Error.Message)
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("failed to unmarshal %s result: %v", method, err)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"time"
+)
+
+// runSimulate generates a synthetic source folder, imports it into a
+// temporary vault with the configured settings and prints the resulting
+// notes. Remote sources and everything else that reaches outside of the
+// vault are disabled.
+func runSimulate(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	photos := flags.Int("photos", 10, "Number of generated photos")
+	days := flags.Int("days", 3, "Number of days the photos are spread over")
+	seed := flags.Int64("seed", 1, "Seed of the generated names and sizes")
+	keep := flags.Bool("keep", false, "Keep the temporary vault")
+	flags.Parse(args)
+
+	if *days < 1 {
+		*days = 1
+	}
+
+	root, err := os.MkdirTemp("", "diary-simulate-")
+	if err != nil {
+		log.Fatalf("unable to create temporary folder: %s", err)
+	}
+	if *keep {
+		logInfof("keeping the simulated vault in %s", root)
+	} else {
+		defer os.RemoveAll(root)
+	}
+
+	simulated := simulationSettings(settings, root)
+	if err := os.MkdirAll(simulated.OriginalPhotoPath, 0755); err != nil {
+		log.Fatalf("unable to create %s: %s", simulated.OriginalPhotoPath, err)
+	}
+	if err := os.MkdirAll(simulated.ObsidianFilePath, 0755); err != nil {
+		log.Fatalf("unable to create %s: %s", simulated.ObsidianFilePath, err)
+	}
+	if err := generatePhotos(simulated, *photos, *days, rand.New(rand.NewSource(*seed))); err != nil {
+		log.Fatalf("unable to generate photos: %s", err)
+	}
+
+	_, planned, _ := importFolders([]*appSettings{simulated}, true)
+	for _, file := range planned {
+		fmt.Printf("%-32s %-8s %s\n", file.Name, file.Action, file.Target)
+	}
+
+	notes, err := noteFiles(simulated)
+	if err != nil {
+		log.Fatalf("unable to list the notes: %s", err)
+	}
+	for _, note := range notes {
+		content, err := os.ReadFile(path.Join(simulated.ObsidianFilePath, note))
+		if err != nil {
+			log.Fatalf("unable to read %s: %s", note, err)
+		}
+		fmt.Printf("\n==> %s <==\n%s", note, content)
+	}
+}
+
+// simulationSettings returns a copy of the settings with every path moved
+// under the temporary root.
+func simulationSettings(settings *appSettings, root string) *appSettings {
+	simulated := *settings
+	simulated.OriginalPhotoPath = path.Join(root, "source")
+	simulated.ObsidianFilePath = path.Join(root, "vault")
+	simulated.TargetPhotoPath = path.Join(root, "vault", "attachments")
+	if settings.LargeFilePath != "" {
+		simulated.LargeFilePath = path.Join(root, "large")
+	}
+	if settings.UnsortedPhotoPath != "" {
+		simulated.UnsortedPhotoPath = path.Join(root, "unsorted")
+	}
+	if settings.ArchivePhotoPath != "" {
+		simulated.ArchivePhotoPath = path.Join(root, "archive")
+	}
+	if settings.DateGuard != nil && settings.DateGuard.QuarantinePath != "" {
+		guard := *settings.DateGuard
+		guard.QuarantinePath = path.Join(root, "quarantine")
+		simulated.DateGuard = &guard
+	}
+
+	if settings.QuarantinePath != "" {
+		simulated.QuarantinePath = path.Join(root, "failed")
+	}
+	if settings.StatePath != "" {
+		simulated.StatePath = path.Join(root, "state.db")
+	}
+	if settings.RetroEdits != nil && settings.RetroEdits.StagingPath != "" {
+		retro := *settings.RetroEdits
+		retro.StagingPath = path.Join(root, "staging")
+		simulated.RetroEdits = &retro
+	}
+	if settings.ProcessedMarkers != nil {
+		markers := *settings.ProcessedMarkers
+		markers.LedgerPath = path.Join(root, "ledger.jsonl")
+		simulated.ProcessedMarkers = &markers
+	}
+
+	simulated.Sources = nil
+	simulated.VaultIndex = nil
+	simulated.LatestPhotoPath = ""
+	simulated.CalDAV = nil
+	simulated.Signal = nil
+	simulated.Matrix = nil
+	simulated.Telegram = nil
+	simulated.IMAP = nil
+	simulated.Removable = nil
+	simulated.Camera = nil
+	simulated.Backup = nil
+	simulated.Storage = nil
+	simulated.Cast = nil
+	simulated.Plugins = nil
+	return &simulated
+}
+
+// generatePhotos writes photos with ISO names, names in the first
+// configured date layout and a few unrecognized names. Their modification
+// times are spread over the day like capture times.
+func generatePhotos(settings *appSettings, count int, days int, random *rand.Rand) error {
+	start := time.Now().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+	reserved := make(map[string]bool)
+	for i := 0; i < count; i++ {
+		captured := start.AddDate(0, 0, random.Intn(days)).Add(time.Duration(6*60+random.Intn(16*60)) * time.Minute)
+		ext := "jpg"
+		if random.Intn(5) == 0 {
+			ext = "png"
+		}
+
+		name := fmt.Sprintf("%s.%s", captured.Format("2006-01-02"), ext)
+		if i%7 == 6 {
+			name = fmt.Sprintf("IMG_%04d.%s", 1000+i, ext)
+		} else if layoutName := fmt.Sprintf("%s.%s", captured.Format(firstLayout(settings)), ext); i%3 == 2 && !reserved[layoutName] {
+			// Layouts can't take a suffix, so only the first photo of a day
+			// gets a localized name.
+			name = layoutName
+		}
+		name = uniqueSimulatedName(name, reserved)
+
+		size := int64(10*1024 + random.Intn(200*1024))
+		if i == count-1 && settings.LargeFilePath != "" && settings.LargeFileThresholdMB > 0 {
+			size = (settings.LargeFileThresholdMB + 1) * 1024 * 1024
+		}
+		if err := writeSimulatedPhoto(path.Join(settings.OriginalPhotoPath, name), size, captured, random); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func firstLayout(settings *appSettings) string {
+	if len(settings.DateLayouts) == 0 {
+		return "2006-01-02"
+	}
+	return settings.DateLayouts[0]
+}
+
+func uniqueSimulatedName(name string, reserved map[string]bool) string {
+	ext := path.Ext(name)
+	base := name[:len(name)-len(ext)]
+	result := name
+	for i := 1; reserved[result]; i++ {
+		result = fmt.Sprintf("%s-%02d%s", base, i, ext)
+	}
+	reserved[result] = true
+	return result
+}
+
+func writeSimulatedPhoto(filePath string, size int64, captured time.Time, random *rand.Rand) error {
+	f, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", filePath, err)
+	}
+	// Only the head of the file holds data, large files are left sparse.
+	head := make([]byte, 4096)
+	random.Read(head)
+	if _, err := f.Write(head); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write %s: %v", filePath, err)
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to resize %s: %v", filePath, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(filePath, captured, captured)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSettings writes a settings file for a vault in a temporary folder
+// and reads it like the import does.
+func testSettings(t *testing.T, extra string) *appSettings {
+	t.Helper()
+	root := t.TempDir()
+	data := "original_photo_path: " + path.Join(root, "source") + "\n" +
+		"target_photo_path: " + path.Join(root, "vault", "attachments") + "\n" +
+		"obsidian_file_path: " + path.Join(root, "vault") + "\n" +
+		"image_prefix: diary-image-\n" + extra
+	settingsFile := path.Join(root, "settings.yaml")
+	if err := os.WriteFile(settingsFile, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	settings, err := readSettings(settingsFile, "")
+	if err != nil {
+		t.Fatalf("unable to read the settings: %s", err)
+	}
+	for _, dir := range []string{settings.OriginalPhotoPath, settings.ObsidianFilePath} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return settings
+}
+
+func readTestNotes(t *testing.T, settings *appSettings) map[string]string {
+	t.Helper()
+	notes, err := selftestNotes(settings)
+	if err != nil {
+		t.Fatalf("unable to read the notes: %s", err)
+	}
+	return notes
+}
+
+func TestImportFoldersWritesNotes(t *testing.T) {
+	settings := testSettings(t, "")
+	captured := time.Date(2024, 5, 1, 9, 0, 0, 0, time.Local)
+	for i, name := range []string{"2024-05-01.jpg", "2024-05-01-02.jpg", "2024-05-02.png"} {
+		filePath := path.Join(settings.OriginalPhotoPath, name)
+		if err := writeSelftestImage(filePath, i); err != nil {
+			t.Fatal(err)
+		}
+		when := captured.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filePath, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	importFolders([]*appSettings{settings}, false)
+	notes := readTestNotes(t, settings)
+
+	first := notes["2024-05-01.md"]
+	if !strings.HasPrefix(first, "# 2024-05-01\n") || !strings.Contains(first, "![[diary-image-2024-05-01.jpg]]") || !strings.Contains(first, "![[diary-image-2024-05-01-02.jpg]]") {
+		t.Errorf("2024-05-01.md does not embed both photos:\n%s", first)
+	}
+	if second := notes["2024-05-02.md"]; !strings.Contains(second, "![[diary-image-2024-05-02.png]]") {
+		t.Errorf("2024-05-02.md does not embed its photo:\n%s", second)
+	}
+	for _, name := range []string{"diary-image-2024-05-01.jpg", "diary-image-2024-05-01-02.jpg", "diary-image-2024-05-02.png"} {
+		if !fileExists(path.Join(settings.TargetPhotoPath, name)) {
+			t.Errorf("attachment %s is missing", name)
+		}
+	}
+	if entries, _ := os.ReadDir(settings.OriginalPhotoPath); len(entries) > 0 {
+		t.Errorf("%d files were left in the source folder", len(entries))
+	}
+
+	importFolders([]*appSettings{settings}, false)
+	for name, content := range readTestNotes(t, settings) {
+		if notes[name] != content {
+			t.Errorf("%s changed on the second import:\n%s", name, content)
+		}
+	}
+}
+
+func TestImportFoldersLinksSimulatedPhotos(t *testing.T) {
+	settings := testSettings(t, "date_layouts: [\"IMG_20060102_150405\"]\nunsorted_photo_path: "+path.Join(t.TempDir(), "unsorted")+"\n")
+	if err := generatePhotos(settings, 14, 3, rand.New(rand.NewSource(1))); err != nil {
+		t.Fatalf("unable to generate photos: %s", err)
+	}
+
+	_, planned, _ := importFolders([]*appSettings{settings}, true)
+	notes := readTestNotes(t, settings)
+	imported := 0
+	for _, file := range planned {
+		if file.Action != "import" {
+			continue
+		}
+		imported++
+		note := strings.TrimPrefix(notePath(file.Date, settings), settings.ObsidianFilePath+"/")
+		if !strings.Contains(notes[note], "![["+path.Base(file.Target)+"]]") {
+			t.Errorf("%s does not embed %s:\n%s", note, path.Base(file.Target), notes[note])
+		}
+	}
+	if imported == 0 {
+		t.Fatal("no generated photo was imported")
+	}
+}
+
+func TestSimulationSettingsStayInTheRoot(t *testing.T) {
+	settings := testSettings(t, "state_path: "+path.Join(t.TempDir(), "state.db")+"\nvault_index:\n  paths: [/vault]\nretro_edits:\n  max_age_days: 7\n  staging_path: /staging\n")
+	root := t.TempDir()
+	simulated := simulationSettings(settings, root)
+	for name, value := range map[string]string{"state_path": simulated.StatePath, "staging_path": simulated.RetroEdits.StagingPath} {
+		if !strings.HasPrefix(value, root+"/") {
+			t.Errorf("%s %s is outside of %s", name, value, root)
+		}
+	}
+	if simulated.VaultIndex != nil {
+		t.Error("the vault index of the settings is used")
+	}
+}
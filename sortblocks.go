@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var photoLinkLinePattern = regexp.MustCompile(`^!?\[\[([^\]|]+)[^\]]*\]\]$|^\[([^\]]+)\]\(<file://[^>]*>\)$`)
+
+// photoBlock is a section of photo links. Each of its photos is the link
+// line followed by the caption lines under it with captions_below, so a
+// caption moves with its photo.
+type photoBlock struct {
+	start    int
+	end      int
+	photos   [][]string
+	captured time.Time
+}
+
+// sortPhotoBlocks orders the photo blocks of a note, and the photos within
+// each block, by capture time. Blocks stay where they are in the note, only
+// their contents are swapped, so text written between them is not moved.
+func sortPhotoBlocks(diaryFilePath string, settings *appSettings) {
+	original, err := os.ReadFile(diaryFilePath)
+	if err != nil {
+		return
+	}
+	if bytes.Equal(sortedPhotoBlocks(original, settings), original) {
+		return
+	}
+
+	err = rewriteNote(diaryFilePath, settings, func(original []byte) []byte {
+		return sortedPhotoBlocks(original, settings)
+	})
+	if err != nil {
+		logFields{"note": diaryFilePath}.errorf("unable to sort the photos: %s", err)
+	}
+}
+
+func sortedPhotoBlocks(note []byte, settings *appSettings) []byte {
+	newline := "\n"
+	if bytes.Contains(note, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+	lines := strings.Split(string(note), newline)
+
+	blocks := make([]photoBlock, 0)
+	for i := 0; i < len(lines); i++ {
+		if !isSectionHeading(lines[i], settings) {
+			continue
+		}
+		block := photoBlock{start: i}
+		j := i + 1
+		for j < len(lines) && photoLinkLinePattern.MatchString(strings.TrimSpace(lines[j])) {
+			photo := []string{lines[j]}
+			for j++; j < len(lines) && settings.CaptionsBelow && isCaptionLine(strings.TrimSpace(lines[j])); j++ {
+				photo = append(photo, lines[j])
+			}
+			block.photos = append(block.photos, photo)
+		}
+		block.end = j
+		sortPhotosByCaptureTime(block.photos, settings)
+		if len(block.photos) > 0 {
+			block.captured = linkCaptureTime(block.photos[0][0], settings)
+		}
+		blocks = append(blocks, block)
+		i = j - 1
+	}
+
+	sorted := make([]photoBlock, len(blocks))
+	copy(sorted, blocks)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].captured.Before(sorted[j].captured)
+	})
+
+	result := make([]string, 0, len(lines))
+	next := 0
+	for k, block := range blocks {
+		result = append(result, lines[next:block.start]...)
+		result = append(result, lines[block.start])
+		for _, photo := range sorted[k].photos {
+			result = append(result, photo...)
+		}
+		next = block.end
+	}
+	result = append(result, lines[next:]...)
+
+	return []byte(strings.Join(result, newline))
+}
+
+func sortPhotosByCaptureTime(photos [][]string, settings *appSettings) {
+	sort.SliceStable(photos, func(i, j int) bool {
+		return linkCaptureTime(photos[i][0], settings).Before(linkCaptureTime(photos[j][0], settings))
+	})
+}
+
+// linkCaptureTime returns the modification time of the linked attachment,
+// which is kept from the original photo when it is moved. Links to missing
+// files sort first.
+func linkCaptureTime(link string, settings *appSettings) time.Time {
+	match := photoLinkLinePattern.FindStringSubmatch(strings.TrimSpace(link))
+	if match == nil {
+		return time.Time{}
+	}
+	name := path.Base(match[1] + match[2])
+
+	for _, folder := range sourceFolders(settings) {
+		for _, dir := range []string{folder.TargetPhotoPath, folder.LargeFilePath} {
+			if dir == "" {
+				continue
+			}
+			filePath, ok := findFile(dir, name)
+			if !ok {
+				continue
+			}
+			if info, err := os.Stat(filePath); err == nil {
+				return info.ModTime()
+			}
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestSortedPhotoBlocksKeepCaptionsBelowTheirPhotos(t *testing.T) {
+	settings := testSettings(t, "captions_below: true\n")
+	if err := os.MkdirAll(settings.TargetPhotoPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	captured := time.Date(2024, 5, 1, 9, 0, 0, 0, time.Local)
+	for i, name := range []string{"diary-image-2024-05-01.jpg", "diary-image-2024-05-01-01.jpg"} {
+		filePath := path.Join(settings.TargetPhotoPath, name)
+		if err := os.WriteFile(filePath, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		when := captured.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(filePath, when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	note := "# 2024-05-01\n\n" + sectionHeading + "\n" +
+		"![[diary-image-2024-05-01-01.jpg]]\n*Lunch*\n" +
+		"![[diary-image-2024-05-01.jpg]]\n*Breakfast*\n\nText\n"
+	want := "# 2024-05-01\n\n" + sectionHeading + "\n" +
+		"![[diary-image-2024-05-01.jpg]]\n*Breakfast*\n" +
+		"![[diary-image-2024-05-01-01.jpg]]\n*Lunch*\n\nText\n"
+	if sorted := string(sortedPhotoBlocks([]byte(note), settings)); sorted != want {
+		t.Errorf("sortedPhotoBlocks() =\n%s\nwant\n%s", sorted, want)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sourceFolderSettings configure an additional folder that is imported
+// like the original photo path. Photos from it get their own image prefix
+// and can go to a subfolder of the target photo path, so photos from
+// different devices stay distinguishable and can't collide. Only the
+// photos whose names match the pattern are imported, and the section puts
+// them under their own heading in the note.
+type sourceFolderSettings struct {
+	Name        string `yaml:"name"`
+	Path        string `yaml:"path"`
+	ImagePrefix string `yaml:"image_prefix"`
+	Subfolder   string `yaml:"subfolder"`
+	Pattern     string `yaml:"pattern"`
+	Section     string `yaml:"section"`
+	ReadOnly    bool   `yaml:"read_only"`
+}
+
+// inboxSettings are shared by the remote sources. Photos are copied to the
+// original photo path unless an inbox is given, which should be the path
+// of one of the source folders.
+type inboxSettings struct {
+	Inbox string `yaml:"inbox"`
+}
+
+// sourceFolders returns the settings for importing each source folder,
+// starting with the original photo path.
+func sourceFolders(settings *appSettings) []*appSettings {
+	result := []*appSettings{settings}
+	for _, source := range settings.Sources {
+		folder := *settings
+		folder.Sources = nil
+		folder.SourceName = source.Name
+		folder.OriginalPhotoPath = source.Path
+		folder.SourcePattern = source.Pattern
+		folder.SourceSection = source.Section
+		folder.SourceReadOnly = settings.SourceReadOnly || source.ReadOnly
+		if source.ImagePrefix != "" {
+			folder.ImagePrefix = source.ImagePrefix
+		}
+		if source.Subfolder != "" {
+			folder.TargetPhotoPath = path.Join(settings.TargetPhotoPath, source.Subfolder)
+		}
+		result = append(result, &folder)
+	}
+	return result
+}
+
+// sourceName returns the name of the source folder, "default" for the
+// original photo path.
+func sourceName(settings *appSettings) string {
+	if settings.SourceName == "" {
+		return "default"
+	}
+	return settings.SourceName
+}
+
+// matchSourcePattern leaves out the photos whose names, before they were
+// renamed, don't match the pattern of the source folder.
+func matchSourcePattern(photos map[string][]string, scan *folderScan) map[string][]string {
+	settings := scan.settings
+	pattern, err := regexp.Compile(settings.SourcePattern)
+	if err != nil {
+		logErrorf("unable to use the pattern of %s: %s", sourceName(settings), err)
+		return photos
+	}
+
+	result := make(map[string][]string)
+	for date, datePhotos := range photos {
+		for _, photo := range datePhotos {
+			if pattern.MatchString(originalName(photo, scan.renamed)) {
+				result[date] = append(result[date], photo)
+			}
+		}
+	}
+	return result
+}
+
+// photoSectionName returns the section the scripts routed the photo to or
+// the section of its source folder.
+func photoSectionName(photo string, scan *folderScan) string {
+	if section, ok := scan.sections[photo]; ok {
+		return section
+	}
+	return scan.settings.SourceSection
+}
+
+// folderForInbox returns the settings of the source folder a remote source
+// copies its photos to.
+func folderForInbox(inbox string, settings *appSettings) *appSettings {
+	if inbox == "" {
+		return settings
+	}
+	for _, folder := range sourceFolders(settings) {
+		if path.Clean(folder.OriginalPhotoPath) == path.Clean(inbox) {
+			return folder
+		}
+	}
+	folder := *settings
+	folder.OriginalPhotoPath = inbox
+	return &folder
+}
+
+func isSourceFolder(folder string, settings *appSettings) bool {
+	for _, source := range sourceFolders(settings) {
+		if path.Clean(source.OriginalPhotoPath) == path.Clean(folder) {
+			return true
+		}
+	}
+	return false
+}
+
+type photoSource struct {
+	name   string
+	inbox  string
+	ingest func(settings *appSettings) (int, error)
+}
+
+// ingestSources pulls photos from the configured remote sources into the
+// original photo path, where they are picked up by checkPhotos like any
+// other synced photo. It returns the number of sources that failed.
+func ingestSources(settings *appSettings) int {
+	sources := make([]photoSource, 0)
+	if settings.Signal != nil {
+		sources = append(sources, photoSource{"Signal", settings.Signal.Inbox, importSignalMessages})
+	}
+	if settings.Matrix != nil {
+		sources = append(sources, photoSource{"Matrix", settings.Matrix.Inbox, importMatrixImages})
+	}
+	if settings.Telegram != nil {
+		sources = append(sources, photoSource{"Telegram", settings.Telegram.Inbox, importTelegramPhotos})
+	}
+	if settings.IMAP != nil {
+		sources = append(sources, photoSource{"IMAP", settings.IMAP.Inbox, importIMAPMessages})
+	}
+	if settings.Storage != nil && settings.Storage.Original != nil {
+		sources = append(sources, photoSource{"the original store", settings.Storage.Original.Inbox, importStoredPhotos})
+	}
+	if settings.Removable != nil {
+		sources = append(sources, photoSource{"removable volumes", settings.Removable.Inbox, importRemovableVolumes})
+	}
+	if settings.Camera != nil {
+		sources = append(sources, photoSource{"camera", settings.Camera.Inbox, importCameraPhotos})
+	}
+	sources = append(sources, pluginSources(settings)...)
+
+	failures := 0
+	for _, source := range sources {
+		count, err := source.ingest(folderForInbox(source.inbox, settings))
+		if err != nil {
+			logErrorf("unable to import photos from %s: %s", source.name, err)
+			failures++
+		}
+		if count > 0 {
+			logInfof("imported %d photos from %s", count, source.name)
+		}
+	}
+	return failures
+}
+
+// freePhotoName returns a file name for a photo of the given date that is
+// not taken in the original photo path nor among the moved photos.
+func freePhotoName(date time.Time, ext string, settings *appSettings) (string, error) {
+	return freeName(date.Format("2006-01-02"), "-", ext, settings, nil)
+}
+
+// createPhotoFile creates a new file in the original photo path under a
+// free name of the date. Photos uploaded at the same time get the same free
+// name, so the file is created only if it doesn't exist and the next free
+// name is tried when another upload took it first.
+func createPhotoFile(date time.Time, ext string, settings *appSettings) (*os.File, string, error) {
+	for i := 0; i < 100; i++ {
+		name, err := freePhotoName(date, ext, settings)
+		if err != nil {
+			return nil, "", err
+		}
+		target := path.Join(settings.OriginalPhotoPath, name)
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to create %s: %v", target, err)
+		}
+		return file, name, nil
+	}
+	return nil, "", fmt.Errorf("no free file name left for %s", date.Format("2006-01-02"))
+}
+
+func freeName(day string, separator string, ext string, settings *appSettings, reserved map[string]bool) (string, error) {
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("%s.%s", day, ext)
+		if i > 0 {
+			name = fmt.Sprintf("%s%s%02d.%s", day, separator, i, ext)
+		}
+		if reserved[name] {
+			continue
+		}
+		if fileExists(path.Join(settings.OriginalPhotoPath, name)) {
+			continue
+		}
+		if settings.Travel != nil && fileExists(path.Join(travelCollectPath(settings), name)) {
+			continue
+		}
+		if fileExists(path.Join(settings.TargetPhotoPath, targetName(name, settings))) {
+			continue
+		}
+		if settings.LargeFilePath != "" && fileExists(path.Join(settings.LargeFilePath, targetName(name, settings))) {
+			continue
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("no free file name left for %s", day)
+}
+
+func copyFile(source string, target string) error {
+	inputFile, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %v", source, err)
+	}
+	defer inputFile.Close()
+
+	outputFile, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", target, err)
+	}
+
+	if _, err := io.Copy(outputFile, inputFile); err != nil {
+		outputFile.Close()
+		return fmt.Errorf("unable to copy %s to %s: %v", source, target, err)
+	}
+	return outputFile.Close()
+}
+
+// importHashedPhoto copies a photo into the original photo path unless its
+// hash is already in the index. The index file is updated right away so an
+// interrupted import does not copy the same photos again.
+func importHashedPhoto(filePath string, date time.Time, ext string, index map[string]bool, indexPath string, settings *appSettings) (bool, error) {
+	hash, err := fileSHA256(filePath)
+	if err != nil {
+		return false, err
+	}
+	if index[hash] {
+		return false, nil
+	}
+
+	name, err := freePhotoName(date, ext, settings)
+	if err != nil {
+		return false, err
+	}
+	if err := copyFile(filePath, path.Join(settings.OriginalPhotoPath, name)); err != nil {
+		return false, err
+	}
+	if err := appendHashIndex(indexPath, hash); err != nil {
+		return false, err
+	}
+	index[hash] = true
+	return true, nil
+}
+
+func readHashIndex(indexPath string) (map[string]bool, error) {
+	index := make(map[string]bool)
+	f, err := os.Open(indexPath)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read hash index %s: %v", indexPath, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			index[line] = true
+		}
+	}
+	return index, scanner.Err()
+}
+
+func appendHashIndex(indexPath string, hash string) error {
+	f, err := os.OpenFile(indexPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open hash index %s: %v", indexPath, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(hash + "\n"); err != nil {
+		return fmt.Errorf("unable to append to hash index: %v", err)
+	}
+	return nil
+}
+
+// parseTimeOffset parses the offset that corrects the clock of a camera,
+// such as "+2h13m" or "-45m".
+func parseTimeOffset(offset string) (time.Duration, error) {
+	if offset == "" {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(offset)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time_offset %s: %v", offset, err)
+	}
+	return duration, nil
+}
+
+// moveFile renames the file, or copies and deletes it when the target is
+// on another file system.
+func moveFile(source string, target string) error {
+	if err := os.Rename(source, target); err == nil {
+		return nil
+	}
+	if err := copyFile(source, target); err != nil {
+		return err
+	}
+	if err := os.Remove(source); err != nil {
+		return fmt.Errorf("unable to delete %s: %v", source, err)
+	}
+	return nil
+}
+
+func fileSHA256(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s: %v", filePath, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("unable to hash %s: %v", filePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// photoExtension returns the normalized extension of a supported photo file
+// or an empty string when the file is not a supported photo.
+func photoExtension(name string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	switch ext {
+	case "jpg", "jpeg":
+		return "jpg"
+	case "png":
+		return "png"
+	}
+	return ""
+}
+
+func extensionForContentType(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return "jpg"
+	case "image/png":
+		return "png"
+	}
+	return ""
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var processedBucket = []byte("processed")
+
+// processedFile records a photo that was imported, keyed by the SHA-256 of
+// its content.
+type processedFile struct {
+	Hash       string    `json:"hash"`
+	Source     string    `json:"source"`
+	Original   string    `json:"original"`
+	Target     string    `json:"target"`
+	Note       string    `json:"note"`
+	Date       string    `json:"date"`
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// openState opens the state database. It is only kept open for a single
+// read or write, so the state command works while an import runs.
+func openState(settings *appSettings) (*bolt.DB, error) {
+	db, err := bolt.Open(settings.StatePath, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the state database %s: %v", settings.StatePath, err)
+	}
+	return db, nil
+}
+
+// skipProcessedPhotos hashes the scanned photos and leaves out the photos
+// whose content was already imported and is still in the vault, is in the
+// vault index, or appears twice in the folder. Those are left in the
+// source folder.
+func skipProcessedPhotos(photos map[string][]string, scan *folderScan) map[string][]string {
+	settings := scan.settings
+	db, err := openState(settings)
+	if err != nil {
+		logErrorf("unable to check for processed photos: %s", err)
+		return photos
+	}
+	defer db.Close()
+	if settings.VaultIndex != nil && len(photos) > 0 {
+		updateVaultIndex(db, settings)
+	}
+
+	scan.hashes = make(map[string]string)
+	seen := make(map[string]string)
+	dates := make([]string, 0, len(photos))
+	for date := range photos {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+
+	result := make(map[string][]string)
+	for _, date := range dates {
+		for _, photo := range photos[date] {
+			hash, err := fileSHA256(photo)
+			if err != nil {
+				logFields{"source": photo}.errorf("unable to hash %s: %s", photo, err)
+				result[date] = append(result[date], photo)
+				continue
+			}
+			if first, ok := seen[hash]; ok {
+				logFields{"source": photo}.infof("skipped %s, it is the same photo as %s", photo, path.Base(first))
+				markPlannedAction(scan.planned, originalName(photo, scan.renamed), "duplicate")
+				continue
+			}
+			seen[hash] = photo
+			scan.hashes[photo] = hash
+
+			var record *processedFile
+			var existing string
+			db.View(func(tx *bolt.Tx) error {
+				record = readProcessedFile(tx, hash)
+				if settings.VaultIndex != nil {
+					existing = vaultCopy(tx, hash)
+				}
+				return nil
+			})
+			// A read-only source keeps the photos it imported, so a photo
+			// that was imported once is not imported again even when its
+			// attachment was removed from the vault. Attachments moved to
+			// the target store are not in the vault either.
+			if record != nil && (fileExists(record.Target) || settings.SourceReadOnly || movesToStore(settings)) {
+				logFields{"source": photo, "target": record.Target}.infof("skipped %s, it was imported as %s on %s", photo, path.Base(record.Target), record.ImportedAt.Local().Format("2006-01-02"))
+				markPlannedAction(scan.planned, originalName(photo, scan.renamed), "duplicate")
+				continue
+			}
+			if existing != "" && existing != photo && fileExists(existing) {
+				logFields{"source": photo, "target": existing}.infof("skipped %s, it is already in the vault as %s", photo, existing)
+				markPlannedAction(scan.planned, originalName(photo, scan.renamed), "duplicate")
+				continue
+			}
+			result[date] = append(result[date], photo)
+		}
+	}
+	return result
+}
+
+// recordProcessedPhotos stores the imported photos of a date with their
+// targets and the note they were added to.
+func recordProcessedPhotos(scan *folderScan, date string, photos []string, targets []string, note string) {
+	db, err := openState(scan.settings)
+	if err != nil {
+		logErrorf("unable to record the imported photos: %s", err)
+		return
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(processedBucket)
+		if err != nil {
+			return err
+		}
+		for i, photo := range photos {
+			hash, ok := scan.hashes[photo]
+			if !ok {
+				continue
+			}
+			data, err := json.Marshal(processedFile{
+				Hash:       hash,
+				Source:     photo,
+				Original:   originalName(photo, scan.renamed),
+				Target:     targets[i],
+				Note:       note,
+				Date:       date,
+				ImportedAt: time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(hash), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logErrorf("unable to record the imported photos: %s", err)
+	}
+}
+
+// recordedNotes tells which of the notes the state has photos recorded
+// for, so they were written by an import.
+func recordedNotes(notes map[string]string, settings *appSettings) (map[string]bool, error) {
+	db, err := openState(settings)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	recorded := make(map[string]bool)
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(processedBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key []byte, data []byte) error {
+			var record processedFile
+			if err := json.Unmarshal(data, &record); err != nil {
+				return nil
+			}
+			if _, ok := notes[record.Note]; ok {
+				recorded[record.Note] = true
+			}
+			return nil
+		})
+	})
+	return recorded, err
+}
+
+func readProcessedFile(tx *bolt.Tx, hash string) *processedFile {
+	bucket := tx.Bucket(processedBucket)
+	if bucket == nil {
+		return nil
+	}
+	data := bucket.Get([]byte(hash))
+	if data == nil {
+		return nil
+	}
+	var record processedFile
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil
+	}
+	return &record
+}
+
+// runState implements the state command, which lists the processed
+// photos, optionally only those of the given dates or names.
+func runState(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("state", flag.ExitOnError)
+	output := flags.String("output", "text", "Output format, text or json")
+	flags.Parse(args)
+
+	if settings.StatePath == "" {
+		log.Fatal("state_path is not set")
+	}
+	selected := make(map[string]bool)
+	for _, arg := range flags.Args() {
+		selected[arg] = true
+	}
+
+	db, err := openState(settings)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	records := make([]processedFile, 0)
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(processedBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key []byte, data []byte) error {
+			var record processedFile
+			if err := json.Unmarshal(data, &record); err != nil {
+				return fmt.Errorf("invalid record %s: %v", key, err)
+			}
+			if len(selected) == 0 || selected[record.Date] || selected[record.Original] || selected[path.Base(record.Target)] {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Fatalf("unable to read the state database: %s", err)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Date != records[j].Date {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].Target < records[j].Target
+	})
+
+	if *output == "json" {
+		printJSON(records)
+		return
+	}
+	for _, record := range records {
+		fmt.Printf("%s\t%s\t%s\t%s\n", record.Date, record.Original, record.Target, record.Note)
+	}
+}
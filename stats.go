@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const statsNoteName = "Photo Stats.md"
+
+// monthStats are the photos of a month. The days hold the time of the
+// last photo of each day, the evening photo, in minutes from midnight.
+type monthStats struct {
+	photos int
+	days   map[string]int
+}
+
+// updatePhotoStats rewrites the photo stats note from the attachments of
+// every source folder. The capture time of an attachment is its
+// modification time, which is kept from the original photo. The missed
+// days link to the notes they would have.
+func updatePhotoStats(settings *appSettings) error {
+	months := make(map[string]*monthStats)
+	days := make(map[string]bool)
+	for _, folder := range sourceFolders(settings) {
+		for _, dir := range []string{folder.TargetPhotoPath, folder.LargeFilePath} {
+			if dir == "" {
+				continue
+			}
+			files, err := os.ReadDir(dir)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("unable to read path %s: %v", dir, err)
+			}
+
+			for _, file := range files {
+				if file.IsDir() || !strings.HasPrefix(file.Name(), folder.ImagePrefix) {
+					continue
+				}
+				original := strings.TrimSuffix(strings.TrimPrefix(file.Name(), folder.ImagePrefix), encryptedExtension)
+				date, ok := getDateFromFile(original)
+				if !ok || isMonthKey(date) {
+					continue
+				}
+				info, err := file.Info()
+				if err != nil {
+					return fmt.Errorf("unable to stat %s: %v", file.Name(), err)
+				}
+
+				month, ok := months[date[:7]]
+				if !ok {
+					month = &monthStats{days: make(map[string]int)}
+					months[date[:7]] = month
+				}
+				captured := info.ModTime().Local()
+				minutes := captured.Hour()*60 + captured.Minute()
+				month.photos++
+				if last, ok := month.days[date]; !ok || minutes > last {
+					month.days[date] = minutes
+				}
+				days[date] = true
+			}
+		}
+	}
+
+	content := photoStatsNote(months, days, time.Now(), settings)
+	notePath := path.Join(settings.ObsidianFilePath, statsNoteName)
+	if existing, err := os.ReadFile(notePath); err == nil && string(existing) == content {
+		return nil
+	}
+	return rewriteNote(notePath, settings, func(original []byte) []byte {
+		return []byte(content)
+	})
+}
+
+func photoStatsNote(months map[string]*monthStats, days map[string]bool, now time.Time, settings *appSettings) string {
+	keys := make([]string, 0, len(months))
+	for key := range months {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var note strings.Builder
+	note.WriteString("# Photo Stats\n\n")
+	note.WriteString("| Month | Photos | Days with photos | Missed days | Average evening photo |\n")
+	note.WriteString("| --- | --- | --- | --- | --- |\n")
+	for i := len(keys) - 1; i >= 0; i-- {
+		month := months[keys[i]]
+		minutes := 0
+		for _, last := range month.days {
+			minutes += last
+		}
+		average := minutes / len(month.days)
+		note.WriteString(fmt.Sprintf("| %s | %d | %d | %d | %02d:%02d |\n",
+			keys[i], month.photos, len(month.days), daysInMonthSoFar(keys[i], now)-len(month.days), average/60, average%60))
+	}
+
+	missed := make([]string, 0)
+	for day := now.AddDate(0, 0, -30); !day.After(now); day = day.AddDate(0, 0, 1) {
+		if date := day.Format("2006-01-02"); !days[date] {
+			missed = append(missed, fmt.Sprintf("- %s\n", noteLink(date, settings)))
+		}
+	}
+	note.WriteString("\n## Missed days in the last 30 days\n\n")
+	if len(missed) == 0 {
+		note.WriteString("None.\n")
+	}
+	note.WriteString(strings.Join(missed, ""))
+	return note.String()
+}
+
+// noteLink returns a link to the note of the date, with the date as the
+// text when the note has another name.
+func noteLink(date string, settings *appSettings) string {
+	notes := notesForDates([]string{date}, settings)
+	if len(notes) == 0 {
+		return fmt.Sprintf("[[%s]]", date)
+	}
+	note := strings.TrimSuffix(notes[0], ".md")
+	if note == date {
+		return fmt.Sprintf("[[%s]]", note)
+	}
+	return fmt.Sprintf("[[%s|%s]]", note, date)
+}
+
+// daysInMonthSoFar returns the number of days of the month up to today.
+func daysInMonthSoFar(month string, now time.Time) int {
+	first, err := time.ParseInLocation("2006-01", month, time.Local)
+	if err != nil {
+		return 0
+	}
+	if month == now.Format("2006-01") {
+		return now.Day()
+	}
+	if first.After(now) {
+		return 0
+	}
+	return first.AddDate(0, 1, -1).Day()
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusFileSettings write the status of the imports to a JSON file that a
+// companion Obsidian plugin reads. The path defaults to
+// .obsidian/plugins/diary-automation/status.json in the vault, which is the
+// vault path of obsidian_uri or else the note folder. The file keeps the
+// last imports, 10 by default.
+type statusFileSettings struct {
+	Path    string `yaml:"path"`
+	History int    `yaml:"history"`
+}
+
+const statusFileVersion = 1
+
+// importRun is the status of a single import.
+type importRun struct {
+	Started      time.Time  `json:"started"`
+	Finished     *time.Time `json:"finished,omitempty"`
+	Photos       int        `json:"photos"`
+	NotesCreated int        `json:"notes_created"`
+	Dates        []string   `json:"dates"`
+	Errors       int        `json:"errors"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// statusDocument is the content of the status file.
+type statusDocument struct {
+	Version         int           `json:"version"`
+	Updated         time.Time     `json:"updated"`
+	State           string        `json:"state"`
+	PID             int           `json:"pid"`
+	LastScan        *time.Time    `json:"last_scan,omitempty"`
+	PhotosProcessed int           `json:"photos_processed"`
+	LastError       string        `json:"last_error,omitempty"`
+	RecentErrors    []loggedError `json:"recent_errors,omitempty"`
+	Current         *importRun    `json:"current,omitempty"`
+	History         []importRun   `json:"history"`
+}
+
+// loggedError is one of the recent errors.
+type loggedError struct {
+	Time    string `json:"time"`
+	Message string `json:"message"`
+}
+
+// recentErrorCount is how many errors the status keeps.
+const recentErrorCount = 10
+
+// importStatus follows the imports of the process through the event bus.
+type importStatus struct {
+	sync.Mutex
+	state     string
+	current   *importRun
+	history   []importRun
+	lastScan  *time.Time
+	photos    int
+	lastError string
+	errors    []loggedError
+	loaded    bool
+}
+
+var currentStatus = &importStatus{state: "idle", history: make([]importRun, 0)}
+
+func (s *importStatus) handleEvent(event importEvent) error {
+	s.Lock()
+	defer s.Unlock()
+	switch event.Type {
+	case eventPhotoImported:
+		s.photos++
+	case eventError:
+		s.lastError = event.Message
+		s.errors = append(s.errors, loggedError{event.Time, event.Message})
+		if len(s.errors) > recentErrorCount {
+			s.errors = s.errors[len(s.errors)-recentErrorCount:]
+		}
+	}
+	if s.current == nil {
+		return nil
+	}
+	switch event.Type {
+	case eventPhotoImported:
+		s.current.Photos++
+	case eventNoteCreated:
+		s.current.NotesCreated++
+	case eventError:
+		s.current.Errors++
+		s.current.LastError = event.Message
+	}
+	return nil
+}
+
+// beginImportStatus starts following an import. The first import of the
+// process continues the history of the status file.
+func beginImportStatus(settings *appSettings) {
+	currentStatus.Lock()
+	if !currentStatus.loaded && settings.StatusFile != nil {
+		currentStatus.loaded = true
+		if previous, err := readStatusFile(statusFilePath(settings)); err == nil {
+			currentStatus.history = append(currentStatus.history, previous.History...)
+		}
+	}
+	currentStatus.state = "importing"
+	currentStatus.current = &importRun{Started: time.Now(), Dates: make([]string, 0)}
+	currentStatus.Unlock()
+	writeStatusFile(settings)
+}
+
+// finishImportStatus adds the import with the dates it imported to the
+// history. Imports that found nothing to do are left out, so the polls of
+// the watch don't rotate the imports out of it.
+func finishImportStatus(settings *appSettings, imported map[string][]string) {
+	currentStatus.Lock()
+	finished := time.Now()
+	currentStatus.lastScan = &finished
+	if run := currentStatus.current; run != nil && (len(imported) > 0 || run.Photos > 0 || run.Errors > 0) {
+		run.Finished = &finished
+		for date := range imported {
+			run.Dates = append(run.Dates, date)
+		}
+		sort.Strings(run.Dates)
+		currentStatus.history = append([]importRun{*run}, currentStatus.history...)
+		if keep := statusHistory(settings); len(currentStatus.history) > keep {
+			currentStatus.history = currentStatus.history[:keep]
+		}
+	}
+	currentStatus.current = nil
+	currentStatus.state = "idle"
+	currentStatus.Unlock()
+	writeStatusFile(settings)
+}
+
+// stopImportStatus tells the plugin that the watch is no longer running.
+func stopImportStatus(settings *appSettings) {
+	currentStatus.Lock()
+	currentStatus.state = "stopped"
+	currentStatus.Unlock()
+	writeStatusFile(settings)
+}
+
+func statusHistory(settings *appSettings) int {
+	if settings.StatusFile == nil || settings.StatusFile.History <= 0 {
+		return 10
+	}
+	return settings.StatusFile.History
+}
+
+// statusSnapshot returns a copy of the status.
+func statusSnapshot() statusDocument {
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	document := statusDocument{
+		Version:         statusFileVersion,
+		Updated:         time.Now(),
+		State:           currentStatus.state,
+		PID:             os.Getpid(),
+		LastScan:        currentStatus.lastScan,
+		PhotosProcessed: currentStatus.photos,
+		LastError:       currentStatus.lastError,
+		RecentErrors:    append([]loggedError{}, currentStatus.errors...),
+		History:         append([]importRun{}, currentStatus.history...),
+	}
+	if currentStatus.current != nil {
+		current := *currentStatus.current
+		document.Current = &current
+	}
+	return document
+}
+
+// serveHealth answers the liveness checks, which only need the process to
+// answer.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
+}
+
+// serveStatus returns the status as the status file has it, with the time
+// of the last scan, the photos processed since the start and the last
+// error.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statusSnapshot())
+}
+
+func statusFilePath(settings *appSettings) string {
+	if settings.StatusFile.Path != "" {
+		return settings.StatusFile.Path
+	}
+	vault := settings.ObsidianFilePath
+	if settings.ObsidianURI != nil && settings.ObsidianURI.VaultPath != "" {
+		vault = settings.ObsidianURI.VaultPath
+	}
+	return path.Join(vault, ".obsidian", "plugins", "diary-automation", "status.json")
+}
+
+// writeStatusFile replaces the status file, through a temporary file so the
+// plugin never reads half of it.
+func writeStatusFile(settings *appSettings) {
+	if settings.StatusFile == nil {
+		return
+	}
+	filePath := statusFilePath(settings)
+	if err := replaceStatusFile(filePath, statusSnapshot()); err != nil {
+		logWarnf("unable to write the status file %s: %s", filePath, err)
+	}
+}
+
+func readStatusFile(filePath string) (statusDocument, error) {
+	var document statusDocument
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return document, err
+	}
+	err = json.Unmarshal(data, &document)
+	return document, err
+}
+
+func replaceStatusFile(filePath string, document statusDocument) error {
+	data, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", path.Dir(filePath), err)
+	}
+	temp := filePath + ".tmp"
+	if err := os.WriteFile(temp, append(data, '\n'), 0644); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return os.Rename(temp, filePath)
+}
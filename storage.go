@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// storageSettings configure keeping the photos in a store outside of the
+// local folders. Photos in the original store are moved to the inbox, or
+// the original photo path, before the import. Attachments are copied to
+// the target store after the import and, unless keep_local is set,
+// removed from the vault with their embeds pointing to the store.
+type storageSettings struct {
+	Original *storeSettings `yaml:"original"`
+	Target   *storeSettings `yaml:"target"`
+}
+
+// storeSettings configure one store, a local path or an S3 compatible
+// bucket. URL is the address the embeds of the target store point to,
+// the object URL of the bucket or a file URL by default.
+type storeSettings struct {
+	Path      string      `yaml:"path"`
+	S3        *s3Settings `yaml:"s3"`
+	URL       string      `yaml:"url"`
+	KeepLocal bool        `yaml:"keep_local"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+// photoStore is where files are kept outside of the local folders, named
+// by their path in the store.
+type photoStore interface {
+	list() ([]string, error)
+	fetch(name string, target string) error
+	store(filePath string, name string) error
+	remove(name string) error
+	exists(name string) (bool, error)
+	url(name string) string
+}
+
+type folderStore struct {
+	dir     string
+	baseURL string
+}
+
+type bucketStore struct {
+	settings *s3Settings
+	baseURL  string
+}
+
+func openStore(settings *storeSettings) (photoStore, error) {
+	switch {
+	case settings.S3 != nil:
+		return &bucketStore{settings: settings.S3, baseURL: settings.URL}, nil
+	case settings.Path != "":
+		return &folderStore{dir: settings.Path, baseURL: settings.URL}, nil
+	}
+	return nil, fmt.Errorf("neither path nor s3 is set")
+}
+
+func validateStorage(storage *storageSettings) error {
+	for name, store := range map[string]*storeSettings{"original": storage.Original, "target": storage.Target} {
+		if store == nil {
+			continue
+		}
+		if _, err := openStore(store); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *folderStore) list() ([]string, error) {
+	names := make([]string, 0)
+	err := filepath.WalkDir(s.dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		name, err := filepath.Rel(s.dir, filePath)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return names, nil
+	}
+	if err != nil {
+		return names, fmt.Errorf("unable to list %s: %v", s.dir, err)
+	}
+	return names, nil
+}
+
+func (s *folderStore) fetch(name string, target string) error {
+	return copyFile(path.Join(s.dir, name), target)
+}
+
+func (s *folderStore) store(filePath string, name string) error {
+	target := path.Join(s.dir, name)
+	if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+		return fmt.Errorf("unable to create %s: %v", path.Dir(target), err)
+	}
+	return copyFile(filePath, target)
+}
+
+func (s *folderStore) remove(name string) error {
+	if err := os.Remove(path.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("unable to delete %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *folderStore) exists(name string) (bool, error) {
+	return fileExists(path.Join(s.dir, name)), nil
+}
+
+func (s *folderStore) url(name string) string {
+	if s.baseURL != "" {
+		return strings.TrimRight(s.baseURL, "/") + "/" + s3Escape(name)
+	}
+	return fileURL(path.Join(s.dir, name))
+}
+
+func (s *bucketStore) list() ([]string, error) {
+	keys, err := s3List(s.settings)
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			names = append(names, strings.TrimPrefix(key, s.settings.Prefix))
+		}
+	}
+	return names, err
+}
+
+func (s *bucketStore) fetch(name string, target string) error {
+	return s3Download(s.settings, s.settings.Prefix+name, target)
+}
+
+func (s *bucketStore) store(filePath string, name string) error {
+	return s3Upload(s.settings, filePath, s.settings.Prefix+name)
+}
+
+func (s *bucketStore) remove(name string) error {
+	return s3Delete(s.settings, s.settings.Prefix+name)
+}
+
+func (s *bucketStore) exists(name string) (bool, error) {
+	probe := *s.settings
+	probe.Prefix = s.settings.Prefix + name
+	keys, err := s3List(&probe)
+	if err != nil {
+		return false, err
+	}
+	for _, key := range keys {
+		if key == probe.Prefix {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *bucketStore) url(name string) string {
+	if s.baseURL != "" {
+		return strings.TrimRight(s.baseURL, "/") + "/" + s3Escape(name)
+	}
+	return s3ObjectURL(s.settings, s.settings.Prefix+name)
+}
+
+// importStoredPhotos moves the files of the original store to the
+// original photo path, where the import picks them up like synced photos.
+// Files whose name is taken there are left in the store.
+func importStoredPhotos(settings *appSettings) (int, error) {
+	store, err := openStore(settings.Storage.Original)
+	if err != nil {
+		return 0, err
+	}
+	names, err := store.list()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, name := range names {
+		target := path.Join(settings.OriginalPhotoPath, path.Base(name))
+		if fileExists(target) {
+			logWarnf("leaving %s in the store, %s already exists", name, target)
+			continue
+		}
+		if err := store.fetch(name, target); err != nil {
+			os.Remove(target)
+			return count, err
+		}
+		if err := store.remove(name); err != nil {
+			os.Remove(target)
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// movesToStore tells whether the attachments leave the vault for the
+// target store.
+func movesToStore(settings *appSettings) bool {
+	return settings.Storage != nil && settings.Storage.Target != nil && !settings.Storage.Target.KeepLocal
+}
+
+// storeAttachments copies the attachments and their sidecars to the target
+// store and, unless keep_local is set, removes them from the vault and
+// points their embeds in the note to the store.
+func storeAttachments(targets []string, diaryFilePath string, settings *appSettings) {
+	config := settings.Storage.Target
+	store, err := openStore(config)
+	if err != nil {
+		logErrorf("unable to open the target store: %s", err)
+		return
+	}
+
+	links := make(map[string]string)
+	stored := make([]string, 0)
+	for _, target := range targets {
+		name, err := filepath.Rel(settings.TargetPhotoPath, target)
+		if err != nil || strings.HasPrefix(name, "..") {
+			name = path.Base(target)
+		}
+		name, err = freeStoreName(store, filepath.ToSlash(name))
+		if err != nil {
+			logFields{"target": target}.errorf("unable to store %s, keeping it in the vault: %s", target, err)
+			continue
+		}
+		files := []string{target}
+		for _, ext := range []string{".json", ".xmp"} {
+			if fileExists(target + ext) {
+				files = append(files, target+ext)
+			}
+		}
+
+		err = nil
+		for _, file := range files {
+			if err = store.store(file, name+strings.TrimPrefix(file, target)); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			logFields{"target": target}.errorf("unable to store %s, keeping it in the vault: %s", name, err)
+			continue
+		}
+		if !config.KeepLocal {
+			links[normalizeName(path.Base(target))] = store.url(name)
+			stored = append(stored, files...)
+		}
+	}
+	if len(links) == 0 {
+		return
+	}
+
+	err = rewriteNote(diaryFilePath, settings, func(note []byte) []byte {
+		return storedLinks(note, links)
+	})
+	if err != nil {
+		logFields{"note": diaryFilePath}.errorf("unable to link the stored attachments, keeping them in the vault: %s", err)
+		return
+	}
+	for _, file := range stored {
+		if err := os.Remove(file); err != nil {
+			logFields{"target": file}.errorf("unable to delete %s: %s", file, err)
+		}
+	}
+}
+
+// freeStoreName returns the name, or the name with a number added, that
+// is not taken in the store. Attachments that left the vault free their
+// names for later photos of the same date.
+func freeStoreName(store photoStore, name string) (string, error) {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 100; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+		taken, err := store.exists(candidate)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name left for %s", name)
+}
+
+// storedLinks turns the embeds and links of the attachments into Markdown
+// images and links to their addresses, keeping the captions.
+func storedLinks(note []byte, links map[string]string) []byte {
+	return attachmentLinkPattern.ReplaceAllFunc(note, func(link []byte) []byte {
+		match := attachmentLinkPattern.FindSubmatch(link)
+		address, ok := links[normalizeName(string(match[1]))]
+		if !ok {
+			return link
+		}
+		text := strings.TrimPrefix(string(match[2]), "|")
+		if link[0] == '!' {
+			return []byte(fmt.Sprintf("![%s](<%s>)", text, address))
+		}
+		if text == "" {
+			text = string(match[1])
+		}
+		return []byte(fmt.Sprintf("[%s](<%s>)", text, address))
+	})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramSettings configure importing photos sent to a Telegram bot from
+// the allowed chats. The offset of the next update is stored between runs
+// so every photo is imported only once.
+type telegramSettings struct {
+	BotToken    string  `yaml:"bot_token"`
+	ChatIDs     []int64 `yaml:"chat_ids"`
+	Reaction    string  `yaml:"reaction"`
+	MessageText bool    `yaml:"message_text"`
+	OffsetPath  string  `yaml:"offset_path"`
+	APIURL      string  `yaml:"api_url"`
+
+	inboxSettings `yaml:",inline"`
+}
+
+type telegramPhotoSize struct {
+	FileID   string `json:"file_id"`
+	FileSize int64  `json:"file_size"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+type telegramMessage struct {
+	MessageID    int64  `json:"message_id"`
+	Date         int64  `json:"date"`
+	MediaGroupID string `json:"media_group_id"`
+	Chat         struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Photo    []telegramPhotoSize `json:"photo"`
+	Document *struct {
+		FileID   string `json:"file_id"`
+		MimeType string `json:"mime_type"`
+	} `json:"document"`
+	Caption string `json:"caption"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+var telegramClient = &http.Client{Timeout: 60 * time.Second}
+
+// importTelegramPhotos fetches the new updates of the bot and copies the
+// photos of the allowed chats into the original photo path, named by the
+// message date. Captions are kept in an XMP sidecar of the photo, which
+// the import reads with xmp.captions, or with message_text as the body of
+// the entry. Each imported photo is acknowledged with a reaction.
+func importTelegramPhotos(settings *appSettings) (int, error) {
+	telegram := settings.Telegram
+	if telegram.OffsetPath == "" {
+		return 0, fmt.Errorf("telegram offset_path is not set")
+	}
+	if len(telegram.ChatIDs) == 0 {
+		return 0, fmt.Errorf("telegram chat_ids is not set")
+	}
+
+	offset := int64(0)
+	if data, err := os.ReadFile(telegram.OffsetPath); err == nil {
+		offset, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	query := url.Values{"timeout": {"0"}, "allowed_updates": {`["message"]`}}
+	if offset > 0 {
+		query.Set("offset", strconv.FormatInt(offset, 10))
+	}
+	var updates []telegramUpdate
+	if err := telegramRequest(telegram, "getUpdates?"+query.Encode(), nil, &updates); err != nil {
+		return 0, err
+	}
+
+	// The photos of an album are sent as separate messages with the
+	// caption only on the first one.
+	groupCaptions := make(map[string]string)
+	for _, update := range updates {
+		if message := update.Message; message != nil && message.MediaGroupID != "" && message.Caption != "" {
+			groupCaptions[message.MediaGroupID] = message.Caption
+		}
+	}
+
+	count := 0
+	start := offset
+	defer func() {
+		if offset == start {
+			return
+		}
+		if err := os.WriteFile(telegram.OffsetPath, []byte(strconv.FormatInt(offset, 10)), 0600); err != nil {
+			logErrorf("unable to store the Telegram offset: %s", err)
+		}
+	}()
+	for _, update := range updates {
+		message := update.Message
+		if message == nil || !telegramChatAllowed(message.Chat.ID, telegram) {
+			offset = update.UpdateID + 1
+			continue
+		}
+
+		fileID, ext := telegramFile(message)
+		if fileID == "" {
+			offset = update.UpdateID + 1
+			continue
+		}
+		name, err := freePhotoName(time.Unix(message.Date, 0).Local(), ext, settings)
+		if err != nil {
+			return count, err
+		}
+		target := path.Join(settings.OriginalPhotoPath, name)
+		if err := downloadTelegramFile(telegram, fileID, target); err != nil {
+			return count, err
+		}
+		count++
+		offset = update.UpdateID + 1
+
+		caption := message.Caption
+		if telegram.MessageText {
+			// The text of an album is written once, with the photo it
+			// was sent with.
+			if err := writeMessageText(target, caption); err != nil {
+				logFields{"source": target}.errorf("%s", err)
+			}
+			caption = ""
+		} else if caption == "" {
+			caption = groupCaptions[message.MediaGroupID]
+		}
+		if caption != "" {
+			if settings.XMP == nil || !settings.XMP.Captions {
+				logDebugf("leaving out the caption of %s without xmp.captions", name)
+			} else if err := writeXMPCaption(target+".xmp", caption); err != nil {
+				logFields{"source": target}.errorf("unable to keep the caption of %s: %s", name, err)
+			}
+		}
+
+		if err := reactToTelegramMessage(telegram, message); err != nil {
+			logWarnf("unable to react to the Telegram message %d: %s", message.MessageID, err)
+		}
+	}
+	return count, nil
+}
+
+func telegramChatAllowed(chatID int64, settings *telegramSettings) bool {
+	for _, allowed := range settings.ChatIDs {
+		if allowed == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// telegramFile returns the file of the photo in the message and its
+// extension: the largest size of a photo, which Telegram sends as JPEG, or
+// an image sent as a file.
+func telegramFile(message *telegramMessage) (string, string) {
+	if len(message.Photo) > 0 {
+		largest := message.Photo[0]
+		for _, size := range message.Photo[1:] {
+			if size.Width*size.Height > largest.Width*largest.Height {
+				largest = size
+			}
+		}
+		return largest.FileID, "jpg"
+	}
+	if message.Document != nil {
+		if ext := extensionForContentType(message.Document.MimeType); ext != "" {
+			return message.Document.FileID, ext
+		}
+	}
+	return "", ""
+}
+
+func downloadTelegramFile(settings *telegramSettings, fileID string, target string) error {
+	var file struct {
+		FilePath string `json:"file_path"`
+	}
+	if err := telegramRequest(settings, "getFile?"+url.Values{"file_id": {fileID}}.Encode(), nil, &file); err != nil {
+		return err
+	}
+
+	resp, err := telegramClient.Get(telegramAPIURL(settings) + "/file/bot" + settings.BotToken + "/" + file.FilePath)
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %v", file.FilePath, telegramError(err, settings))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download %s: %s", file.FilePath, resp.Status)
+	}
+
+	outputFile, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", target, err)
+	}
+	if _, err := io.Copy(outputFile, resp.Body); err != nil {
+		outputFile.Close()
+		return fmt.Errorf("unable to write %s: %v", target, err)
+	}
+	return outputFile.Close()
+}
+
+func reactToTelegramMessage(settings *telegramSettings, message *telegramMessage) error {
+	reaction := settings.Reaction
+	if reaction == "" {
+		reaction = "👍"
+	}
+	body := map[string]interface{}{
+		"chat_id":    message.Chat.ID,
+		"message_id": message.MessageID,
+		"reaction":   []map[string]string{{"type": "emoji", "emoji": reaction}},
+	}
+	return telegramRequest(settings, "setMessageReaction", body, nil)
+}
+
+func telegramAPIURL(settings *telegramSettings) string {
+	if settings.APIURL == "" {
+		return "https://api.telegram.org"
+	}
+	return strings.TrimRight(settings.APIURL, "/")
+}
+
+// telegramError leaves the bot token, which is part of the URL, out of the
+// error.
+func telegramError(err error, settings *telegramSettings) error {
+	return fmt.Errorf("%s", strings.ReplaceAll(err.Error(), settings.BotToken, "<token>"))
+}
+
+func telegramRequest(settings *telegramSettings, method string, body interface{}, result interface{}) error {
+	name := method
+	if i := strings.Index(name, "?"); i >= 0 {
+		name = name[:i]
+	}
+
+	var resp *http.Response
+	var err error
+	endpoint := telegramAPIURL(settings) + "/bot" + settings.BotToken + "/" + method
+	if body != nil {
+		data, marshalErr := json.Marshal(body)
+		if marshalErr != nil {
+			return fmt.Errorf("failed to marshal %s request: %v", name, marshalErr)
+		}
+		resp, err = telegramClient.Post(endpoint, "application/json", bytes.NewReader(data))
+	} else {
+		resp, err = telegramClient.Get(endpoint)
+	}
+	if err != nil {
+		return fmt.Errorf("%s request failed: %v", name, telegramError(err, settings))
+	}
+	defer resp.Body.Close()
+
+	var response telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to unmarshal %s response: %v", name, err)
+	}
+	if !response.OK {
+		return fmt.Errorf("%s failed: %s", name, response.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("failed to unmarshal %s result: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// runTemplate handles the template subcommands. "template test" prints the
+// text an import would add to a note for sample photos without touching the
+// vault.
+func runTemplate(settings *appSettings, args []string) {
+	if len(args) == 0 || args[0] != "test" {
+		log.Fatal("usage: template test [-date YYYY-MM-DD] [-photos N] [-existing] [-events]")
+	}
+
+	flags := flag.NewFlagSet("template test", flag.ExitOnError)
+	date := flags.String("date", time.Now().Format("2006-01-02"), "Date of the rendered note")
+	photos := flags.Int("photos", 3, "Number of sample photos")
+	existing := flags.Bool("existing", false, "Render the section added to an existing note")
+	events := flags.Bool("events", false, "Fetch the calendar events of the date")
+	flags.Parse(args[1:])
+
+	if _, err := parseDateKey(*date); err != nil {
+		log.Fatalf("invalid date %s: %s", *date, err)
+	}
+
+	sample := *settings
+	if !*events {
+		sample.CalDAV = nil
+	}
+
+	samplePhotos := make([]notePhoto, 0, *photos)
+	for i := 0; i < *photos; i++ {
+		name := fmt.Sprintf("%s.jpg", *date)
+		if i > 0 {
+			name = fmt.Sprintf("%s-%02d.jpg", *date, i)
+		}
+		samplePhotos = append(samplePhotos, notePhoto{path: path.Join(sample.OriginalPhotoPath, name), original: name, settings: &sample})
+	}
+
+	content := noteContent(*date, samplePhotos, *existing, 0, &sample)
+	os.Stdout.WriteString(formatForNote("", content, &sample))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timingSettings configure the report of the slowest files of an import.
+// Files that take longer than the warning time are always logged as
+// warnings, 60 seconds by default.
+type timingSettings struct {
+	Slowest     int `yaml:"slowest"`
+	WarnSeconds int `yaml:"warn_seconds"`
+}
+
+// fileTiming is how long a file took in each stage of an import, like
+// conversion, metadata, transform, scripts and move.
+type fileTiming struct {
+	File   string
+	Total  time.Duration
+	Stages map[string]time.Duration
+}
+
+var fileTimings = struct {
+	sync.Mutex
+	files map[string]*fileTiming
+}{files: make(map[string]*fileTiming)}
+
+// timeFileStage adds the time since start to the stage of the file.
+func timeFileStage(file string, stage string, start time.Time) {
+	elapsed := time.Since(start)
+	fileTimings.Lock()
+	defer fileTimings.Unlock()
+	timing, ok := fileTimings.files[file]
+	if !ok {
+		timing = &fileTiming{File: file, Stages: make(map[string]time.Duration)}
+		fileTimings.files[file] = timing
+	}
+	timing.Total += elapsed
+	timing.Stages[stage] += elapsed
+}
+
+func resetFileTimings() {
+	fileTimings.Lock()
+	defer fileTimings.Unlock()
+	fileTimings.files = make(map[string]*fileTiming)
+}
+
+// slowestFiles returns up to count files of the import, the slowest first.
+func slowestFiles(count int) []fileTiming {
+	fileTimings.Lock()
+	defer fileTimings.Unlock()
+	timings := make([]fileTiming, 0, len(fileTimings.files))
+	for _, timing := range fileTimings.files {
+		timings = append(timings, *timing)
+	}
+	sort.Slice(timings, func(i, j int) bool {
+		if timings[i].Total != timings[j].Total {
+			return timings[i].Total > timings[j].Total
+		}
+		return timings[i].File < timings[j].File
+	})
+	if count >= 0 && len(timings) > count {
+		timings = timings[:count]
+	}
+	return timings
+}
+
+// describeStages lists the stages of the file, the slowest first, like
+// "move 9m2s, metadata 1.2s".
+func describeStages(timing fileTiming) string {
+	stages := make([]string, 0, len(timing.Stages))
+	for stage := range timing.Stages {
+		stages = append(stages, stage)
+	}
+	sort.Slice(stages, func(i, j int) bool {
+		return timing.Stages[stages[i]] > timing.Stages[stages[j]]
+	})
+	parts := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		parts = append(parts, fmt.Sprintf("%s %s", stage, timing.Stages[stage].Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// reportFileTimings logs the files that took longer than the warning time
+// and, with the timing settings, the slowest files of the import.
+func reportFileTimings(settings *appSettings) {
+	config := timingSettings{}
+	if settings.Timing != nil {
+		config = *settings.Timing
+	}
+	if config.WarnSeconds <= 0 {
+		config.WarnSeconds = 60
+	}
+	if config.Slowest <= 0 {
+		config.Slowest = 5
+	}
+
+	warnAfter := time.Duration(config.WarnSeconds) * time.Second
+	for _, timing := range slowestFiles(-1) {
+		if timing.Total < warnAfter {
+			break
+		}
+		logFields{"source": timing.File, "duration": timing.Total.String()}.warnf("%s took %s: %s", timing.File, timing.Total.Round(time.Millisecond), describeStages(timing))
+	}
+
+	report := logFields.debugf
+	if settings.Timing != nil {
+		report = logFields.infof
+	}
+	for i, timing := range slowestFiles(config.Slowest) {
+		report(logFields{"source": timing.File, "duration": timing.Total.String()}, "slowest file %d: %s took %s: %s", i+1, timing.File, timing.Total.Round(time.Millisecond), describeStages(timing))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// travelSettings defer the vault writes while away from home. The photos
+// of the source folders are collected to a local folder instead, and
+// imported in chronological order with the first import back home. Away
+// means the toggle file exists or the home path, the note folder by
+// default, doesn't answer in time.
+type travelSettings struct {
+	CollectPath    string `yaml:"collect_path"`
+	ToggleFile     string `yaml:"toggle_file"`
+	HomePath       string `yaml:"home_path"`
+	TimeoutSeconds int    `yaml:"timeout_seconds"`
+}
+
+// travelling tells whether the vault writes are deferred, and why.
+func travelling(settings *appSettings) (bool, string) {
+	travel := settings.Travel
+	if travel.ToggleFile != "" && fileExists(travel.ToggleFile) {
+		return true, fmt.Sprintf("%s exists", travel.ToggleFile)
+	}
+
+	homePath := travel.HomePath
+	if homePath == "" {
+		homePath = settings.ObsidianFilePath
+	}
+	timeout := time.Duration(travel.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	if err := statWithTimeout(homePath, timeout); err != nil {
+		return true, err.Error()
+	}
+	return false, ""
+}
+
+// statWithTimeout checks that the path exists. Network mounts that are out
+// of reach can hang, so a check that doesn't finish in time fails.
+func statWithTimeout(filePath string, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(filePath)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%s is not reachable: %v", filePath, err)
+		}
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("%s did not answer in %s", filePath, timeout)
+	}
+}
+
+// travelCollectPath returns the folder the photos of the source folder are
+// collected to.
+func travelCollectPath(settings *appSettings) string {
+	return path.Join(settings.Travel.CollectPath, sourceName(settings))
+}
+
+// collectTravelPhotos moves the files of every source folder to their
+// collection folders. A dated photo whose name is taken in the collection
+// gets the next free name of the date, other files are left in the source
+// folder for a later import.
+func collectTravelPhotos(settings *appSettings) (int, error) {
+	collected := 0
+	for _, folder := range sourceFolders(settings) {
+		// Read-only sources keep their photos until the next import home.
+		if folder.SourceReadOnly {
+			continue
+		}
+		files, err := os.ReadDir(folder.OriginalPhotoPath)
+		if err != nil {
+			return collected, fmt.Errorf("unable to read path %s: %v", folder.OriginalPhotoPath, err)
+		}
+		collectPath := travelCollectPath(folder)
+		if err := os.MkdirAll(collectPath, 0755); err != nil {
+			return collected, fmt.Errorf("unable to create %s: %v", collectPath, err)
+		}
+
+		for _, file := range files {
+			if isSkippedEntry(file, folder) {
+				continue
+			}
+			source := path.Join(folder.OriginalPhotoPath, file.Name())
+			target := path.Join(collectPath, file.Name())
+			if date, ok := getDateFromFile(file.Name()); ok && fileExists(target) {
+				name, err := freeName(date, "-", strings.TrimPrefix(path.Ext(file.Name()), "."), folder, nil)
+				if err != nil {
+					return collected, err
+				}
+				target = path.Join(collectPath, name)
+			}
+			if fileExists(target) {
+				logFields{"source": source, "target": target}.warnf("left %s in the source folder, the collection has a file by that name", file.Name())
+				continue
+			}
+			if err := moveFile(source, target); err != nil {
+				return collected, err
+			}
+			collected++
+		}
+	}
+	return collected, nil
+}
+
+// collectedFolders returns the settings for importing the collection
+// folders that hold photos collected while away.
+func collectedFolders(settings *appSettings) []*appSettings {
+	result := make([]*appSettings, 0)
+	for _, folder := range sourceFolders(settings) {
+		collectPath := travelCollectPath(folder)
+		files, err := os.ReadDir(collectPath)
+		if err != nil || len(files) == 0 {
+			continue
+		}
+		collected := *folder
+		collected.OriginalPhotoPath = collectPath
+		result = append(result, &collected)
+	}
+	return result
+}
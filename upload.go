@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const maxUploadSize = 64 << 20
+
+var uploadPage = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Diary upload</title>
+<style>body{font-family:sans-serif;max-width:30em;margin:auto;padding:1em}input,button{display:block;margin:1em 0;font-size:1.2em}</style>
+</head>
+<body>
+{{if .Message}}<p>{{.Message}}</p>{{end}}
+<form method="post" enctype="multipart/form-data">
+<input type="file" name="photo" accept="image/jpeg,image/png" capture="environment" required>
+<input type="date" name="date" value="{{.Date}}" required>
+<button type="submit">Upload</button>
+</form>
+</body>
+</html>
+`))
+
+// runUpload implements the upload command. It serves an upload page under
+// a random path for a limited time and prints the address as a QR code, so
+// a phone can send photos to the original photo path without any app.
+func runUpload(settings *appSettings, args []string) {
+	flags := flag.NewFlagSet("upload", flag.ExitOnError)
+	listen := flags.String("listen", ":8080", "Listen address")
+	host := flags.String("host", "", "Host name or address the phone uses to reach this machine")
+	duration := flags.Duration("duration", time.Hour, "How long the upload page is available")
+	inbox := flags.String("inbox", "", "Source folder for the uploaded photos")
+	flags.Parse(args)
+	settings = folderForInbox(*inbox, settings)
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		log.Fatalf("unable to generate upload token: %s", err)
+	}
+	uploadPath := "/u/" + hex.EncodeToString(token)
+
+	listenHost, port, err := net.SplitHostPort(*listen)
+	if err != nil {
+		log.Fatalf("invalid listen address %s: %s", *listen, err)
+	}
+	if *host == "" {
+		*host = listenHost
+	}
+	if *host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("unable to resolve host name, use -host: %s", err)
+		}
+		*host = hostname
+	}
+	uploadURL := fmt.Sprintf("http://%s:%s%s", *host, port, uploadPath)
+
+	qr, err := qrcode.New(uploadURL, qrcode.Medium)
+	if err != nil {
+		log.Fatalf("unable to create QR code: %s", err)
+	}
+	logInfof("upload page available for %s at %s\n%s", duration.String(), uploadURL, qr.ToSmallString(false))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(uploadPath, func(w http.ResponseWriter, r *http.Request) {
+		handleUpload(w, r, settings)
+	})
+	server := &http.Server{Addr: *listen, Handler: mux}
+	time.AfterFunc(*duration, func() {
+		logInfof("upload page expired")
+		server.Shutdown(context.Background())
+	})
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("unable to serve the upload page: %s", err)
+	}
+}
+
+func handleUpload(w http.ResponseWriter, r *http.Request, settings *appSettings) {
+	page := struct {
+		Date    string
+		Message string
+	}{Date: time.Now().Format("2006-01-02")}
+
+	if r.Method == http.MethodPost {
+		name, err := saveUpload(r, settings)
+		if err != nil {
+			logErrorf("unable to save upload: %s", err)
+			page.Message = "Upload failed: " + err.Error()
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			logInfof("received %s", name)
+			page.Message = "Saved " + name
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	uploadPage.Execute(w, page)
+}
+
+// saveUpload stores an uploaded photo in the original photo path, named by
+// the submitted date.
+func saveUpload(r *http.Request, settings *appSettings) (string, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, maxUploadSize)
+
+	date, err := uploadDate(r.FormValue("date"))
+	if err != nil {
+		return "", err
+	}
+
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		return "", fmt.Errorf("no photo in the request")
+	}
+	defer file.Close()
+	return storeUpload(file, date, settings)
+}
+
+// uploadDate parses the date of an upload, which is today when it is
+// empty.
+func uploadDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Now(), nil
+	}
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %s", value)
+	}
+	return date, nil
+}
+
+// storeUpload writes the uploaded photo to the original photo path under a
+// free name of the date.
+func storeUpload(file io.Reader, date time.Time, settings *appSettings) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("unable to read the photo: %v", err)
+	}
+	ext := extensionForContentType(http.DetectContentType(head[:n]))
+	if ext == "" {
+		return "", fmt.Errorf("only JPEG and PNG photos are supported")
+	}
+
+	outputFile, name, err := createPhotoFile(date, ext, settings)
+	if err != nil {
+		return "", err
+	}
+	target := outputFile.Name()
+	if _, err := io.Copy(outputFile, io.MultiReader(bytes.NewReader(head[:n]), file)); err != nil {
+		outputFile.Close()
+		os.Remove(target)
+		return "", fmt.Errorf("unable to write %s: %v", target, err)
+	}
+	return name, outputFile.Close()
+}
+
+// handleUpload takes a photo for the import of the watch, as the photo
+// field of a form or as the body of the request, with the date in the date
+// field or parameter. It is served when watch.upload_token is set, and the
+// requests need the token as a bearer token or the token parameter.
+func (api *profileAPI) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !api.authorized(w, r, func(config watchSettings) string { return config.UploadToken }) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	api.mu.Lock()
+	settings := api.settings
+	api.mu.Unlock()
+	config := watchConfig(settings)
+
+	folder := folderForInbox(config.UploadInbox, settings)
+	var name string
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		name, err = saveUpload(r, folder)
+	} else {
+		var date time.Time
+		if date, err = uploadDate(r.URL.Query().Get("date")); err == nil {
+			name, err = storeUpload(http.MaxBytesReader(w, r.Body, maxUploadSize), date, folder)
+		}
+	}
+	if err != nil {
+		logWarnf("unable to save upload: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logInfof("received %s", name)
+
+	select {
+	case api.uploaded <- struct{}{}:
+	default:
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"name": name})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// vaultIndexSettings configure hashing the photos already in the vault, so
+// photos placed there by hand are not imported a second time. The paths
+// default to the target photo path.
+type vaultIndexSettings struct {
+	Paths []string `yaml:"paths"`
+}
+
+var (
+	vaultFilesBucket  = []byte("vault_files")
+	vaultHashesBucket = []byte("vault_hashes")
+)
+
+// vaultFile is the cached hash of a photo in the vault, valid while its
+// size and modification time stay the same.
+type vaultFile struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+var vaultImageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".heic": true, ".heif": true, ".webp": true, ".gif": true,
+}
+
+// vaultIndexed tells whether the index was updated during this import.
+var vaultIndexed bool
+
+func resetVaultIndex() {
+	vaultIndexed = false
+}
+
+// updateVaultIndex hashes the photos in the vault paths that are new or
+// changed since the last import and forgets the ones that are gone. The
+// source folders are left out, even inside the vault.
+func updateVaultIndex(db *bolt.DB, settings *appSettings) {
+	if vaultIndexed {
+		return
+	}
+	vaultIndexed = true
+
+	roots := settings.VaultIndex.Paths
+	if len(roots) == 0 {
+		roots = []string{settings.TargetPhotoPath}
+	}
+	skipped := make(map[string]bool)
+	for _, folder := range sourceFolders(settings) {
+		skipped[path.Clean(folder.OriginalPhotoPath)] = true
+		if settings.Travel != nil {
+			skipped[path.Clean(travelCollectPath(folder))] = true
+		}
+	}
+	if settings.QuarantinePath != "" {
+		skipped[path.Clean(settings.QuarantinePath)] = true
+	}
+
+	cached := make(map[string]vaultFile)
+	db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(vaultFilesBucket); bucket != nil {
+			bucket.ForEach(func(key []byte, data []byte) error {
+				var file vaultFile
+				if json.Unmarshal(data, &file) == nil {
+					cached[string(key)] = file
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	files := make(map[string]vaultFile)
+	hashed := 0
+	for _, root := range roots {
+		err := filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				if filePath != root && (strings.HasPrefix(entry.Name(), ".") || skipped[path.Clean(filePath)]) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !vaultImageExtensions[strings.ToLower(path.Ext(entry.Name()))] || skipped[path.Dir(filePath)] {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if file, ok := cached[filePath]; ok && file.Size == info.Size() && file.ModTime.Equal(info.ModTime()) {
+				files[filePath] = file
+				return nil
+			}
+			hash, err := fileSHA256(filePath)
+			if err != nil {
+				logWarnf("unable to index %s: %s", filePath, err)
+				return nil
+			}
+			files[filePath] = vaultFile{Hash: hash, Size: info.Size(), ModTime: info.ModTime()}
+			hashed++
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			logErrorf("unable to index the photos in %s: %s", root, err)
+		}
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{vaultFilesBucket, vaultHashesBucket} {
+			if tx.Bucket(name) != nil {
+				if err := tx.DeleteBucket(name); err != nil {
+					return err
+				}
+			}
+		}
+		filesBucket, err := tx.CreateBucket(vaultFilesBucket)
+		if err != nil {
+			return err
+		}
+		hashesBucket, err := tx.CreateBucket(vaultHashesBucket)
+		if err != nil {
+			return err
+		}
+		for filePath, file := range files {
+			data, err := json.Marshal(file)
+			if err != nil {
+				return err
+			}
+			if err := filesBucket.Put([]byte(filePath), data); err != nil {
+				return err
+			}
+			if err := hashesBucket.Put([]byte(file.Hash), []byte(filePath)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logErrorf("unable to store the vault index: %s", err)
+		return
+	}
+	logDebugf("indexed %d photos in the vault, hashed %d", len(files), hashed)
+}
+
+// vaultCopy returns the photo in the vault with the hash, if there is one.
+func vaultCopy(tx *bolt.Tx, hash string) string {
+	bucket := tx.Bucket(vaultHashesBucket)
+	if bucket == nil {
+		return ""
+	}
+	return string(bucket.Get([]byte(hash)))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	verifyAttempts = 3
+	verifyDelay    = 2 * time.Second
+)
+
+// verifyNoteWrite re-reads a note after the block was appended and checks
+// that the block is still there and the note is well-formed. A sync client
+// may replace the note with an older version right after the write, so the
+// block is appended again when it has gone missing.
+func verifyNoteWrite(diaryFilePath string, content string, sizeBefore int64, settings *appSettings) {
+	for attempt := 1; attempt <= verifyAttempts; attempt++ {
+		time.Sleep(verifyDelay)
+
+		err := checkNoteWrite(diaryFilePath, content, sizeBefore)
+		if err == nil {
+			return
+		}
+		logFields{"note": diaryFilePath}.warnf("verification of %s failed (attempt %d/%d): %s", diaryFilePath, attempt, verifyAttempts, err)
+
+		if data, readErr := os.ReadFile(diaryFilePath); readErr == nil && !strings.Contains(string(data), content) {
+			if info, statErr := os.Stat(diaryFilePath); statErr == nil {
+				sizeBefore = info.Size()
+			}
+			if err := appendToNote(diaryFilePath, content, settings); err != nil {
+				logFields{"note": diaryFilePath}.errorf("unable to append the block again: %s", err)
+			}
+		}
+	}
+
+	logFields{"note": diaryFilePath}.errorf("%s is still not as expected after %d attempts, check the note manually", diaryFilePath, verifyAttempts)
+}
+
+func checkNoteWrite(diaryFilePath string, content string, sizeBefore int64) error {
+	data, err := os.ReadFile(diaryFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to read the note: %v", err)
+	}
+	note := string(data)
+
+	if !strings.Contains(note, content) {
+		return fmt.Errorf("the inserted block is missing")
+	}
+	if int64(len(data)) < sizeBefore+int64(len(content)) {
+		return fmt.Errorf("the note is shorter than expected")
+	}
+	if strings.HasPrefix(note, "---\n") && !strings.Contains(note[4:], "\n---") && !strings.HasPrefix(note[4:], "---") {
+		return fmt.Errorf("the frontmatter is not closed")
+	}
+	return nil
+}
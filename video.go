@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// videoSettings enable importing video clips named like the photos.
+// Videos over max_embed_mb are linked instead of embedded, and videos over
+// max_mb are left in the original photo path.
+type videoSettings struct {
+	MaxEmbedMB int64 `yaml:"max_embed_mb"`
+	MaxMB      int64 `yaml:"max_mb"`
+}
+
+var videoFilePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})(-\d{2})?\.(mp4|mov|m4v|webm)$`)
+
+// videoExtension returns the normalized extension of a supported video
+// file or an empty string when the file is not a supported video.
+func videoExtension(name string) string {
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(name), "."))
+	switch ext {
+	case "mp4", "mov", "m4v", "webm":
+		return ext
+	}
+	return ""
+}
+
+// skipLargeVideo tells whether the video is over the size limit and should
+// not be imported.
+func skipLargeVideo(filePath string, settings *appSettings) bool {
+	if settings.Videos.MaxMB <= 0 || videoExtension(filePath) == "" {
+		return false
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || info.Size() <= settings.Videos.MaxMB*1024*1024 {
+		return false
+	}
+	logFields{"source": filePath}.infof("skipped %s, it is larger than %d MB", filePath, settings.Videos.MaxMB)
+	return true
+}
+
+// linkOnlyVideo tells whether the video is too large to be embedded.
+func linkOnlyVideo(filePath string, settings *appSettings) bool {
+	if settings.Videos == nil || settings.Videos.MaxEmbedMB <= 0 || videoExtension(filePath) == "" {
+		return false
+	}
+	info, err := os.Stat(filePath)
+	return err == nil && info.Size() > settings.Videos.MaxEmbedMB*1024*1024
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// wasmMemoryLimitPages limits the memory of a WASM plugin to 256 MB.
+const wasmMemoryLimitPages = 4096
+
+// wasmCache keeps the compiled plugins so a module is compiled once per run
+// instead of once per photo.
+var wasmCache = wazero.NewCompilationCache()
+
+// runWASMPlugin runs the WASI module of the plugin with the request on
+// stdin and returns what it wrote to stdout. The module gets no file
+// system, environment, network or real clock, so it only sees the request.
+func runWASMPlugin(ctx context.Context, plugin pluginSettings, input []byte) ([]byte, error) {
+	code, err := os.ReadFile(plugin.WASM)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read plugin %s: %v", plugin.Name, err)
+	}
+
+	config := wazero.NewRuntimeConfig().
+		WithCompilationCache(wasmCache).
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(wasmMemoryLimitPages)
+	runtime := wazero.NewRuntimeWithConfig(ctx, config)
+	defer runtime.Close(ctx)
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		return nil, fmt.Errorf("unable to set up WASI for plugin %s: %v", plugin.Name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	moduleConfig := wazero.NewModuleConfig().
+		WithName(plugin.Name).
+		WithArgs(plugin.Name).
+		WithStdin(bytes.NewReader(input)).
+		WithStdout(&stdout).
+		WithStderr(&stderr)
+	_, err = runtime.InstantiateWithConfig(ctx, code, moduleConfig)
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed: %v: %s", plugin.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettings configure the watch command. File system events start an
+// import once they have settled for the debounce time, and an import also
+// runs every poll interval for network file systems that don't deliver
+// events and for the remote sources. The API on the listen address
+// reports the health and status, with the API token switches profiles
+// without a restart and, with an upload token, takes uploaded photos into
+// the upload inbox, a source folder that is the original photo path by
+// default.
+type watchSettings struct {
+	PollIntervalSeconds int    `yaml:"poll_interval_seconds"`
+	DebounceSeconds     int    `yaml:"debounce_seconds"`
+	DisableEvents       bool   `yaml:"disable_events"`
+	Listen              string `yaml:"listen"`
+	APIToken            string `yaml:"api_token"`
+	UploadToken         string `yaml:"upload_token"`
+	UploadInbox         string `yaml:"upload_inbox"`
+}
+
+// runWatch imports right away and then keeps importing whenever photos
+// arrive in a source folder. Reloaded settings are used from the next
+// import on.
+func runWatch(settings *appSettings, outputFormat string, args []string) {
+	config := watchConfig(settings)
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	poll := flags.Int("poll", config.PollIntervalSeconds, "Seconds between polling imports, 0 to disable polling")
+	debounce := flags.Int("debounce", config.DebounceSeconds, "Seconds the file system events must settle before an import")
+	flags.Parse(args)
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var watcher *fsnotify.Watcher
+	events := make(chan fsnotify.Event)
+	if !config.DisableEvents {
+		var err error
+		watcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			logWarnf("unable to watch for file system events, polling only: %s", err)
+			watcher = nil
+		} else {
+			defer watcher.Close()
+			watchSourceFolders(watcher, settings)
+			events = watcher.Events
+			go func() {
+				for err := range watcher.Errors {
+					logWarnf("file system watch error: %s", err)
+				}
+			}()
+		}
+	}
+
+	var ticker *time.Ticker
+	var ticks <-chan time.Time
+	startPolling := func() {
+		if ticker != nil {
+			ticker.Stop()
+			ticker, ticks = nil, nil
+		}
+		if *poll > 0 {
+			ticker = time.NewTicker(time.Duration(*poll) * time.Second)
+			ticks = ticker.C
+		}
+	}
+	startPolling()
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	// The timer is stopped until an event arrives, and every event resets it
+	// so photos synced in a burst are imported together.
+	settle := time.NewTimer(time.Hour)
+	settle.Stop()
+
+	var api *profileAPI
+	if config.Listen != "" {
+		api = serveProfileAPI(config.Listen, settings)
+	}
+
+	// switchSettings starts using new settings, watching their source
+	// folders and polling at their interval unless the flags set it.
+	switchSettings := func(switched *appSettings) {
+		if watcher != nil {
+			for _, folder := range sourceFolders(settings) {
+				watcher.Remove(folder.OriginalPhotoPath)
+			}
+			watchSourceFolders(watcher, switched)
+		}
+		switchedConfig := watchConfig(switched)
+		if switchedConfig.Listen != config.Listen {
+			logWarnf("the watch API keeps listening on %s, a new listen address needs a restart", config.Listen)
+		}
+		if !given["debounce"] {
+			*debounce = switchedConfig.DebounceSeconds
+		}
+		if !given["poll"] && switchedConfig.PollIntervalSeconds != *poll {
+			*poll = switchedConfig.PollIntervalSeconds
+			startPolling()
+		}
+		if err := configureEvents(switched); err != nil {
+			logErrorf("unable to subscribe to the events: %s", err)
+		}
+		api.use(switched)
+		useDumpSettings(switched)
+		settings = switched
+	}
+
+	// A reload waits for the next import, so an import that is due runs
+	// with the settings it was due with.
+	reloads := settingsReloads(settings.settingsFile)
+	var reloaded *appSettings
+	importNext := func() {
+		if reloaded != nil {
+			switchSettings(reloaded)
+			reloaded = nil
+			logInfof("using the reloaded settings")
+		}
+		importWithLock(settings, outputFormat)
+	}
+
+	defer func() { stopImportStatus(settings) }()
+	importWithLock(settings, outputFormat)
+	for {
+		select {
+		case <-shutdownRequested():
+			return
+		case <-reloads:
+			if next, ok := reloadSettings(settings); ok {
+				reloaded = next
+			}
+		case switched := <-api.profileSwitches():
+			logInfof("switched to profile %s", switched.Profile)
+			reloaded = nil
+			switchSettings(switched)
+			importWithLock(settings, outputFormat)
+		case <-api.uploads():
+			settle.Reset(time.Duration(*debounce) * time.Second)
+		case event := <-events:
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) == 0 {
+				continue
+			}
+			settle.Reset(time.Duration(*debounce) * time.Second)
+		case <-settle.C:
+			importNext()
+		case <-ticks:
+			importNext()
+		}
+	}
+}
+
+// watchConfig returns the watch settings with the defaults filled in.
+func watchConfig(settings *appSettings) watchSettings {
+	config := watchSettings{}
+	if settings.Watch != nil {
+		config = *settings.Watch
+	}
+	if config.PollIntervalSeconds <= 0 {
+		config.PollIntervalSeconds = 300
+	}
+	if config.DebounceSeconds <= 0 {
+		config.DebounceSeconds = 5
+	}
+	return config
+}
+
+func watchSourceFolders(watcher *fsnotify.Watcher, settings *appSettings) {
+	for _, folder := range sourceFolders(settings) {
+		if err := watcher.Add(folder.OriginalPhotoPath); err != nil {
+			logWarnf("unable to watch %s, polling only: %s", folder.OriginalPhotoPath, err)
+		}
+	}
+}
+
+// importWithLock runs an import unless another instance holds the lock, in
+// which case the photos are left for the next import.
+func importWithLock(settings *appSettings, outputFormat string) {
+	lock, err := lockInstance(settings)
+	if err != nil {
+		logWarnf("skipping the import: %s", err)
+		return
+	}
+	defer lock.release()
+	runImport(settings, outputFormat)
+}
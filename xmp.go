@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// xmpSettings configure reading the XMP sidecars that Lightroom, darktable
+// and others write next to photos. An existing sidecar is moved along with
+// its photo as <attachment>.xmp, and with write enabled a minimal one is
+// created for photos that have none.
+type xmpSettings struct {
+	Captions bool `yaml:"captions"`
+	Write    bool `yaml:"write"`
+}
+
+// xmpSidecar is the metadata read from the sidecar of a photo.
+type xmpSidecar struct {
+	Path     string
+	Rating   int
+	Caption  string
+	Captions map[string]string
+	Keywords []string
+}
+
+const (
+	xmpNamespace = "http://ns.adobe.com/xap/1.0/"
+	dcNamespace  = "http://purl.org/dc/elements/1.1/"
+	rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+const xmpPacket = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/"
+    xmp:CreateDate="%s"
+    xmpMM:PreservedFileName="%s"/>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+
+const xmpCaptionPacket = `<?xpacket begin="` + "\ufeff" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/">
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">%s</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>
+`
+
+// readXMPSidecars reads the sidecars of the checked photos. Photos without
+// a sidecar are left out of the result.
+func readXMPSidecars(photos map[string][]string, renamed map[string]string) map[string]*xmpSidecar {
+	sidecars := make(map[string]*xmpSidecar)
+	for _, datePhotos := range photos {
+		for _, photo := range datePhotos {
+			sidecarPath := findXMPSidecar(photo, originalName(photo, renamed))
+			if sidecarPath == "" {
+				continue
+			}
+			sidecar, err := readXMPSidecar(sidecarPath)
+			if err != nil {
+				logErrorf("unable to read XMP sidecar %s: %s", sidecarPath, err)
+				continue
+			}
+			sidecars[photo] = sidecar
+		}
+	}
+	return sidecars
+}
+
+// findXMPSidecar looks for both photo.jpg.xmp and photo.xmp next to the
+// photo, under its current and its original name.
+func findXMPSidecar(photo string, original string) string {
+	dir := path.Dir(photo)
+	for _, name := range []string{original, path.Base(photo)} {
+		base := strings.TrimSuffix(name, path.Ext(name))
+		for _, candidate := range []string{name + ".xmp", name + ".XMP", base + ".xmp", base + ".XMP"} {
+			if fileExists(path.Join(dir, candidate)) {
+				return path.Join(dir, candidate)
+			}
+		}
+	}
+	return ""
+}
+
+func readXMPSidecar(sidecarPath string) (*xmpSidecar, error) {
+	f, err := os.Open(sidecarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sidecar := &xmpSidecar{Path: sidecarPath, Captions: make(map[string]string)}
+	decoder := xml.NewDecoder(f)
+	inRating, inDescription, inCaption := false, false, false
+	inSubject, inKeyword := false, false
+	captionLangs := make([]string, 0)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			sidecar.Caption = sidecar.Captions["x-default"]
+			if sidecar.Caption == "" && len(captionLangs) > 0 {
+				sidecar.Caption = sidecar.Captions[captionLangs[0]]
+			}
+			return sidecar, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XMP: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if attr.Name.Space == xmpNamespace && attr.Name.Local == "Rating" {
+					sidecar.Rating = parseXMPRating(attr.Value)
+				}
+			}
+			switch {
+			case t.Name.Space == xmpNamespace && t.Name.Local == "Rating":
+				inRating = true
+			case t.Name.Space == dcNamespace && t.Name.Local == "description":
+				inDescription = true
+			case t.Name.Space == dcNamespace && t.Name.Local == "subject":
+				inSubject = true
+			case inDescription && t.Name.Space == rdfNamespace && t.Name.Local == "li":
+				inCaption = true
+				lang := "x-default"
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "lang" {
+						lang = attr.Value
+					}
+				}
+				captionLangs = append(captionLangs, lang)
+			case inSubject && t.Name.Space == rdfNamespace && t.Name.Local == "li":
+				inKeyword = true
+				sidecar.Keywords = append(sidecar.Keywords, "")
+			}
+		case xml.EndElement:
+			switch {
+			case t.Name.Space == xmpNamespace && t.Name.Local == "Rating":
+				inRating = false
+			case t.Name.Space == dcNamespace && t.Name.Local == "description":
+				inDescription = false
+			case t.Name.Space == dcNamespace && t.Name.Local == "subject":
+				inSubject = false
+			case t.Name.Space == rdfNamespace && t.Name.Local == "li":
+				inCaption = false
+				inKeyword = false
+			}
+		case xml.CharData:
+			if inRating {
+				sidecar.Rating = parseXMPRating(string(t))
+			}
+			if inCaption {
+				sidecar.Captions[captionLangs[len(captionLangs)-1]] += string(t)
+			}
+			if inKeyword {
+				sidecar.Keywords[len(sidecar.Keywords)-1] += string(t)
+			}
+		}
+	}
+}
+
+func parseXMPRating(value string) int {
+	rating, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0
+	}
+	return rating
+}
+
+// xmpCaption returns the caption of a photo cleaned up for an Obsidian link
+// alias, or an empty string when the photo has no caption.
+func xmpCaption(photo string, sidecars map[string]*xmpSidecar, settings *appSettings) string {
+	if settings.XMP == nil || !settings.XMP.Captions {
+		return ""
+	}
+	sidecar, ok := sidecars[photo]
+	if !ok {
+		return ""
+	}
+	return cleanCaption(localizedCaption(sidecar, settings))
+}
+
+// cleanCaption puts the caption on one line and replaces the characters
+// that would break an Obsidian link alias.
+func cleanCaption(caption string) string {
+	caption = strings.Join(strings.Fields(caption), " ")
+	return strings.NewReplacer("[", "(", "]", ")", "|", "/").Replace(caption)
+}
+
+// moveXMPSidecars moves the sidecars of moved photos next to their targets
+// or writes new ones. Sidecars are left alone for encrypted attachments so
+// captions do not end up next to them in plain text.
+func moveXMPSidecars(photos []string, targets []string, sidecars map[string]*xmpSidecar, renamed map[string]string, settings *appSettings) {
+	if settings.Encryption != nil {
+		return
+	}
+	for i, photo := range photos {
+		target := targets[i] + ".xmp"
+		if sidecar, ok := sidecars[photo]; ok {
+			transfer := moveFile
+			if settings.SourceReadOnly {
+				transfer = copyFile
+			}
+			if err := transfer(sidecar.Path, target); err != nil {
+				logErrorf("unable to move XMP sidecar %s: %s", sidecar.Path, err)
+			}
+			continue
+		}
+		if !settings.XMP.Write {
+			continue
+		}
+		if err := writeXMPSidecar(targets[i], originalName(photo, renamed), target); err != nil {
+			logFields{"target": target}.errorf("unable to write XMP sidecar %s: %s", target, err)
+		}
+	}
+}
+
+// writeXMPCaption writes a sidecar with only the caption of a photo,
+// for the sources that receive captions along with the photos.
+func writeXMPCaption(target string, caption string) error {
+	content := fmt.Sprintf(xmpCaptionPacket, xmlEscape(caption))
+	if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %v", target, err)
+	}
+	return nil
+}
+
+func writeXMPSidecar(attachment string, original string, target string) error {
+	info, err := os.Stat(attachment)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %v", attachment, err)
+	}
+	content := fmt.Sprintf(xmpPacket, info.ModTime().Format(time.RFC3339), xmlEscape(original))
+	if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %v", target, err)
+	}
+	return nil
+}